@@ -0,0 +1,343 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"parte3/internal/auth"
+	"parte3/internal/user"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+)
+
+// apiKeyHeader is an alternative to "Authorization: Bearer <key>".
+const apiKeyHeader = "X-API-Key"
+
+// ctxPrincipal is the gin context key holding the authenticated *auth.Principal.
+const ctxPrincipal = "principal"
+
+// mimeProblem is the content type of RFC 7807 problem responses.
+const mimeProblem = "application/problem+json"
+
+// problemDetails is an RFC 7807 error response.
+type problemDetails struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// problem aborts the request with an RFC 7807 response.
+func problem(ctx *gin.Context, status int, detail string) {
+	body, _ := json.Marshal(problemDetails{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+	ctx.Data(status, mimeProblem, body)
+	ctx.Abort()
+}
+
+// presentedCredential extracts the bearer token or API key of the request.
+func presentedCredential(ctx *gin.Context) string {
+	if authz := ctx.GetHeader("Authorization"); authz != "" {
+		scheme, credentials, ok := strings.Cut(authz, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(credentials)
+		}
+		return ""
+	}
+	return ctx.GetHeader(apiKeyHeader)
+}
+
+// looksLikeJWT tells tokens (header.payload.signature) from API keys.
+func looksLikeJWT(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+// currentPrincipal returns who authenticated the request, if anyone.
+func currentPrincipal(ctx *gin.Context) *auth.Principal {
+	if v, ok := ctx.Get(ctxPrincipal); ok {
+		return v.(*auth.Principal)
+	}
+	return nil
+}
+
+// authenticate rejects requests without a valid API key or bearer token
+// with 401 and stores the principal in the context. The tokens of users
+// take the role the user has now, not the one it had when the token was
+// issued. It does not call ctx.Next so it can also run in the chains
+// dispatched by customMethods.
+func authenticate(keys *auth.Service, tokens *auth.TokenService, users *user.Service, logger *zap.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		credential := presentedCredential(ctx)
+		if credential == "" {
+			ctx.Header("WWW-Authenticate", `Bearer realm="api"`)
+			problem(ctx, http.StatusUnauthorized, "missing credentials")
+			return
+		}
+
+		if looksLikeJWT(credential) {
+			principal, err := tokens.Verify(credential)
+			if err != nil {
+				ctx.Header("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+				problem(ctx, http.StatusUnauthorized, err.Error())
+				return
+			}
+			// los tokens de API keys mueren con la clave
+			if err := keys.CheckToken(principal); err != nil {
+				rejectKey(ctx, logger, err)
+				return
+			}
+			// y los de usuarios con el usuario, que además pudo cambiar de rol
+			if principal.Kind == auth.SubjectUser {
+				u, err := users.Get(ctx.Request.Context(), principal.Subject)
+				if err != nil {
+					rejectUser(ctx, logger, err)
+					return
+				}
+				principal.Role = u.Role
+			}
+			ctx.Set(ctxPrincipal, principal)
+			return
+		}
+
+		key, err := keys.Authenticate(credential)
+		if err != nil {
+			rejectKey(ctx, logger, err)
+			return
+		}
+		ctx.Set(ctxPrincipal, auth.KeyPrincipal(key))
+	}
+}
+
+// rejectKey replies 401 to an invalid or revoked API key and 500 to any
+// other error looking it up.
+func rejectKey(ctx *gin.Context, logger *zap.Logger, err error) {
+	if !errors.Is(err, auth.ErrInvalidKey) && !errors.Is(err, auth.ErrRevokedKey) {
+		requestLogger(ctx, logger).Error("error authenticating api key", zap.Error(err))
+		problem(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.Header("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+	problem(ctx, http.StatusUnauthorized, err.Error())
+}
+
+// rejectUser replies 401 to the token of a user that no longer exists and
+// errorStatus to any other error looking it up.
+func rejectUser(ctx *gin.Context, logger *zap.Logger, err error) {
+	if !errors.Is(err, user.ErrNotFound) {
+		requestLogger(ctx, logger).Error("error authenticating user token", zap.Error(err))
+		problem(ctx, errorStatus(err), err.Error())
+		return
+	}
+	ctx.Header("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+	problem(ctx, http.StatusUnauthorized, "the user of the token no longer exists")
+}
+
+// requirePermission rejects with 403 the requests whose principal has none
+// of perms. Handlers of routes that accept an ":own" permission must still
+// check the owner with auth.AuthorizeOwn. Must run after authenticate.
+func requirePermission(perms ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal := currentPrincipal(ctx)
+		if principal == nil {
+			problem(ctx, http.StatusUnauthorized, "missing credentials")
+			return
+		}
+		for _, perm := range perms {
+			if principal.Can(perm) {
+				return
+			}
+		}
+		problem(ctx, http.StatusForbidden, "missing permission "+strings.Join(perms, " or "))
+	}
+}
+
+// deny replies 403 if err is an authorization error and reports whether
+// the request was rejected.
+func deny(ctx *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	problem(ctx, http.StatusForbidden, err.Error())
+	return true
+}
+
+//HANDLER PARA API KEYS
+
+// handleIssueKey handles POST /auth/keys
+func (h *handler) handleIssueKey(ctx *gin.Context) {
+	var req auth.IssueKeyRequest
+	if err := bindBody(ctx, &req); err != nil {
+		respond(ctx, bindStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	issued, err := h.authService.Issue(req.Name, req.Scopes)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidScope) {
+			respond(ctx, http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.log(ctx).Error("error issuing api key", zap.Error(err))
+		serverError(ctx, err)
+		return
+	}
+	h.log(ctx).Info("api key issued", zap.String("id", issued.ID), zap.Strings("scopes", issued.Scopes))
+	respond(ctx, http.StatusCreated, issued)
+}
+
+// handleListKeys handles GET /auth/keys
+func (h *handler) handleListKeys(ctx *gin.Context) {
+	keys, err := h.authService.List()
+	if err != nil {
+		h.log(ctx).Error("error listing api keys", zap.Error(err))
+		serverError(ctx, err)
+		return
+	}
+	respondList(ctx, http.StatusOK, listing[*auth.APIKey]{
+		Body:    keys,
+		XMLBody: newXMLList("api_keys", keys),
+		Items:   keys,
+	})
+}
+
+// handleRotateKey handles POST /auth/keys/:id/rotate
+func (h *handler) handleRotateKey(ctx *gin.Context) {
+	id := ctx.Param("id")
+	issued, err := h.authService.Rotate(id)
+	if err != nil {
+		h.respondKeyError(ctx, id, err)
+		return
+	}
+	h.log(ctx).Info("api key rotated", zap.String("id", id))
+	respond(ctx, http.StatusOK, issued)
+}
+
+// handleRevokeKey handles DELETE /auth/keys/:id
+func (h *handler) handleRevokeKey(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if _, err := h.authService.Revoke(id); err != nil {
+		h.respondKeyError(ctx, id, err)
+		return
+	}
+	h.log(ctx).Info("api key revoked", zap.String("id", id))
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *handler) respondKeyError(ctx *gin.Context, id string, err error) {
+	switch {
+	case errors.Is(err, auth.ErrNotFound):
+		respond(ctx, http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrAlreadyRevoked):
+		respond(ctx, http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.log(ctx).Error("error updating api key", zap.String("id", id), zap.Error(err))
+		serverError(ctx, err)
+	}
+}
+
+//HANDLER PARA TOKENS
+
+// tokenRequest is the payload of POST /auth/token, following the OAuth 2.0
+// password and client_credentials grants.
+type tokenRequest struct {
+	GrantType    string `json:"grant_type" xml:"grant_type" form:"grant_type" binding:"required,oneof=password client_credentials"`
+	Username     string `json:"username" xml:"username" form:"username"`
+	Password     string `json:"password" xml:"password" form:"password"`
+	ClientSecret string `json:"client_secret" xml:"client_secret" form:"client_secret"` // API key
+}
+
+// tokenResponse is the OAuth 2.0 access token response.
+type tokenResponse struct {
+	AccessToken string `json:"access_token" xml:"access_token"`
+	TokenType   string `json:"token_type" xml:"token_type"`
+	ExpiresIn   int    `json:"expires_in" xml:"expires_in"`
+	Scope       string `json:"scope" xml:"scope"`
+}
+
+// oauthError replies with an OAuth 2.0 error response.
+func oauthError(ctx *gin.Context, status int, code string, description string) {
+	respond(ctx, status, gin.H{"error": code, "error_description": description})
+}
+
+// handleIssueToken handles POST /auth/token
+// Users exchange their login and password (grant_type=password) and
+// machines an API key (grant_type=client_credentials) for a signed JWT.
+func (h *handler) handleIssueToken(ctx *gin.Context) {
+	var req tokenRequest
+	var err error
+	if ctx.ContentType() == binding.MIMEPOSTForm {
+		err = ctx.ShouldBindWith(&req, binding.Form)
+	} else {
+		err = bindBody(ctx, &req)
+	}
+	if err != nil {
+		oauthError(ctx, bindStatus(err), "invalid_request", err.Error())
+		return
+	}
+
+	var principal *auth.Principal
+	switch req.GrantType {
+	case "password":
+		if h.passwordVerifier == nil {
+			oauthError(ctx, http.StatusBadRequest, "unsupported_grant_type", "password grant is not available")
+			return
+		}
+		principal, err = h.passwordVerifier.VerifyPassword(ctx.Request.Context(), req.Username, req.Password)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				oauthError(ctx, http.StatusUnauthorized, "invalid_grant", err.Error())
+				return
+			}
+			h.log(ctx).Error("error verifying password", zap.Error(err))
+			oauthError(ctx, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+	case "client_credentials":
+		key, err := h.authService.Authenticate(req.ClientSecret)
+		if err != nil {
+			oauthError(ctx, http.StatusUnauthorized, "invalid_client", err.Error())
+			return
+		}
+		principal = auth.KeyPrincipal(key)
+	}
+
+	token, expiresAt, err := h.tokenService.Issue(principal)
+	if err != nil {
+		h.log(ctx).Error("error issuing token", zap.Error(err))
+		oauthError(ctx, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	h.log(ctx).Info("token issued", zap.String("sub", principal.Subject), zap.String("sub_type", principal.Kind))
+	ctx.Header("Cache-Control", "no-store")
+	respond(ctx, http.StatusOK, tokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(time.Until(expiresAt).Seconds()),
+		Scope:       strings.Join(principal.Scopes, " "),
+	})
+}
+
+// handleJWKS handles GET /.well-known/jwks.json
+func (h *handler) handleJWKS(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.tokenService.Keys().Public())
+}
+
+// handleRotateSigningKey handles POST /auth/jwks/rotate
+func (h *handler) handleRotateSigningKey(ctx *gin.Context) {
+	kid, err := h.tokenService.Keys().Rotate()
+	if err != nil {
+		h.log(ctx).Error("error rotating signing key", zap.Error(err))
+		serverError(ctx, err)
+		return
+	}
+	h.log(ctx).Info("signing key rotated", zap.String("kid", kid))
+	respond(ctx, http.StatusOK, gin.H{"kid": kid})
+}
@@ -0,0 +1,350 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"parte3/internal/logging"
+	"parte3/internal/sale"
+	"parte3/internal/user"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+)
+
+// maxBatchItems bounds how many items a single batch request may contain.
+const maxBatchItems = 10000
+
+// Modos de ejecución de un batch.
+const (
+	batchAllOrNothing = "all_or_nothing"
+	batchBestEffort   = "best_effort"
+)
+
+// Códigos de error por item de un batch.
+const (
+	codeInvalidJSON      = "invalid_json"
+	codeValidationFailed = "validation_failed"
+	codeUserNotFound     = "user_not_found"
+	codeInvalidAmount    = "invalid_amount"
+	codeAborted          = "aborted"
+	codeConflict         = "conflict"
+	codeTimeout          = "timeout"
+	codeInternalError    = "internal_error"
+)
+
+// errBatchTooLarge is returned when the batch exceeds maxBatchItems.
+var errBatchTooLarge = fmt.Errorf("batch exceeds the maximum of %d items", maxBatchItems)
+
+// errEmptyBatch is returned when the batch has no items.
+var errEmptyBatch = errors.New("batch has no items")
+
+// batchItemResult is the outcome of a single item of a batch request.
+type batchItemResult struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Data   any    `json:"data,omitempty"`
+}
+
+// batchResponse is returned by every batch endpoint.
+type batchResponse struct {
+	Mode    string            `json:"mode"`
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+	Results []batchItemResult `json:"results"`
+}
+
+// customMethods maps "METHOD /path:verb" routes that gin cannot register
+// because of the colon, they are dispatched from the NoRoute handler.
+// The handlers of a chain must not call ctx.Next, the chain stops as soon as
+// one of them aborts.
+type customMethods map[string]gin.HandlersChain
+
+// handle dispatches the request to the matching custom method or replies 404.
+func (m customMethods) handle(ctx *gin.Context) {
+	if chain, ok := m[ctx.Request.Method+" "+ctx.Request.URL.Path]; ok {
+		ctx.Set(routeKey, ctx.Request.URL.Path)
+		for _, fn := range chain {
+			fn(ctx)
+			if ctx.IsAborted() {
+				return
+			}
+		}
+		return
+	}
+	ctx.JSON(http.StatusNotFound, gin.H{"error": "route not found"})
+}
+
+// batchMode reads the mode query parameter, all_or_nothing by default.
+func batchMode(ctx *gin.Context) (string, error) {
+	switch mode := ctx.DefaultQuery("mode", batchAllOrNothing); mode {
+	case batchAllOrNothing, batchBestEffort:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid batch mode %q, must be %s or %s", mode, batchAllOrNothing, batchBestEffort)
+	}
+}
+
+// readBatch splits the body in raw items. It accepts a JSON array or, when
+// the content type is application/x-ndjson, one JSON document per line.
+func readBatch(ctx *gin.Context) ([]json.RawMessage, error) {
+	if strings.HasPrefix(ctx.ContentType(), "application/x-ndjson") {
+		var items []json.RawMessage
+		scanner := bufio.NewScanner(ctx.Request.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if len(items) == maxBatchItems {
+				return nil, errBatchTooLarge
+			}
+			items = append(items, json.RawMessage(line))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return nil, errEmptyBatch
+		}
+		return items, nil
+	}
+
+	dec := json.NewDecoder(ctx.Request.Body)
+	tok, err := dec.Token()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errEmptyBatch
+		}
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, errors.New("batch body must be a JSON array")
+	}
+	var items []json.RawMessage
+	for dec.More() {
+		if len(items) == maxBatchItems {
+			return nil, errBatchTooLarge
+		}
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return nil, errEmptyBatch
+	}
+	return items, nil
+}
+
+// decodeBatchItem unmarshals and validates one item with the same binding
+// rules used by the single item endpoints.
+func decodeBatchItem(raw json.RawMessage, dst any) *batchItemResult {
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return &batchItemResult{Status: http.StatusBadRequest, Code: codeInvalidJSON, Error: err.Error()}
+	}
+	if err := binding.Validator.ValidateStruct(dst); err != nil {
+		return &batchItemResult{Status: http.StatusBadRequest, Code: codeValidationFailed, Error: err.Error()}
+	}
+	return nil
+}
+
+// batchItemError converts a service error into an item result.
+func batchItemError(err error) batchItemResult {
+	switch {
+	case errors.Is(err, user.ErrBatchAborted), errors.Is(err, sale.ErrBatchAborted):
+		return batchItemResult{Status: http.StatusConflict, Code: codeAborted, Error: err.Error()}
+	case errors.Is(err, sale.ErrUserNotFound):
+		return batchItemResult{Status: http.StatusNotFound, Code: codeUserNotFound, Error: err.Error()}
+	case errors.Is(err, sale.ErrInvalidAmount):
+		return batchItemResult{Status: http.StatusBadRequest, Code: codeInvalidAmount, Error: err.Error()}
+	case errors.Is(err, user.ErrInvalidAddress):
+		return batchItemResult{Status: http.StatusBadRequest, Code: codeValidationFailed, Error: err.Error()}
+	case errors.Is(err, user.ErrConflict):
+		return batchItemResult{Status: http.StatusConflict, Code: codeConflict, Error: err.Error()}
+	case errors.Is(err, context.DeadlineExceeded):
+		return batchItemResult{Status: http.StatusGatewayTimeout, Code: codeTimeout, Error: err.Error()}
+	default:
+		return batchItemResult{Status: errorStatus(err), Code: codeInternalError, Error: err.Error()}
+	}
+}
+
+// abortedResult marks a valid item that was skipped in all_or_nothing mode.
+func abortedResult(index int) batchItemResult {
+	r := batchItemError(user.ErrBatchAborted)
+	r.Index = index
+	return r
+}
+
+// tally counts the created and failed items. Aborted items are neither.
+func (resp *batchResponse) tally() {
+	resp.Created, resp.Failed = 0, 0
+	for _, r := range resp.Results {
+		if r.Status == http.StatusCreated {
+			resp.Created++
+		} else if r.Code != codeAborted {
+			resp.Failed++
+		}
+	}
+}
+
+// status is 201 when every item was created, 422 when an all_or_nothing
+// batch was rolled back and 200 otherwise.
+func (resp *batchResponse) status() int {
+	switch {
+	case resp.Failed == 0 && resp.Created == len(resp.Results):
+		return http.StatusCreated
+	case resp.Mode == batchAllOrNothing:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusOK
+	}
+}
+
+// writeBatch sends the batch response.
+func writeBatch(ctx *gin.Context, resp *batchResponse) {
+	resp.tally()
+	ctx.JSON(resp.status(), resp)
+}
+
+// handleCreateUsersBatch handles POST /users:batch
+func (h *handler) handleCreateUsersBatch(ctx *gin.Context) {
+	mode, err := batchMode(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	items, err := readBatch(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := &batchResponse{Mode: mode, Results: make([]batchItemResult, len(items))}
+	reqs := make([]*user.CreateUserRequest, len(items))
+	for i, raw := range items {
+		req := new(user.CreateUserRequest)
+		if r := decodeBatchItem(raw, req); r != nil {
+			r.Index = i
+			resp.Results[i] = *r
+			continue
+		}
+		reqs[i] = req
+	}
+
+	h.createUsers(ctx.Request.Context(), reqs, resp)
+	writeBatch(ctx, resp)
+}
+
+// createUsers creates the valid requests of a batch and fills their results.
+// A nil request means the item was invalid and its result is already set;
+// in all_or_nothing mode that aborts every other item.
+func (h *handler) createUsers(ctx context.Context, reqs []*user.CreateUserRequest, resp *batchResponse) {
+	var users []*user.User
+	var indexes []int
+	invalid := false
+	for i, req := range reqs {
+		if req == nil {
+			invalid = true
+			continue
+		}
+		u, err := newUser(req)
+		if err != nil {
+			resp.Results[i] = batchItemResult{Index: i, Status: http.StatusBadRequest, Code: codeValidationFailed, Error: err.Error()}
+			invalid = true
+			continue
+		}
+		users = append(users, u)
+		indexes = append(indexes, i)
+	}
+
+	if invalid && resp.Mode == batchAllOrNothing {
+		for _, i := range indexes {
+			resp.Results[i] = abortedResult(i)
+		}
+		return
+	}
+
+	errs := h.userService.CreateBatch(ctx, users, resp.Mode == batchAllOrNothing)
+	for j, i := range indexes {
+		if errs[j] != nil {
+			resp.Results[i] = batchItemError(errs[j])
+			resp.Results[i].Index = i
+			continue
+		}
+		resp.Results[i] = batchItemResult{Index: i, Status: http.StatusCreated, Data: users[j]}
+	}
+	logging.FromContext(ctx, h.logger).Info("users batch processed", zap.String("mode", resp.Mode), zap.Int("items", len(reqs)))
+}
+
+// handleCreateSalesBatch handles POST /sales:batch
+func (h *handler) handleCreateSalesBatch(ctx *gin.Context) {
+	mode, err := batchMode(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	items, err := readBatch(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := &batchResponse{Mode: mode, Results: make([]batchItemResult, len(items))}
+	reqs := make([]*sale.CreateSaleRequest, len(items))
+	for i, raw := range items {
+		req := new(sale.CreateSaleRequest)
+		if r := decodeBatchItem(raw, req); r != nil {
+			r.Index = i
+			resp.Results[i] = *r
+			continue
+		}
+		reqs[i] = req
+	}
+
+	h.createSales(ctx.Request.Context(), reqs, resp)
+	writeBatch(ctx, resp)
+}
+
+// createSales is the sales counterpart of createUsers.
+func (h *handler) createSales(ctx context.Context, reqs []*sale.CreateSaleRequest, resp *batchResponse) {
+	var valid []sale.CreateSaleRequest
+	var indexes []int
+	invalid := false
+	for i, req := range reqs {
+		if req == nil {
+			invalid = true
+			continue
+		}
+		valid = append(valid, *req)
+		indexes = append(indexes, i)
+	}
+
+	if invalid && resp.Mode == batchAllOrNothing {
+		for _, i := range indexes {
+			resp.Results[i] = abortedResult(i)
+		}
+		return
+	}
+
+	sales, errs := h.saleService.CreateBatch(ctx, valid, resp.Mode == batchAllOrNothing)
+	for j, i := range indexes {
+		if errs[j] != nil {
+			resp.Results[i] = batchItemError(errs[j])
+			resp.Results[i].Index = i
+			continue
+		}
+		resp.Results[i] = batchItemResult{Index: i, Status: http.StatusCreated, Data: sales[j]}
+	}
+	logging.FromContext(ctx, h.logger).Info("sales batch processed", zap.String("mode", resp.Mode), zap.Int("items", len(reqs)))
+}
@@ -0,0 +1,491 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"parte3/internal/auth"
+	"parte3/internal/sale"
+	"parte3/internal/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// csvFlushEvery is how many rows are written before flushing the response,
+// so large exports reach the client progressively.
+const csvFlushEvery = 100
+
+// maxImportReports is how many error reports are kept for download.
+const maxImportReports = 100
+
+var userCSVHeader = []string{"id", "name", "address", "nickname", "email", "created_at", "updated_at", "version"}
+
+var summaryCSVHeader = []string{"sales_quantity", "sales_approved", "sales_rejected", "sales_pending", "sales_awaiting_approval", "sales_total_amount", "first_sale_at", "last_sale_at"}
+
+var saleCSVHeader = []string{"id", "user_id", "amount", "status", "created_at", "updated_at", "version", "approvers"}
+
+// csvStream writes CSV rows to the response flushing every csvFlushEvery rows.
+type csvStream struct {
+	ctx  *gin.Context
+	w    *csv.Writer
+	rows int
+}
+
+// newCSVStream sends the headers of a CSV download named filename.
+func newCSVStream(ctx *gin.Context, filename string) *csvStream {
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	ctx.Status(http.StatusOK)
+	return &csvStream{ctx: ctx, w: csv.NewWriter(ctx.Writer)}
+}
+
+func (s *csvStream) write(record []string) error {
+	if err := s.w.Write(csvSafe(record)); err != nil {
+		return err
+	}
+	s.rows++
+	if s.rows%csvFlushEvery == 0 {
+		s.w.Flush()
+		s.ctx.Writer.Flush()
+	}
+	return s.w.Error()
+}
+
+func (s *csvStream) close() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// csvSafe prefixes with ' the cells a spreadsheet would run as a formula,
+// the names, nicknames and addresses come from the users.
+func csvSafe(record []string) []string {
+	var safe []string
+	for i, cell := range record {
+		if cell == "" || !strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			continue
+		}
+		if safe == nil {
+			safe = append([]string{}, record...)
+		}
+		safe[i] = "'" + cell
+	}
+	if safe == nil {
+		return record
+	}
+	return safe
+}
+
+func formatCSVTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// formatCSVAddresses joins the one-line form of every address with " | ".
+func formatCSVAddresses(addresses []user.Address) string {
+	lines := make([]string, len(addresses))
+	for i, a := range addresses {
+		lines[i] = a.String()
+	}
+	return strings.Join(lines, " | ")
+}
+
+func userCSVRecord(u *user.User) []string {
+	return []string{
+		u.ID,
+		u.Name,
+		formatCSVAddresses(u.Addresses),
+		u.NickName,
+		u.Email,
+		formatCSVTime(&u.CreatedAt),
+		formatCSVTime(&u.UpdatedAt),
+		strconv.Itoa(u.Version),
+	}
+}
+
+func summaryCSVRecord(s *sale.Summary) []string {
+	return []string{
+		strconv.Itoa(s.Quantity),
+		strconv.Itoa(s.Approved),
+		strconv.Itoa(s.Rejected),
+		strconv.Itoa(s.Pending),
+		strconv.Itoa(s.Awaiting),
+		strconv.FormatFloat(s.TotalAmount, 'f', 2, 64),
+		formatCSVTime(s.FirstSaleAt),
+		formatCSVTime(s.LastSaleAt),
+	}
+}
+
+func saleCSVRecord(s *sale.Sale) []string {
+	return []string{
+		s.ID,
+		s.UserID,
+		strconv.FormatFloat(s.Amount, 'f', 2, 64),
+		s.Status,
+		formatCSVTime(&s.CreatedAt),
+		formatCSVTime(&s.UpdatedAt),
+		strconv.Itoa(s.Version),
+		strings.Join(s.Approvers, ";"),
+	}
+}
+
+// handleExportUsers handles GET /users.csv
+// Accepts the same query parameters as GET /users (include=sales_summary).
+func (h *handler) handleExportUsers(ctx *gin.Context) {
+	withSummary := includesSalesSummary(ctx)
+	if withSummary && deny(ctx, auth.Authorize(currentPrincipal(ctx), auth.PermSalesReadAny)) {
+		return
+	}
+	header := userCSVHeader
+	if withSummary {
+		header = append(append([]string{}, userCSVHeader...), summaryCSVHeader...)
+	}
+
+	out := newCSVStream(ctx, "users.csv")
+	if err := out.write(header); err != nil {
+		h.log(ctx).Error("error writing users csv", zap.Error(err))
+		return
+	}
+	err := h.userService.EachActive(ctx.Request.Context(), func(u *user.User) error {
+		record := userCSVRecord(u)
+		if withSummary {
+			summary, err := h.saleService.Summary(ctx.Request.Context(), u.ID)
+			if err != nil {
+				return fmt.Errorf("sales summary of %s: %w", u.ID, err)
+			}
+			record = append(record, summaryCSVRecord(summary)...)
+		}
+		return out.write(record)
+	})
+	if err == nil {
+		err = out.close()
+	}
+	if err != nil {
+		// los headers ya se enviaron, solo queda cortar la descarga
+		h.log(ctx).Error("error writing users csv", zap.Error(err))
+	}
+}
+
+// handleExportSales handles GET /sales.csv
+// Optional filters: user_id and status, like GET /sales/:id/:status.
+func (h *handler) handleExportSales(ctx *gin.Context) {
+	userID := ctx.Query("user_id")
+	status := ctx.Query("status")
+
+	// quien solo puede ver sus ventas exporta únicamente las propias
+	actor := currentPrincipal(ctx)
+	if !actor.Can(auth.PermSalesReadAny) && userID == "" {
+		userID = actor.Subject
+	}
+	if deny(ctx, auth.AuthorizeOwn(actor, auth.PermSalesReadAny, auth.PermSalesReadOwn, userID)) {
+		return
+	}
+	if status != "" && status != "pending" && status != "approved" && status != "rejected" && status != sale.StatusAwaitingApproval {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": sale.ErrInvalidStatus.Error()})
+		return
+	}
+
+	out := newCSVStream(ctx, "sales.csv")
+	if err := out.write(saleCSVHeader); err != nil {
+		h.log(ctx).Error("error writing sales csv", zap.Error(err))
+		return
+	}
+	err := h.saleService.Each(ctx.Request.Context(), userID, status, func(s *sale.Sale) error {
+		return out.write(saleCSVRecord(s))
+	})
+	if err == nil {
+		err = out.close()
+	}
+	if err != nil {
+		h.log(ctx).Error("error writing sales csv", zap.Error(err))
+	}
+}
+
+// importReportStore keeps the error reports of the latest imports so they
+// can be downloaded after the import finished, only by whoever uploaded them.
+type importReportStore struct {
+	mu    sync.Mutex
+	m     map[string]importReport
+	order []string
+}
+
+// importReport is an error report and the principal that can download it.
+type importReport struct {
+	owner  string
+	report []byte
+}
+
+func newImportReportStore() *importReportStore {
+	return &importReportStore{m: map[string]importReport{}}
+}
+
+// reportOwner identifies the principal that uploaded an import.
+func reportOwner(p *auth.Principal) string {
+	if p == nil {
+		return ""
+	}
+	return p.Kind + ":" + p.Subject
+}
+
+// save stores the report of owner and returns its ID, evicting the oldest if needed.
+func (s *importReportStore) save(owner string, report []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := uuid.NewString()
+	s.m[id] = importReport{owner: owner, report: report}
+	s.order = append(s.order, id)
+	if len(s.order) > maxImportReports {
+		delete(s.m, s.order[0])
+		s.order = s.order[1:]
+	}
+	return id
+}
+
+// get returns the report id if it belongs to owner.
+func (s *importReportStore) get(owner, id string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.m[id]
+	if !ok || owner == "" || r.owner != owner {
+		return nil, false
+	}
+	return r.report, true
+}
+
+// importResponse is the batch response plus the link to the error report.
+type importResponse struct {
+	*batchResponse
+	ErrorReport string `json:"error_report,omitempty"`
+}
+
+// csvImport is a parsed CSV upload: the data rows with the line where each
+// starts and, for each field, the position of its column.
+type csvImport struct {
+	header  []string
+	rows    [][]string
+	lines   []int // un campo entre comillas puede ocupar varias líneas
+	columns map[string]int
+}
+
+// value returns the field of the row, empty if the column is missing.
+func (c *csvImport) value(row []string, field string) string {
+	i, ok := c.columns[field]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+// readCSVImport reads the upload (raw text/csv body or multipart "file") and
+// maps its header to fields. Columns are matched by name ignoring case, the
+// map query parameter renames them: map=Nombre:name,Direccion:address.
+func readCSVImport(ctx *gin.Context, fields []string, required []string) (*csvImport, error) {
+	var body io.Reader = ctx.Request.Body
+	if strings.HasPrefix(ctx.ContentType(), "multipart/form-data") {
+		file, err := ctx.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		f, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		body = f
+	}
+
+	rename := map[string]string{}
+	if m := ctx.Query("map"); m != "" {
+		for _, pair := range strings.Split(m, ",") {
+			from, to, ok := strings.Cut(pair, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid column mapping %q, expected column:field", pair)
+			}
+			rename[strings.ToLower(strings.TrimSpace(from))] = strings.ToLower(strings.TrimSpace(to))
+		}
+	}
+
+	r := csv.NewReader(body)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("csv file is empty")
+		}
+		return nil, err
+	}
+
+	imp := &csvImport{header: header, columns: map[string]int{}}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if to, ok := rename[name]; ok {
+			name = to
+		}
+		for _, f := range fields {
+			if f == name {
+				imp.columns[f] = i
+			}
+		}
+	}
+	for _, f := range required {
+		if _, ok := imp.columns[f]; !ok {
+			return nil, fmt.Errorf("missing column for field %q", f)
+		}
+	}
+
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(imp.rows) == maxBatchItems {
+			return nil, errBatchTooLarge
+		}
+		line, _ := r.FieldPos(0)
+		imp.rows = append(imp.rows, row)
+		imp.lines = append(imp.lines, line)
+	}
+	if len(imp.rows) == 0 {
+		return nil, errEmptyBatch
+	}
+	return imp, nil
+}
+
+// validateImportRow applies the binding rules of the single item endpoint.
+func validateImportRow(req any) *batchItemResult {
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		return &batchItemResult{Status: http.StatusBadRequest, Code: codeValidationFailed, Error: err.Error()}
+	}
+	return nil
+}
+
+// finishImport builds the error report, if any row failed, and replies.
+func (h *handler) finishImport(ctx *gin.Context, imp *csvImport, resp *batchResponse) {
+	resp.tally()
+	out := importResponse{batchResponse: resp}
+
+	if resp.Failed > 0 || resp.Created < len(resp.Results) {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		_ = w.Write(csvSafe(append([]string{"line", "code", "error"}, imp.header...)))
+		for _, r := range resp.Results {
+			if r.Status == http.StatusCreated {
+				continue
+			}
+			_ = w.Write(csvSafe(append([]string{strconv.Itoa(imp.lines[r.Index]), r.Code, r.Error}, imp.rows[r.Index]...)))
+		}
+		w.Flush()
+		out.ErrorReport = "/imports/" + h.importReports.save(reportOwner(currentPrincipal(ctx)), buf.Bytes()) + "/errors.csv"
+	}
+
+	ctx.JSON(resp.status(), out)
+}
+
+// handleImportUsers handles POST /users/import
+func (h *handler) handleImportUsers(ctx *gin.Context) {
+	mode, err := batchMode(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	imp, err := readCSVImport(ctx, []string{"name", "address", "nickname", "email"}, []string{"name", "address"})
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := &batchResponse{Mode: mode, Results: make([]batchItemResult, len(imp.rows))}
+	reqs := make([]*user.CreateUserRequest, len(imp.rows))
+	for i, row := range imp.rows {
+		req := &user.CreateUserRequest{
+			Name:     imp.value(row, "name"),
+			Address:  importAddress(imp.value(row, "address")),
+			NickName: imp.value(row, "nickname"),
+			Email:    imp.value(row, "email"),
+		}
+		if r := validateImportRow(req); r != nil {
+			r.Index = i
+			resp.Results[i] = *r
+			continue
+		}
+		reqs[i] = req
+	}
+
+	h.createUsers(ctx.Request.Context(), reqs, resp)
+	h.finishImport(ctx, imp, resp)
+}
+
+// importAddress parses the address column, empty means missing.
+func importAddress(s string) *user.Address {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	a := user.ParseAddress(s)
+	return &a
+}
+
+// handleImportSales handles POST /sales/import
+func (h *handler) handleImportSales(ctx *gin.Context) {
+	mode, err := batchMode(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	imp, err := readCSVImport(ctx, []string{"user_id", "amount"}, []string{"user_id", "amount"})
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := &batchResponse{Mode: mode, Results: make([]batchItemResult, len(imp.rows))}
+	reqs := make([]*sale.CreateSaleRequest, len(imp.rows))
+	for i, row := range imp.rows {
+		req := &sale.CreateSaleRequest{UserID: imp.value(row, "user_id")}
+		if v := imp.value(row, "amount"); v != "" {
+			amount, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				resp.Results[i] = batchItemResult{Index: i, Status: http.StatusBadRequest, Code: codeValidationFailed, Error: fmt.Sprintf("invalid amount %q", v)}
+				continue
+			}
+			req.Amount = amount
+		}
+		if r := validateImportRow(req); r != nil {
+			r.Index = i
+			resp.Results[i] = *r
+			continue
+		}
+		reqs[i] = req
+	}
+
+	h.createSales(ctx.Request.Context(), reqs, resp)
+	h.finishImport(ctx, imp, resp)
+}
+
+// handleImportErrors handles GET /imports/:id/errors.csv
+// The reports of other principals answer 404 like the missing ones.
+func (h *handler) handleImportErrors(ctx *gin.Context) {
+	report, ok := h.importReports.get(reportOwner(currentPrincipal(ctx)), ctx.Param("id"))
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "import report not found"})
+		return
+	}
+	ctx.Header("Content-Disposition", `attachment; filename="errors.csv"`)
+	ctx.Data(http.StatusOK, "text/csv; charset=utf-8", report)
+}
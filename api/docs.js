@@ -0,0 +1,142 @@
+// Documentación de la API: dibuja el documento OpenAPI que indica
+// data-spec-url en #docs. Sin dependencias, se sirve desde el binario.
+(function () {
+  "use strict";
+
+  var root = document.getElementById("docs");
+  var methods = ["get", "post", "put", "patch", "delete"];
+
+  // el crea elementos sin innerHTML, el texto del documento nunca se toma como HTML
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (k) { node.setAttribute(k, attrs[k]); });
+    (children || []).forEach(function (c) {
+      if (c === null || c === undefined) return;
+      node.appendChild(typeof c === "string" ? document.createTextNode(c) : c);
+    });
+    return node;
+  }
+
+  function refName(ref) {
+    return ref.slice(ref.lastIndexOf("/") + 1);
+  }
+
+  // constraints resume las validaciones de un schema en una línea.
+  function constraints(s) {
+    var out = [];
+    if (s.format) out.push("format: " + s.format);
+    if (s.enum) out.push("one of: " + s.enum.join(", "));
+    if (s.pattern) out.push("pattern: " + s.pattern);
+    [["minLength", "min length"], ["maxLength", "max length"], ["minimum", ">="],
+      ["maximum", "<="], ["exclusiveMinimum", ">"], ["exclusiveMaximum", "<"],
+      ["minItems", "min items"], ["maxItems", "max items"]].forEach(function (c) {
+      if (s[c[0]] !== undefined) out.push(c[1] + " " + s[c[0]]);
+    });
+    return out.join("; ");
+  }
+
+  function typeName(s) {
+    if (!s) return "any";
+    if (s.$ref) return refName(s.$ref);
+    if (s.type === "array") return typeName(s.items) + "[]";
+    if (s.additionalProperties) return "map of " + typeName(s.additionalProperties);
+    return s.type || "any";
+  }
+
+  // schema dibuja las propiedades de un objeto; los $ref enlazan a su
+  // sección en vez de repetirse.
+  function schema(s) {
+    if (s && s.$ref) {
+      return el("p", {}, [el("a", { href: "#schema-" + refName(s.$ref) }, [refName(s.$ref)])]);
+    }
+    if (!s || !s.properties) {
+      var c = s ? constraints(s) : "";
+      return el("p", {}, [el("code", {}, [typeName(s)]), c ? " (" + c + ")" : null]);
+    }
+    var required = s.required || [];
+    var rows = Object.keys(s.properties).sort().map(function (name) {
+      var p = s.properties[name];
+      var type = p.$ref || (p.items && p.items.$ref)
+        ? el("a", { href: "#schema-" + refName(p.$ref || p.items.$ref) }, [typeName(p)])
+        : el("code", {}, [typeName(p)]);
+      return el("tr", {}, [
+        el("td", {}, [el("code", {}, [name]), required.indexOf(name) >= 0 ? " *" : null]),
+        el("td", {}, [type]),
+        el("td", {}, [[p.description, constraints(p)].filter(Boolean).join(". ")]),
+      ]);
+    });
+    return el("table", {}, [el("tr", {}, [el("th", {}, ["Field"]), el("th", {}, ["Type"]), el("th", {}, ["Notes"])])].concat(rows));
+  }
+
+  function content(c) {
+    return Object.keys(c || {}).map(function (mime) {
+      return el("div", {}, [el("p", { class: "mime" }, [mime]), schema(c[mime].schema)]);
+    });
+  }
+
+  function operation(method, path, op) {
+    var body = [el("p", {}, [op.summary])];
+    body.push(el("p", { class: "auth" }, [op.security && op.security.length
+      ? "Auth: " + op.security.map(function (s) { return Object.keys(s).join(" + "); }).join(" or ")
+      : "Public"]));
+    if (op.parameters && op.parameters.length) {
+      body.push(el("h4", {}, ["Parameters"]));
+      body.push(el("table", {}, op.parameters.map(function (p) {
+        return el("tr", {}, [
+          el("td", {}, [el("code", {}, [p.name]), p.required ? " *" : null]),
+          el("td", {}, [p.in]),
+          el("td", {}, [el("code", {}, [typeName(p.schema)])]),
+          el("td", {}, [[p.description, p.schema ? constraints(p.schema) : ""].filter(Boolean).join(". ")]),
+        ]);
+      })));
+    }
+    if (op.requestBody) {
+      body.push(el("h4", {}, ["Request body"]));
+      body = body.concat(content(op.requestBody.content));
+    }
+    body.push(el("h4", {}, ["Responses"]));
+    Object.keys(op.responses || {}).sort().forEach(function (status) {
+      var r = op.responses[status];
+      body.push(el("p", {}, [el("strong", {}, [status]), " " + r.description]));
+      body = body.concat(content(r.content));
+    });
+    return el("details", { id: op.operationId }, [
+      el("summary", {}, [el("span", { class: "method " + method }, [method.toUpperCase()]), " ", el("code", {}, [path])]),
+    ].concat(body));
+  }
+
+  function render(doc) {
+    var byTag = {};
+    Object.keys(doc.paths).sort().forEach(function (path) {
+      methods.forEach(function (method) {
+        var op = doc.paths[path][method];
+        if (!op) return;
+        var tag = (op.tags && op.tags[0]) || "other";
+        (byTag[tag] = byTag[tag] || []).push(operation(method, path, op));
+      });
+    });
+
+    var nodes = [el("h1", {}, [doc.info.title + " " + doc.info.version])];
+    if (doc.info.description) nodes.push(el("p", {}, [doc.info.description]));
+    Object.keys(byTag).sort().forEach(function (tag) {
+      nodes.push(el("h2", {}, [tag]));
+      nodes = nodes.concat(byTag[tag]);
+    });
+    var schemas = (doc.components && doc.components.schemas) || {};
+    nodes.push(el("h2", {}, ["Schemas"]));
+    Object.keys(schemas).sort().forEach(function (name) {
+      nodes.push(el("section", { id: "schema-" + name }, [el("h3", {}, [name]), schema(schemas[name])]));
+    });
+    nodes.forEach(function (n) { root.appendChild(n); });
+  }
+
+  fetch(root.getAttribute("data-spec-url"))
+    .then(function (res) {
+      if (!res.ok) throw new Error(res.status + " " + res.statusText);
+      return res.json();
+    })
+    .then(render)
+    .catch(function (err) {
+      root.appendChild(el("p", { class: "error" }, ["Could not load the API document: " + err.message]));
+    });
+})();
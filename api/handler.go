@@ -1,10 +1,17 @@
 package api
 
 import (
+	"context"
+	"encoding/xml"
 	"errors"
 	"net/http"
+	"parte3/internal/auth"
+	"parte3/internal/health"
 	"parte3/internal/sale"
 	"parte3/internal/user"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -13,55 +20,121 @@ import (
 
 // handler holds the user service and implements HTTP handlers for user CRUD.
 type handler struct {
-	userService *user.Service
-	saleService *sale.Service
-	logger      *zap.Logger
+	userService      *user.Service
+	saleService      *sale.Service
+	authService      *auth.Service
+	tokenService     *auth.TokenService
+	passwordVerifier auth.PasswordVerifier // nil deshabilita el grant password
+	logger           *zap.Logger
+	importReports    *importReportStore
+	health           *health.Registry
 }
 
 // handleCreate handles POST /users
 func (h *handler) handleCreate(ctx *gin.Context) {
 	// request payload
-	var req struct {
-		Name     string `json:"name" binding:"required,regexp"` //anotations; si el content type es json, el nombre del campo es name
-		Address  string `json:"address" binding:"required"`
-		NickName string `json:"nickname" binding:"omitempty,regexp"` //solo letras
+	var req user.CreateUserRequest
+	if err := bindBody(ctx, &req); err != nil {
+		respond(ctx, bindStatus(err), gin.H{"error": err.Error()})
+		return
 	}
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+	u, err := newUser(&req)
+	if err != nil {
+		respond(ctx, http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.userService.Create(ctx.Request.Context(), u); err != nil {
+		if conflict(ctx, err) || invalidAddress(ctx, err) {
+			return
+		}
+		serverError(ctx, err)
 		return
 	}
+	h.log(ctx).Info("user created", zap.Object("user", u))
+	respond(ctx, http.StatusCreated, u)
+}
 
+// newUser builds the user to create from the request, hashing its
+// password if it has one.
+func newUser(req *user.CreateUserRequest) (*user.User, error) {
 	u := &user.User{
-		Name:     req.Name,
-		Address:  req.Address,
-		NickName: req.NickName,
+		Name:      req.Name,
+		Addresses: req.Addresses,
+		NickName:  req.NickName,
+		Email:     req.Email,
 	}
-	if err := h.userService.Create(u); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if req.Address != nil {
+		u.Addresses = append([]user.Address{*req.Address}, req.Addresses...)
+	}
+	if req.Password != "" {
+		hash, err := user.HashPassword(req.Password)
+		if err != nil {
+			return nil, err
+		}
+		u.PasswordHash = hash
+	}
+	return u, nil
+}
+
+// conflict answers 409 with the conflicting field when err is a
+// user.ConflictError, and reports whether it did.
+func conflict(ctx *gin.Context, err error) bool {
+	var ce *user.ConflictError
+	if !errors.As(err, &ce) {
+		return false
 	}
-	h.logger.Info("user created", zap.Any("user", u))
-	ctx.JSON(http.StatusCreated, u)
+	respond(ctx, http.StatusConflict, gin.H{"error": ce.Error(), "field": ce.Field})
+	return true
+}
+
+// invalidAddress answers 400 with the invalid field when err is a
+// user.AddressError, and reports whether it did.
+func invalidAddress(ctx *gin.Context, err error) bool {
+	var ae *user.AddressError
+	if !errors.As(err, &ae) {
+		return false
+	}
+	respond(ctx, http.StatusBadRequest, gin.H{"error": ae.Error(), "field": ae.Field})
+	return true
 }
 
 // handleRead handles GET /users/:id
 func (h *handler) handleRead(ctx *gin.Context) {
 	id := ctx.Param("id")
+	actor := currentPrincipal(ctx)
+	if deny(ctx, auth.AuthorizeOwn(actor, auth.PermUsersReadAny, auth.PermUsersReadOwn, id)) {
+		return
+	}
 
-	u, err := h.userService.Get(id)
+	u, err := h.userService.Get(ctx.Request.Context(), id)
 	if err != nil {
 		if errors.Is(err, user.ErrNotFound) { //compara si el error es del tipo ErrNotFound
 			// si el error es del tipo ErrNotFound, devuelve un 404
-			h.logger.Warn("user not found", zap.String("id", id))
-			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			h.log(ctx).Warn("user not found", zap.String("id", id))
+			respond(ctx, http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
-		h.logger.Error("error trying to get user", zap.Error(err))
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.log(ctx).Error("error trying to get user", zap.Error(err))
+		serverError(ctx, err)
+		return
+	}
+	h.log(ctx).Info("get user succeed", zap.Object("user", u))
+
+	if !includesSalesSummary(ctx) {
+		respond(ctx, http.StatusOK, u)
+		return
+	}
+	if deny(ctx, auth.AuthorizeOwn(actor, auth.PermSalesReadAny, auth.PermSalesReadOwn, id)) {
+		return
+	}
+	resp, err := h.withSalesSummary(ctx.Request.Context(), u)
+	if err != nil {
+		h.log(ctx).Error("error trying to get sales summary", zap.String("id", id), zap.Error(err))
+		serverError(ctx, err)
 		return
 	}
-	h.logger.Info("get user succeed", zap.Any("user", u))
-	ctx.JSON(http.StatusOK, u)
+	respond(ctx, http.StatusOK, resp)
 }
 
 // handleUpdate handles PUT /users/:id
@@ -69,55 +142,165 @@ func (h *handler) handleUpdate(ctx *gin.Context) {
 	id := ctx.Param("id")
 
 	// bind partial update fields
-	var fields *user.UpdateFields
+	var fields user.UpdateFields
 	var user_estado user.User
-	if err := ctx.ShouldBindJSON(&fields); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindBody(ctx, &fields); err != nil {
+		respond(ctx, bindStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	u, err := h.userService.Update(id, fields, user_estado)
+	u, err := h.userService.Update(ctx.Request.Context(), currentPrincipal(ctx), id, &fields, user_estado)
 	if err != nil {
+		if deny(ctx, forbidden(err)) {
+			return
+		}
+		if errors.Is(err, auth.ErrInvalidRole) {
+			respond(ctx, http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if conflict(ctx, err) || invalidAddress(ctx, err) {
+			return
+		}
 		if errors.Is(err, user.ErrNotFound) {
-			h.logger.Warn("user not found", zap.String("id", id))
-			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			h.log(ctx).Warn("user not found", zap.String("id", id))
+			respond(ctx, http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
-		h.logger.Error("error trying to get user", zap.Error(err))
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.log(ctx).Error("error trying to get user", zap.Error(err))
+		serverError(ctx, err)
 		return
 	}
-	h.logger.Info("update user succeed", zap.Any("user", u))
-	ctx.JSON(http.StatusOK, u)
+	h.log(ctx).Info("update user succeed", zap.Object("user", u))
+	respond(ctx, http.StatusOK, u)
 }
 
 // handleDelete handles DELETE /users/:id
 func (h *handler) handleDelete(ctx *gin.Context) {
 	id := ctx.Param("id")
 
-	if err := h.userService.Delete(id); err != nil {
+	if err := h.userService.Delete(ctx.Request.Context(), currentPrincipal(ctx), id); err != nil {
+		if deny(ctx, forbidden(err)) {
+			return
+		}
 		if errors.Is(err, user.ErrNotFound) {
-			h.logger.Warn("user not found", zap.String("id", id))
-			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			h.log(ctx).Warn("user not found", zap.String("id", id))
+			respond(ctx, http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
-		h.logger.Error("error trying to get user", zap.Error(err))
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.log(ctx).Error("error trying to get user", zap.Error(err))
+		serverError(ctx, err)
 		return
 	}
-	h.logger.Info("delete user succeed", zap.Any("user", id))
+	h.log(ctx).Info("delete user succeed", zap.String("id", id))
 	ctx.Status(http.StatusNoContent)
 }
 
+// handleRestore handles POST /users/:id/restore
+func (h *handler) handleRestore(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	u, err := h.userService.Restore(ctx.Request.Context(), currentPrincipal(ctx), id)
+	if err != nil {
+		switch {
+		case deny(ctx, forbidden(err)):
+		case errors.Is(err, user.ErrNotFound):
+			h.log(ctx).Warn("user not found", zap.String("id", id))
+			respond(ctx, http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, user.ErrAlreadyActive):
+			respond(ctx, http.StatusConflict, gin.H{"error": err.Error()})
+		case conflict(ctx, err):
+		default:
+			h.log(ctx).Error("error trying to restore user", zap.Error(err))
+			serverError(ctx, err)
+		}
+		return
+	}
+	h.log(ctx).Info("restore user succeed", zap.String("id", id))
+	respond(ctx, http.StatusOK, u)
+}
+
 func (h *handler) handleListActive(ctx *gin.Context) {
-	users, err := h.userService.ListActive()
+	users, err := h.userService.ListActive(ctx.Request.Context())
 	if err != nil {
-		h.logger.Error("error trying to get users", zap.Error(err))
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.log(ctx).Error("error trying to get users", zap.Error(err))
+		serverError(ctx, err)
+		return
+	}
+	h.log(ctx).Info("list users succeed", zap.Int("count", len(users)))
+
+	if !includesSalesSummary(ctx) {
+		respondList(ctx, http.StatusOK, listing[*user.User]{
+			Body:      users,
+			XMLBody:   newXMLList("users", users),
+			Items:     users,
+			Filename:  "users.csv",
+			CSVHeader: userCSVHeader,
+			CSVRecord: userCSVRecord,
+		})
 		return
 	}
-	h.logger.Info("list users succeed", zap.Any("user", users))
-	ctx.JSON(http.StatusOK, users)
+	if deny(ctx, auth.Authorize(currentPrincipal(ctx), auth.PermSalesReadAny)) {
+		return
+	}
+	resp := make([]*userResponse, 0, len(users))
+	for _, u := range users {
+		r, err := h.withSalesSummary(ctx.Request.Context(), u)
+		if err != nil {
+			h.log(ctx).Error("error trying to get sales summary", zap.String("id", u.ID), zap.Error(err))
+			serverError(ctx, err)
+			return
+		}
+		resp = append(resp, r)
+	}
+	respondList(ctx, http.StatusOK, listing[*userResponse]{
+		Body:      resp,
+		XMLBody:   newXMLList("users", resp),
+		Items:     resp,
+		Filename:  "users.csv",
+		CSVHeader: append(append([]string{}, userCSVHeader...), summaryCSVHeader...),
+		CSVRecord: func(r *userResponse) []string {
+			return append(userCSVRecord(r.User), summaryCSVRecord(r.SalesSummary)...)
+		},
+	})
+}
+
+// userResponse is the user representation returned when the client asks
+// for embedded resources through the include query parameter.
+type userResponse struct {
+	*user.User
+	SalesSummary *sale.Summary `json:"sales_summary,omitempty" xml:"sales_summary,omitempty"`
+}
+
+// forbidden returns err if it is an authorization error and nil otherwise,
+// to be used with deny.
+func forbidden(err error) error {
+	if errors.Is(err, auth.ErrForbidden) {
+		return err
+	}
+	return nil
+}
+
+// includesSalesSummary reports whether ?include=sales_summary was requested.
+// include accepts a comma separated list so more resources can be added later.
+func includesSalesSummary(ctx *gin.Context) bool {
+	for _, v := range ctx.QueryArray("include") {
+		for _, part := range strings.Split(v, ",") {
+			if strings.TrimSpace(part) == "sales_summary" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withSalesSummary joins the user with its sales summary. The join lives in
+// the api layer because sale already imports user.
+func (h *handler) withSalesSummary(ctx context.Context, u *user.User) (*userResponse, error) {
+	summary, err := h.saleService.Summary(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &userResponse{User: u, SalesSummary: summary}, nil
 }
 
 //HANDLER PARA VENTAS
@@ -125,143 +308,243 @@ func (h *handler) handleListActive(ctx *gin.Context) {
 // handleCreateSale handles POST /sales
 func (h *handler) handleCreateSale(ctx *gin.Context) {
 	var req sale.CreateSaleRequest // Usa la request struct de tu paquete sale
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		h.logger.Error("error binding request for create sale", zap.Error(err)) // LOG AÑADIDO
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindBody(ctx, &req); err != nil {
+		h.log(ctx).Error("error binding request for create sale", zap.Error(err)) // LOG AÑADIDO
+		respond(ctx, bindStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if deny(ctx, auth.AuthorizeOwn(currentPrincipal(ctx), auth.PermSalesCreateAny, auth.PermSalesCreateOwn, req.UserID)) {
 		return
 	}
 
 	// Llama al servicio de ventas
-	newSale, err := h.saleService.Create(req.UserID, req.Amount)
+	newSale, err := h.saleService.Create(ctx.Request.Context(), req.UserID, req.Amount)
 	if err != nil {
 		// Maneja errores específicos como user not found, etc.
 		if errors.Is(err, sale.ErrUserNotFound) {
-			h.logger.Warn("user not found for sale creation", // LOG AÑADIDO
+			h.log(ctx).Warn("user not found for sale creation", // LOG AÑADIDO
 				zap.String("user_id", req.UserID),
 				zap.Float64("amount", req.Amount),
 				zap.Error(err),
 			)
-			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			respond(ctx, http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
 		if errors.Is(err, sale.ErrInvalidAmount) {
-			h.logger.Warn("invalid amount for sale creation", // LOG AÑADIDO
+			h.log(ctx).Warn("invalid amount for sale creation", // LOG AÑADIDO
 				zap.String("user_id", req.UserID),
 				zap.Float64("amount", req.Amount),
 				zap.Error(err),
 			)
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respond(ctx, http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 		// Error genérico
-		h.logger.Error("error creating sale", // LOG AÑADIDO
+		h.log(ctx).Error("error creating sale", // LOG AÑADIDO
 			zap.String("user_id", req.UserID),
 			zap.Float64("amount", req.Amount),
 			zap.Error(err),
 		)
-		h.logger.Info("sale created successfully", zap.Any("sale", newSale)) // LOG AÑADIDO
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusCreated, newSale)
+	respond(ctx, http.StatusCreated, newSale)
+}
+
+// salesResponse is the body of the sales listings.
+type salesResponse struct {
+	XMLName  xml.Name       `json:"-" xml:"sales"`
+	Metadata *sale.Metadata `json:"metadata" xml:"metadata"`
+	Results  []*sale.Sale   `json:"results" xml:"results>sale"`
+}
+
+// respondSales renders a sales listing in the negotiated format. NDJSON and
+// CSV only carry the sales, the metadata is left out.
+func respondSales(ctx *gin.Context, metadata *sale.Metadata, sales []*sale.Sale) {
+	body := salesResponse{Metadata: metadata, Results: sales}
+	respondList(ctx, http.StatusOK, listing[*sale.Sale]{
+		Body:      body,
+		XMLBody:   body,
+		Items:     sales,
+		Filename:  "sales.csv",
+		CSVHeader: saleCSVHeader,
+		CSVRecord: saleCSVRecord,
+	})
 }
 
 func (h *handler) handleReadSales(ctx *gin.Context) {
 	id := ctx.Param("id")
+	if deny(ctx, auth.AuthorizeOwn(currentPrincipal(ctx), auth.PermSalesReadAny, auth.PermSalesReadOwn, id)) {
+		return
+	}
 
-	sales, metadata, err := h.saleService.Get(id)
+	sales, metadata, err := h.saleService.Get(ctx.Request.Context(), id)
 	if err != nil {
 		if errors.Is(err, sale.ErrNotFound) {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			respond(ctx, http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(ctx, err)
 		return
 	}
-	respuesta := gin.H{
-		"metadata": metadata,
-		"results":  sales,
-	}
-
-	ctx.JSON(http.StatusOK, respuesta)
+	respondSales(ctx, metadata, sales)
 }
 
 func (h *handler) handleReadSalesWithStatus(ctx *gin.Context) {
 	id := ctx.Param("id")
 	status := ctx.Param("status")
+	if deny(ctx, auth.AuthorizeOwn(currentPrincipal(ctx), auth.PermSalesReadAny, auth.PermSalesReadOwn, id)) {
+		return
+	}
 
-	sales, metadata, err := h.saleService.GetByStatus(id, &status)
+	sales, metadata, err := h.saleService.GetByStatus(ctx.Request.Context(), id, &status)
 	if err != nil {
 		if errors.Is(err, sale.ErrNotFound) {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			respond(ctx, http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		serverError(ctx, err)
 		return
 	}
-	respuesta := gin.H{
-		"metadata": metadata,
-		"results":  sales,
-	}
-
-	ctx.JSON(http.StatusOK, respuesta)
+	respondSales(ctx, metadata, sales)
 }
 
 func (h *handler) handleUpdateSaleStatus(ctx *gin.Context) {
 	id := ctx.Param("id") //
 
 	var req sale.UpdateSale
-	if err := ctx.ShouldBindJSON(&req); err != nil { //
-		h.logger.Error("error binding request for update sale status", // LOG AÑADIDO
+	if err := bindBody(ctx, &req); err != nil { //
+		h.log(ctx).Error("error binding request for update sale status", // LOG AÑADIDO
 			zap.String("sale_id", id),
 			zap.Error(err),
 		)
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respond(ctx, bindStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	updatedSale, err := h.saleService.Update(id, req.Status)
+	updatedSale, err := h.saleService.Update(ctx.Request.Context(), currentPrincipal(ctx), id, req.Status)
 	if err != nil {
 		switch {
+		case deny(ctx, forbidden(err)):
 		case errors.Is(err, sale.ErrNotFound): //
-			h.logger.Warn("sale not found for status update", // LOG AÑADIDO
+			h.log(ctx).Warn("sale not found for status update", // LOG AÑADIDO
 				zap.String("sale_id", id),
 				zap.String("requested_status", req.Status),
 				zap.Error(err),
 			)
-			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			respond(ctx, http.StatusNotFound, gin.H{"error": err.Error()})
 		case errors.Is(err, sale.ErrSaleNotActive):
-			h.logger.Warn("sale not active for status update", // LOG AÑADIDO
+			h.log(ctx).Warn("sale not active for status update", // LOG AÑADIDO
 				zap.String("sale_id", id),
 				zap.String("requested_status", req.Status),
 				zap.Error(err),
 			)
-			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()}) // O StatusConflict
+			respond(ctx, http.StatusNotFound, gin.H{"error": err.Error()}) // O StatusConflict
 		case errors.Is(err, sale.ErrSaleMustBePending):
-			h.logger.Warn("sale not pending for status update", // LOG AÑADIDO
+			h.log(ctx).Warn("sale not pending for status update", // LOG AÑADIDO
 				zap.String("sale_id", id),
 				zap.String("requested_status", req.Status),
 				zap.Error(err),
 			)
-			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()}) // 409 Conflict es apropiado aquí
+			respond(ctx, http.StatusConflict, gin.H{"error": err.Error()}) // 409 Conflict es apropiado aquí
 		case errors.Is(err, sale.ErrInvalidSaleStateTransition):
-			h.logger.Warn("invalid state transition for sale status update", // LOG AÑADIDO
+			h.log(ctx).Warn("invalid state transition for sale status update", // LOG AÑADIDO
 				zap.String("sale_id", id),
 				zap.String("requested_status", req.Status),
 				zap.Error(err),
 			)
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respond(ctx, http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, sale.ErrSelfApproval):
+			h.log(ctx).Warn("self approval attempt",
+				zap.String("sale_id", id),
+				zap.Error(err),
+			)
+			respond(ctx, http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, sale.ErrAlreadyApproved), errors.Is(err, sale.ErrVersionConflict):
+			respond(ctx, http.StatusConflict, gin.H{"error": err.Error()})
 		default:
-			h.logger.Error("error updating sale status", // LOG AÑADIDO
+			h.log(ctx).Error("error updating sale status", // LOG AÑADIDO
 				zap.String("sale_id", id),
 				zap.String("requested_status", req.Status),
 				zap.Error(err),
 			)
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}) //
+			serverError(ctx, err) //
+		}
+		return
+	}
+	h.log(ctx).Info("sale status updated successfully", zap.Object("sale", updatedSale)) // LOG AÑADIDO
+	respond(ctx, http.StatusOK, updatedSale)                                             //
+}
+
+//HANDLER PARA REPORTES
+
+// reportDateLayout is the format accepted by the from and to query parameters.
+const reportDateLayout = "2006-01-02"
+
+// handleTopCustomers handles GET /reports/top-customers
+// Query params: from, to (YYYY-MM-DD, inclusive), by (amount|count), limit and
+// format (json|csv). The report joins sale aggregates with user names.
+func (h *handler) handleTopCustomers(ctx *gin.Context) {
+	var from, to time.Time
+	var err error
+	if v := ctx.Query("from"); v != "" {
+		if from, err = time.Parse(reportDateLayout, v); err != nil {
+			respond(ctx, http.StatusBadRequest, gin.H{"error": "invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+	}
+	if v := ctx.Query("to"); v != "" {
+		if to, err = time.Parse(reportDateLayout, v); err != nil {
+			respond(ctx, http.StatusBadRequest, gin.H{"error": "invalid to date, expected YYYY-MM-DD"})
+			return
 		}
+	}
+	limit := 0
+	if v := ctx.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			respond(ctx, http.StatusBadRequest, gin.H{"error": sale.ErrInvalidLimit.Error()})
+			return
+		}
+	}
+
+	ranking, err := h.saleService.TopCustomers(ctx.Request.Context(), from, to, ctx.Query("by"), limit)
+	if err != nil {
+		if errors.Is(err, sale.ErrInvalidRankBy) || errors.Is(err, sale.ErrInvalidLimit) || errors.Is(err, sale.ErrInvalidDateRange) {
+			respond(ctx, http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.log(ctx).Error("error building top customers report", zap.Error(err))
+		serverError(ctx, err)
 		return
 	}
-	h.logger.Info("sale status updated successfully", zap.Any("sale", updatedSale)) // LOG AÑADIDO
-	ctx.JSON(http.StatusOK, updatedSale)                                            //
+
+	for _, row := range ranking {
+		// los usuarios dados de baja quedan en el ranking sin nombre
+		if u, err := h.userService.Get(ctx.Request.Context(), row.UserID); err == nil {
+			row.Name = u.Name
+		}
+	}
+
+	// format=csv se mantiene como atajo de Accept: text/csv
+	if ctx.Query("format") == "csv" {
+		ctx.Request.Header.Set("Accept", mimeCSV)
+		ctx.Accepted = nil
+	}
+	respondList(ctx, http.StatusOK, listing[*sale.CustomerRank]{
+		Body:      gin.H{"results": ranking},
+		XMLBody:   newXMLList("top_customers", ranking),
+		Items:     ranking,
+		Filename:  "top-customers.csv",
+		CSVHeader: []string{"rank", "user_id", "name", "approved", "total_amount"},
+		CSVRecord: func(row *sale.CustomerRank) []string {
+			return []string{
+				strconv.Itoa(row.Rank),
+				row.UserID,
+				row.Name,
+				strconv.Itoa(row.Approved),
+				strconv.FormatFloat(row.TotalAmount, 'f', 2, 64),
+			}
+		},
+	})
 }
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"parte3/internal/health"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// healthCheckTimeout is how long each health check may take.
+const healthCheckTimeout = 2 * time.Second
+
+// handleHealthz handles GET /healthz, the liveness probe.
+func (h *handler) handleHealthz(ctx *gin.Context) {
+	h.writeHealth(ctx, h.health.Liveness(ctx.Request.Context()))
+}
+
+// handleReadyz handles GET /readyz, the readiness probe.
+func (h *handler) handleReadyz(ctx *gin.Context) {
+	h.writeHealth(ctx, h.health.Readiness(ctx.Request.Context()))
+}
+
+// writeHealth answers 200 if every check is up and 503 otherwise, always
+// with the JSON breakdown so the operator can see what failed.
+func (h *handler) writeHealth(ctx *gin.Context, report health.Report) {
+	if report.Up() {
+		ctx.JSON(http.StatusOK, report)
+		return
+	}
+	for _, res := range report.Checks {
+		if res.Status != health.StatusUp {
+			h.log(ctx).Warn("health check failed",
+				zap.String("check", res.Name),
+				zap.String("kind", res.Kind),
+				zap.Duration("latency", res.Latency),
+				zap.String("error", res.Error))
+		}
+	}
+	ctx.JSON(http.StatusServiceUnavailable, report)
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"parte3/internal/idempotency"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// idempotencyHeader is the header clients use to make a POST safe to retry.
+const idempotencyHeader = "Idempotency-Key"
+
+// idempotencyTTL is how long the first response of a key is replayed.
+const idempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLength bounds the size of the keys we keep in memory.
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentBodySize bounds the bodies read to fingerprint them, a user
+// or a sale is far smaller.
+const maxIdempotentBodySize = 1 << 20
+
+// clientID identifies who sent the request, idempotency keys are scoped by
+// it: the authenticated principal, or the client IP for anonymous requests.
+func clientID(ctx *gin.Context) string {
+	if principal := currentPrincipal(ctx); principal != nil {
+		return principal.Kind + ":" + principal.Subject
+	}
+	return "ip:" + ctx.ClientIP()
+}
+
+// bodyRecorder copies everything the handler writes so it can be replayed.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotent makes a creation endpoint safe to retry. The first response for
+// an Idempotency-Key is stored and replayed for repeats with the same payload;
+// reusing the key with another payload returns 422. Requests without the
+// header are not affected.
+func idempotent(store *idempotency.LocalStorage, logger *zap.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(idempotencyHeader)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "idempotency key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxIdempotentBodySize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.New()
+		sum.Write([]byte(ctx.Request.Method + " " + ctx.Request.URL.Path + "\n"))
+		sum.Write(body)
+		fingerprint := hex.EncodeToString(sum.Sum(nil))
+
+		scope := clientID(ctx)
+		record, err := store.Reserve(scope, key, fingerprint)
+		if err != nil {
+			switch {
+			case errors.Is(err, idempotency.ErrKeyReused):
+				requestLogger(ctx, logger).Warn("idempotency key reused with different payload", zap.String("key", key))
+				ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			case errors.Is(err, idempotency.ErrInProgress):
+				ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		if record != nil {
+			requestLogger(ctx, logger).Info("replaying idempotent response", zap.String("key", key), zap.Int("status", record.Status))
+			ctx.Header("Idempotent-Replayed", "true")
+			ctx.Data(record.Status, record.ContentType, record.Body)
+			ctx.Abort()
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: ctx.Writer}
+		ctx.Writer = recorder
+
+		// las respuestas que no son definitivas (y los panics) no se guardan
+		// para que el cliente pueda reintentar con la misma clave
+		completed := false
+		defer func() {
+			if !completed {
+				store.Release(scope, key)
+			}
+		}()
+
+		ctx.Next()
+
+		if storable(recorder.Status()) {
+			store.Complete(scope, key, recorder.Status(), recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+			completed = true
+		}
+	}
+}
+
+// storable reports whether a response is the outcome of the request and can
+// be replayed. The server errors, the timeouts (504) and the cancellations
+// (499) are not: repeating the request may succeed.
+func storable(status int) bool {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return false
+	case status == statusClientClosedRequest, status == http.StatusRequestTimeout:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"parte3/internal/metrics"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routeKey is the context key where customMethods leave the route they
+// dispatched to, gin does not know it because they come through NoRoute.
+const routeKey = "route"
+
+// unmatchedRoute labels the requests that matched no route, the raw path
+// would create a time series per path scanners try.
+const unmatchedRoute = "unmatched"
+
+// observeRequests records the method, route template, status and latency of
+// every request, also the ones that panic. Only requestContext may run
+// before it, so it also counts the requests rejected by authentication or
+// rate limiting.
+func observeRequests(m *metrics.Prometheus) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		defer func() {
+			rec := recover()
+			m.ObserveRequest(ctx.Request.Method, routeTemplate(ctx), responseStatus(ctx, rec), time.Since(start))
+			if rec != nil {
+				panic(rec)
+			}
+		}()
+		ctx.Next()
+	}
+}
+
+// responseStatus is the status of the request once the middlewares return.
+// rec is what the caller recovered: a panic is answered 500 by gin.Recovery,
+// outside the api, unless the handler already wrote its response. Whoever
+// recovers it must panic again with rec.
+func responseStatus(ctx *gin.Context, rec any) int {
+	if rec != nil && !ctx.Writer.Written() {
+		return http.StatusInternalServerError
+	}
+	return ctx.Writer.Status()
+}
+
+// routeTemplate returns the template of the route that served the request,
+// e.g. /users/:id.
+func routeTemplate(ctx *gin.Context) string {
+	if route := ctx.FullPath(); route != "" {
+		return route
+	}
+	if route := ctx.GetString(routeKey); route != "" {
+		return route
+	}
+	return unmatchedRoute
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+)
+
+// Formatos soportados en Accept y Content-Type.
+const (
+	mimeJSON     = binding.MIMEJSON
+	mimeXML      = binding.MIMEXML
+	mimeXML2     = binding.MIMEXML2
+	mimeMsgPack  = binding.MIMEMSGPACK
+	mimeMsgPack2 = binding.MIMEMSGPACK2
+	mimeNDJSON   = "application/x-ndjson"
+	mimeCSV      = "text/csv"
+)
+
+// objectFormats are the formats every response can be rendered in.
+var objectFormats = []string{mimeJSON, mimeXML, mimeXML2, mimeMsgPack, mimeMsgPack2}
+
+// listFormats adds the streaming and tabular formats available for listings.
+// CSV goes last so it can be left out for listings that are not tabular.
+var listFormats = append(append([]string{}, objectFormats...), mimeNDJSON, mimeCSV)
+
+// errUnsupportedMediaType is returned when the request body format is unknown.
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// errNotAcceptable is the body sent when no offered format matches Accept.
+var errNotAcceptable = errors.New("none of the formats in Accept is supported")
+
+// respond writes obj with the format negotiated from the Accept header.
+// Without Accept the response is JSON, like before negotiation existed.
+func respond(ctx *gin.Context, status int, obj any) {
+	switch ctx.NegotiateFormat(objectFormats...) {
+	case mimeJSON:
+		ctx.JSON(status, obj)
+	case mimeXML, mimeXML2:
+		ctx.XML(status, obj)
+	case mimeMsgPack, mimeMsgPack2:
+		ctx.Render(status, render.MsgPack{Data: obj})
+	default:
+		ctx.JSON(http.StatusNotAcceptable, gin.H{"error": errNotAcceptable.Error()})
+	}
+}
+
+// listing describes a list response so it can be rendered in every format:
+// Body is sent as JSON and MessagePack, XMLBody as XML (XML needs a single
+// root element), and Items are streamed one per line as NDJSON or, when
+// CSVHeader is set, one row per item as CSV.
+type listing[T any] struct {
+	Body      any
+	XMLBody   any
+	Items     []T
+	Filename  string
+	CSVHeader []string
+	CSVRecord func(T) []string
+}
+
+// respondList writes the listing with the format negotiated from Accept.
+func respondList[T any](ctx *gin.Context, status int, l listing[T]) {
+	offered := listFormats
+	if l.CSVHeader == nil {
+		offered = listFormats[:len(listFormats)-1]
+	}
+
+	switch ctx.NegotiateFormat(offered...) {
+	case mimeXML, mimeXML2:
+		ctx.XML(status, l.XMLBody)
+	case mimeNDJSON:
+		ctx.Header("Content-Type", mimeNDJSON)
+		ctx.Status(status)
+		enc := json.NewEncoder(ctx.Writer)
+		for _, item := range l.Items {
+			if err := enc.Encode(item); err != nil {
+				return
+			}
+			ctx.Writer.Flush()
+		}
+	case mimeCSV:
+		out := newCSVStream(ctx, l.Filename)
+		if err := out.write(l.CSVHeader); err != nil {
+			return
+		}
+		for _, item := range l.Items {
+			if err := out.write(l.CSVRecord(item)); err != nil {
+				return
+			}
+		}
+		_ = out.close()
+	case mimeJSON, mimeMsgPack, mimeMsgPack2:
+		respond(ctx, status, l.Body)
+	default:
+		ctx.JSON(http.StatusNotAcceptable, gin.H{"error": errNotAcceptable.Error()})
+	}
+}
+
+// bindBody decodes the request body according to its Content-Type (JSON,
+// NDJSON with a single document, XML, MessagePack or CSV with a header and a
+// single row) and validates it with the binding rules, like ShouldBindJSON.
+// Returns errUnsupportedMediaType for any other format.
+func bindBody(ctx *gin.Context, obj any) error {
+	switch ctx.ContentType() {
+	case "", mimeJSON, mimeNDJSON:
+		return ctx.ShouldBindWith(obj, binding.JSON)
+	case mimeXML, mimeXML2:
+		return ctx.ShouldBindWith(obj, binding.XML)
+	case mimeMsgPack, mimeMsgPack2:
+		return ctx.ShouldBindWith(obj, binding.MsgPack)
+	case mimeCSV:
+		if err := decodeCSVBody(ctx.Request.Body, obj); err != nil {
+			return err
+		}
+		return binding.Validator.ValidateStruct(obj)
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedMediaType, ctx.ContentType())
+	}
+}
+
+// bindStatus is the status code for an error returned by bindBody.
+func bindStatus(err error) int {
+	if errors.Is(err, errUnsupportedMediaType) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// decodeCSVBody fills obj from a CSV with a header row and one data row.
+// Columns are matched with the json tags of obj; empty cells are skipped so
+// pointer fields keep meaning "no change". obj must point to a struct, nil
+// pointers on the way are allocated.
+func decodeCSVBody(body io.Reader, obj any) error {
+	r := csv.NewReader(body)
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) != 2 {
+		return errors.New("csv body must have a header and exactly one row")
+	}
+
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			if !v.CanSet() {
+				return errors.New("csv body needs a non-nil target")
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("csv body cannot be decoded into %s", v.Type())
+	}
+	fields := map[string]int{}
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		fields[name] = i
+	}
+
+	for col, name := range records[0] {
+		i, ok := fields[strings.ToLower(strings.TrimSpace(name))]
+		if !ok || records[1][col] == "" {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Pointer {
+			field.Set(reflect.New(field.Type().Elem()))
+			field = field.Elem()
+		}
+		value := records[1][col]
+		if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			if err := u.UnmarshalText([]byte(value)); err != nil {
+				return fmt.Errorf("invalid value %q for %s", value, name)
+			}
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Float64:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for %s", value, name)
+			}
+			field.SetFloat(f)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for %s", value, name)
+			}
+			field.SetInt(int64(n))
+		default:
+			return fmt.Errorf("unsupported csv column %s", name)
+		}
+	}
+	return nil
+}
+
+// xmlList wraps a list under a root element for XML responses.
+type xmlList[T any] struct {
+	XMLName xml.Name
+	Items   []T
+}
+
+// MarshalXML writes every item as a child of the root element.
+func (l xmlList[T]) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = l.XMLName
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, item := range l.Items {
+		if err := e.Encode(item); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// newXMLList builds an XML list with the given root element name.
+func newXMLList[T any](root string, items []T) xmlList[T] {
+	return xmlList[T]{XMLName: xml.Name{Local: root}, Items: items}
+}
@@ -0,0 +1,506 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"parte3/internal/auth"
+	"parte3/internal/health"
+	"parte3/internal/openapi"
+	"parte3/internal/sale"
+	"parte3/internal/user"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+)
+
+// NamePattern is checked by the "regexp" binding validation that main
+// registers for names and nicknames: words of letters separated by one space.
+const NamePattern = `^[a-zA-Z]+(?: [a-zA-Z]+)*$`
+
+// apiVersion is the version reported in the OpenAPI document.
+const apiVersion = "1.0.0"
+
+//go:embed docs.html
+var docsPage []byte
+
+// docsScript renders the document on the /docs page. It is ours and served
+// from the binary, so the page loads no third-party scripts.
+//
+//go:embed docs.js
+var docsScript []byte
+
+// errorBody is the body of the error responses, except the RFC 7807 ones
+// of authentication and rate limiting.
+type errorBody struct {
+	Error string `json:"error"`
+	Field string `json:"field,omitempty"` // campo en conflicto o dirección inválida
+}
+
+// operation documents one route in the OpenAPI document. The zero value of
+// the format lists means the formats negotiated by bindBody and respond.
+type operation struct {
+	summary  string
+	tag      string
+	public   bool // sin credenciales
+	params   []openapi.Parameter
+	body     any      // nil: sin cuerpo
+	bodyMIME []string // formatos aceptados para body
+	status   int      // respuesta exitosa
+	response any      // nil: sin cuerpo
+	respMIME []string // formatos de response
+	item     any      // con listados: el elemento de NDJSON, que además admiten CSV
+	errors   []int
+}
+
+// Parámetros comunes de las operaciones.
+var (
+	paramInclude = openapi.Parameter{Name: "include", In: "query", Description: "embedded resources, comma separated: sales_summary", Schema: &openapi.Schema{Type: "string"}}
+	paramMode    = openapi.Parameter{Name: "mode", In: "query", Description: "all_or_nothing (default) or best_effort", Schema: &openapi.Schema{Type: "string", Enum: []string{batchAllOrNothing, batchBestEffort}}}
+	paramIdem    = openapi.Parameter{Name: idempotencyHeader, In: "header", Description: "makes the request safe to retry for 24h", Schema: &openapi.Schema{Type: "string", MaxLength: ptr(maxIdempotencyKeyLength)}}
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// operations documents every route by "METHOD /gin/path". A route
+// registered without an entry here is left out of the document and logged.
+var operations = map[string]operation{
+	"POST /users": {
+		summary: "Create a user", tag: "users",
+		params: []openapi.Parameter{paramIdem},
+		body:   user.CreateUserRequest{}, status: http.StatusCreated, response: user.User{},
+		errors: []int{http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+	"GET /users": {
+		summary: "List the active users", tag: "users",
+		params: []openapi.Parameter{paramInclude},
+		status: http.StatusOK, response: []userResponse{}, item: userResponse{},
+	},
+	"GET /users/:id": {
+		summary: "Get a user", tag: "users",
+		params: []openapi.Parameter{paramInclude},
+		status: http.StatusOK, response: userResponse{},
+		errors: []int{http.StatusNotFound},
+	},
+	"PATCH /users/:id": {
+		summary: "Update a user", tag: "users",
+		body: user.UpdateFields{}, status: http.StatusOK, response: user.User{},
+		errors: []int{http.StatusNotFound, http.StatusConflict},
+	},
+	"DELETE /users/:id": {
+		summary: "Delete a user, it can be restored", tag: "users",
+		status: http.StatusNoContent,
+		errors: []int{http.StatusNotFound},
+	},
+	"POST /users/:id/restore": {
+		summary: "Restore a deleted user", tag: "users",
+		status: http.StatusOK, response: user.User{},
+		errors: []int{http.StatusNotFound, http.StatusConflict},
+	},
+	"PUT /users/:id/password": {
+		summary: "Change the password of a user", tag: "users",
+		body: user.ChangePasswordRequest{}, status: http.StatusNoContent,
+		errors: []int{http.StatusNotFound},
+	},
+	"POST /users/:id/password/setup": {
+		summary: "Send the user a token to set the password", tag: "users",
+		status: http.StatusAccepted,
+		errors: []int{http.StatusNotFound, http.StatusConflict},
+	},
+	"POST /users:batch": {
+		summary: "Create many users", tag: "users",
+		params: []openapi.Parameter{paramMode},
+		body:   []user.CreateUserRequest{}, bodyMIME: []string{mimeJSON, mimeNDJSON},
+		status: http.StatusCreated, response: batchResponse{}, respMIME: []string{mimeJSON},
+		errors: []int{http.StatusOK, http.StatusUnprocessableEntity},
+	},
+	"GET /users.csv": {
+		summary: "Export the active users as CSV", tag: "users",
+		params: []openapi.Parameter{paramInclude},
+		status: http.StatusOK, response: "", respMIME: []string{mimeCSV},
+	},
+	"POST /users/import": {
+		summary: "Import users from a CSV with name, address, nickname and email", tag: "users",
+		params: []openapi.Parameter{paramMode},
+		body:   "", bodyMIME: []string{mimeCSV},
+		status: http.StatusCreated, response: importResponse{}, respMIME: []string{mimeJSON},
+		errors: []int{http.StatusOK, http.StatusUnprocessableEntity},
+	},
+
+	"POST /sales": {
+		summary: "Create a sale, its status is chosen at random", tag: "sales",
+		params: []openapi.Parameter{paramIdem},
+		body:   sale.CreateSaleRequest{}, status: http.StatusCreated, response: sale.Sale{},
+		errors: []int{http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+	"GET /sales/:id": {
+		summary: "List the sales of a user with their metadata", tag: "sales",
+		status: http.StatusOK, response: salesResponse{}, item: sale.Sale{},
+		errors: []int{http.StatusNotFound},
+	},
+	"GET /sales/:id/:status": {
+		summary: "List the sales of a user with a status", tag: "sales",
+		status: http.StatusOK, response: salesResponse{}, item: sale.Sale{},
+		errors: []int{http.StatusNotFound},
+	},
+	"PATCH /sales/:id": {
+		summary: "Approve or reject a pending sale", tag: "sales",
+		body: sale.UpdateSale{}, status: http.StatusOK, response: sale.Sale{},
+		errors: []int{http.StatusNotFound, http.StatusConflict},
+	},
+	"POST /sales:batch": {
+		summary: "Create many sales", tag: "sales",
+		params: []openapi.Parameter{paramMode},
+		body:   []sale.CreateSaleRequest{}, bodyMIME: []string{mimeJSON, mimeNDJSON},
+		status: http.StatusCreated, response: batchResponse{}, respMIME: []string{mimeJSON},
+		errors: []int{http.StatusOK, http.StatusUnprocessableEntity},
+	},
+	"GET /sales.csv": {
+		summary: "Export the sales as CSV", tag: "sales",
+		params: []openapi.Parameter{
+			{Name: "user_id", In: "query", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "status", In: "query", Schema: &openapi.Schema{Type: "string", Enum: []string{"pending", sale.StatusAwaitingApproval, "approved", "rejected"}}},
+		},
+		status: http.StatusOK, response: "", respMIME: []string{mimeCSV},
+	},
+	"POST /sales/import": {
+		summary: "Import sales from a CSV with user_id and amount", tag: "sales",
+		params: []openapi.Parameter{paramMode},
+		body:   "", bodyMIME: []string{mimeCSV},
+		status: http.StatusCreated, response: importResponse{}, respMIME: []string{mimeJSON},
+		errors: []int{http.StatusOK, http.StatusUnprocessableEntity},
+	},
+	"GET /imports/:id/errors.csv": {
+		summary: "Download the rows that failed in one of your own imports", tag: "sales",
+		status: http.StatusOK, response: "", respMIME: []string{mimeCSV},
+		errors: []int{http.StatusNotFound},
+	},
+
+	"GET /reports/top-customers": {
+		summary: "Rank the customers by approved sales", tag: "reports",
+		params: []openapi.Parameter{
+			{Name: "from", In: "query", Description: "first day, inclusive", Schema: &openapi.Schema{Type: "string", Format: "date"}},
+			{Name: "to", In: "query", Description: "last day, inclusive", Schema: &openapi.Schema{Type: "string", Format: "date"}},
+			{Name: "by", In: "query", Schema: &openapi.Schema{Type: "string", Enum: []string{"amount", "count"}}},
+			{Name: "limit", In: "query", Schema: &openapi.Schema{Type: "integer", Minimum: ptr(1.0)}},
+			{Name: "format", In: "query", Description: "csv is a shortcut of Accept: text/csv", Schema: &openapi.Schema{Type: "string", Enum: []string{"json", "csv"}}},
+		},
+		status: http.StatusOK, response: topCustomersBody{}, item: sale.CustomerRank{},
+	},
+	"GET /metrics": {
+		summary: "Prometheus metrics", tag: "operations",
+		status: http.StatusOK, response: "", respMIME: []string{"text/plain"},
+	},
+
+	"POST /auth/keys": {
+		summary: "Issue an API key, the key is only returned now", tag: "auth",
+		body: auth.IssueKeyRequest{}, status: http.StatusCreated, response: auth.IssuedKey{},
+	},
+	"GET /auth/keys": {
+		summary: "List the API keys", tag: "auth",
+		status: http.StatusOK, response: []auth.APIKey{}, item: auth.APIKey{},
+	},
+	"POST /auth/keys/:id/rotate": {
+		summary: "Replace the secret of an API key", tag: "auth",
+		status: http.StatusOK, response: auth.IssuedKey{},
+		errors: []int{http.StatusNotFound, http.StatusConflict},
+	},
+	"DELETE /auth/keys/:id": {
+		summary: "Revoke an API key", tag: "auth",
+		status: http.StatusNoContent,
+		errors: []int{http.StatusNotFound, http.StatusConflict},
+	},
+	"POST /auth/jwks/rotate": {
+		summary: "Add a new token signing key", tag: "auth",
+		status: http.StatusOK, response: rotatedKeyBody{},
+	},
+	"POST /auth/token": {
+		summary: "Exchange a password or an API key for a token", tag: "auth", public: true,
+		body: tokenRequest{}, bodyMIME: []string{binding.MIMEPOSTForm, mimeJSON, mimeXML, mimeMsgPack},
+		status: http.StatusOK, response: tokenResponse{},
+		errors: []int{http.StatusUnauthorized, http.StatusTooManyRequests},
+	},
+	"POST /auth/password/forgot": {
+		summary: "Send a password reset token, always answers 202", tag: "auth", public: true,
+		body: user.ForgotPasswordRequest{}, status: http.StatusAccepted,
+		errors: []int{http.StatusTooManyRequests},
+	},
+	"POST /auth/password/reset": {
+		summary: "Set the password with a setup or reset token", tag: "auth", public: true,
+		body: user.ResetPasswordRequest{}, status: http.StatusNoContent,
+		errors: []int{http.StatusTooManyRequests},
+	},
+	"GET /.well-known/jwks.json": {
+		summary: "Public keys to verify the tokens", tag: "auth", public: true,
+		status: http.StatusOK, response: auth.JWKS{}, respMIME: []string{mimeJSON},
+	},
+
+	"GET /healthz": {
+		summary: "Liveness probe", tag: "operations", public: true,
+		status: http.StatusOK, response: health.Report{}, respMIME: []string{mimeJSON},
+		errors: []int{http.StatusServiceUnavailable},
+	},
+	"GET /readyz": {
+		summary: "Readiness probe", tag: "operations", public: true,
+		status: http.StatusOK, response: health.Report{}, respMIME: []string{mimeJSON},
+		errors: []int{http.StatusServiceUnavailable},
+	},
+	"GET /ping": {
+		summary: "Answers pong", tag: "operations", public: true,
+		status: http.StatusOK, response: pongBody{}, respMIME: []string{mimeJSON},
+	},
+	"GET /openapi.json": {
+		summary: "This document", tag: "operations", public: true,
+		status: http.StatusOK, response: map[string]any{}, respMIME: []string{mimeJSON},
+	},
+	"GET /docs": {
+		summary: "Documentation UI of this document", tag: "operations", public: true,
+		status: http.StatusOK, response: "", respMIME: []string{"text/html"},
+	},
+	"GET /docs/docs.js": {
+		summary: "Script of the documentation UI", tag: "operations", public: true,
+		status: http.StatusOK, response: "", respMIME: []string{"text/javascript"},
+	},
+}
+
+// Cuerpos que los handlers arman con gin.H, con nombre para documentarlos.
+type (
+	topCustomersBody struct {
+		Results []*sale.CustomerRank `json:"results"`
+	}
+	rotatedKeyBody struct {
+		Kid string `json:"kid"`
+	}
+	pongBody struct {
+		Message string `json:"message"`
+	}
+	oauthErrorBody struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+)
+
+// apiDocs serves the OpenAPI document and its documentation UI.
+type apiDocs struct {
+	spec []byte
+}
+
+// build generates the document from the routes registered on the engine
+// and the custom methods. Routes without an entry in operations are
+// logged, the test of the document fails for them.
+func (d *apiDocs) build(routes gin.RoutesInfo, methods customMethods, logger *zap.Logger) {
+	doc, undocumented := newOpenAPI(routes, methods)
+	for _, route := range undocumented {
+		logger.Warn("route missing from the OpenAPI document", zap.String("route", route))
+	}
+	spec, err := json.Marshal(doc)
+	if err != nil {
+		logger.Fatal("error encoding the OpenAPI document", zap.Error(err))
+	}
+	d.spec = spec
+}
+
+// handleSpec handles GET /openapi.json
+func (d *apiDocs) handleSpec(ctx *gin.Context) {
+	ctx.Data(http.StatusOK, "application/json; charset=utf-8", d.spec)
+}
+
+// handleUI handles GET /docs
+func (d *apiDocs) handleUI(ctx *gin.Context) {
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+}
+
+// handleScript handles GET /docs/docs.js
+func (d *apiDocs) handleScript(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=86400")
+	ctx.Data(http.StatusOK, "text/javascript; charset=utf-8", docsScript)
+}
+
+// newOpenAPI builds the document of the registered routes. It returns the
+// routes without an entry in operations, which are left out.
+func newOpenAPI(routes gin.RoutesInfo, methods customMethods) (*openapi.Document, []string) {
+	var keys []string
+	for _, r := range routes {
+		keys = append(keys, r.Method+" "+r.Path)
+	}
+	for key := range methods {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	gen := openapi.NewGenerator()
+	gen.Pattern("regexp", NamePattern)
+	doc := &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       "parte3",
+			Version:     apiVersion,
+			Description: "Users and sales. Every response can be negotiated with Accept as JSON, XML or MessagePack, and listings also as NDJSON or CSV.",
+		},
+		Paths: map[string]*openapi.PathItem{},
+		Components: openapi.Components{
+			SecuritySchemes: map[string]*openapi.SecurityScheme{
+				"bearer": {Type: "http", Scheme: "bearer", Description: "a token from /auth/token or an API key"},
+				"apiKey": {Type: "apiKey", In: "header", Name: apiKeyHeader},
+			},
+		},
+	}
+
+	var undocumented []string
+	for _, key := range keys {
+		op, ok := operations[key]
+		if !ok {
+			undocumented = append(undocumented, key)
+			continue
+		}
+		method, ginPath, _ := strings.Cut(key, " ")
+		p := openAPIPath(ginPath)
+		item := doc.Paths[p]
+		if item == nil {
+			item = &openapi.PathItem{}
+			doc.Paths[p] = item
+		}
+		(*item)[strings.ToLower(method)] = op.build(gen, method, ginPath)
+	}
+	doc.Components.Schemas = gen.Schemas()
+	return doc, undocumented
+}
+
+// openAPIPath converts a gin path, /users/:id, to /users/{id}.
+func openAPIPath(ginPath string) string {
+	parts := strings.Split(ginPath, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// build turns the entry into the OpenAPI operation of method and path.
+func (op operation) build(gen *openapi.Generator, method, ginPath string) *openapi.Operation {
+	out := &openapi.Operation{
+		OperationID: operationID(method, ginPath),
+		Summary:     op.summary,
+		Tags:        []string{op.tag},
+		Responses:   map[string]*openapi.Response{},
+		Security:    []map[string][]string{},
+	}
+	for _, part := range strings.Split(ginPath, "/") {
+		if strings.HasPrefix(part, ":") {
+			out.Parameters = append(out.Parameters, openapi.Parameter{
+				Name: part[1:], In: "path", Required: true, Schema: &openapi.Schema{Type: "string"},
+			})
+		}
+	}
+	out.Parameters = append(out.Parameters, op.params...)
+	if !op.public {
+		out.Security = []map[string][]string{{"bearer": {}}, {"apiKey": {}}}
+	}
+
+	if op.body != nil {
+		formats := op.bodyMIME
+		if formats == nil {
+			formats = []string{mimeJSON, mimeXML, mimeMsgPack, mimeCSV}
+		}
+		out.RequestBody = &openapi.RequestBody{Required: true, Content: content(gen.Schema(op.body), formats)}
+	}
+
+	success := &openapi.Response{Description: http.StatusText(op.status)}
+	if op.response != nil {
+		formats := op.respMIME
+		switch {
+		case formats != nil:
+		case op.item != nil:
+			formats = []string{mimeJSON, mimeXML, mimeMsgPack}
+		default:
+			formats = objectFormats
+		}
+		success.Content = content(gen.Schema(op.response), formats)
+		if op.item != nil {
+			success.Content[mimeNDJSON] = &openapi.MediaType{Schema: gen.Schema(op.item)}
+			success.Content[mimeCSV] = &openapi.MediaType{Schema: &openapi.Schema{Type: "string"}}
+		}
+	}
+	out.Responses[strconv.Itoa(op.status)] = success
+
+	errs := append([]int{}, op.errors...)
+	if op.body != nil {
+		errs = append(errs, http.StatusBadRequest, http.StatusUnsupportedMediaType)
+	}
+	if !op.public {
+		errs = append(errs, http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests, http.StatusGatewayTimeout)
+	}
+	errs = append(errs, http.StatusInternalServerError)
+	for _, status := range errs {
+		code := strconv.Itoa(status)
+		if _, ok := out.Responses[code]; ok {
+			continue
+		}
+		out.Responses[code] = errorResponse(gen, status, op)
+	}
+	return out
+}
+
+// errorResponse documents status as an error of op. Authentication and
+// rate limiting answer RFC 7807 problems, /auth/token OAuth 2.0 errors,
+// the batches their per item results and the probes their report.
+func errorResponse(gen *openapi.Generator, status int, op operation) *openapi.Response {
+	resp := &openapi.Response{Description: http.StatusText(status)}
+	var body any = errorBody{}
+	format := mimeJSON
+	switch op.response.(type) {
+	case batchResponse, importResponse:
+		if status == http.StatusOK || status == http.StatusUnprocessableEntity {
+			body = op.response
+		}
+	case health.Report:
+		body = op.response
+	}
+	if _, ok := op.body.(tokenRequest); ok {
+		body = oauthErrorBody{}
+	}
+	switch status {
+	case http.StatusTooManyRequests:
+		body, format = problemDetails{}, mimeProblem
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if !op.public {
+			body, format = problemDetails{}, mimeProblem
+		}
+	}
+	resp.Content = content(gen.Schema(body), []string{format})
+	return resp
+}
+
+// content returns the same schema in every format.
+func content(schema *openapi.Schema, formats []string) map[string]*openapi.MediaType {
+	out := make(map[string]*openapi.MediaType, len(formats))
+	for _, f := range formats {
+		out[f] = &openapi.MediaType{Schema: schema}
+	}
+	return out
+}
+
+// operationID builds a stable camelCase ID from method and path, e.g.
+// getUsersById for GET /users/:id.
+func operationID(method, ginPath string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, word := range strings.FieldsFunc(ginPath, func(r rune) bool {
+		return r == '/' || r == '.' || r == '-' || r == '_'
+	}) {
+		if name, ok := strings.CutPrefix(word, ":"); ok {
+			word = "by" + strings.ToUpper(name[:1]) + name[1:]
+		} else if before, verb, ok := strings.Cut(word, ":"); ok {
+			word = before + strings.ToUpper(verb[:1]) + verb[1:]
+		}
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"parte3/internal/user"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// passwordError writes the response for the errors of the password flows.
+func (h *handler) passwordError(ctx *gin.Context, err error) {
+	switch {
+	case deny(ctx, forbidden(err)):
+	case errors.Is(err, user.ErrNotFound):
+		respond(ctx, http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, user.ErrWrongPassword):
+		respond(ctx, http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, user.ErrWeakPassword), errors.Is(err, user.ErrInvalidPasswordToken):
+		respond(ctx, http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, user.ErrNoEmail):
+		respond(ctx, http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.log(ctx).Error("error in password flow", zap.Error(err))
+		serverError(ctx, err)
+	}
+}
+
+// handleChangePassword handles PUT /users/:id/password
+func (h *handler) handleChangePassword(ctx *gin.Context) {
+	id := ctx.Param("id")
+	var req user.ChangePasswordRequest
+	if err := bindBody(ctx, &req); err != nil {
+		respond(ctx, bindStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userService.ChangePassword(ctx.Request.Context(), currentPrincipal(ctx), id, req.CurrentPassword, req.NewPassword); err != nil {
+		h.passwordError(ctx, err)
+		return
+	}
+	h.log(ctx).Info("password changed", zap.String("id", id))
+	ctx.Status(http.StatusNoContent)
+}
+
+// handleSendPasswordSetup handles POST /users/:id/password/setup
+func (h *handler) handleSendPasswordSetup(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if err := h.userService.SendPasswordSetup(ctx.Request.Context(), currentPrincipal(ctx), id); err != nil {
+		h.passwordError(ctx, err)
+		return
+	}
+	h.log(ctx).Info("password setup sent", zap.String("id", id))
+	ctx.Status(http.StatusAccepted)
+}
+
+// handleForgotPassword handles POST /auth/password/forgot
+// It always answers 202 so it cannot be used to find registered emails.
+func (h *handler) handleForgotPassword(ctx *gin.Context) {
+	var req user.ForgotPasswordRequest
+	if err := bindBody(ctx, &req); err != nil {
+		respond(ctx, bindStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userService.RequestPasswordReset(ctx.Request.Context(), req.Email); err != nil && !errors.Is(err, user.ErrNoEmail) {
+		h.log(ctx).Error("error sending password reset", zap.Error(err))
+	}
+	ctx.Status(http.StatusAccepted)
+}
+
+// handleResetPassword handles POST /auth/password/reset
+func (h *handler) handleResetPassword(ctx *gin.Context) {
+	var req user.ResetPasswordRequest
+	if err := bindBody(ctx, &req); err != nil {
+		respond(ctx, bindStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userService.ResetPassword(ctx.Request.Context(), req.Token, req.Password); err != nil {
+		h.passwordError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
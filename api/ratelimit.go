@@ -0,0 +1,51 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"parte3/internal/ratelimit"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitIdle is how long a client must be inactive before its buckets
+// are evicted.
+const rateLimitIdle = 10 * time.Minute
+
+// Límites por ruta. Cada cliente (clave, usuario o IP) tiene su propio
+// bucket en cada ruta; las rutas privadas además comparten defaultLimit.
+var (
+	defaultLimit     = ratelimit.PerSecond(50, 100)
+	createUserLimit  = ratelimit.PerSecond(2, 10)
+	createSaleLimit  = ratelimit.PerSecond(5, 20)
+	bulkLimit        = ratelimit.PerMinute(10, 5)  // batch e importaciones
+	credentialsLimit = ratelimit.PerMinute(10, 10) // token y contraseñas, por IP
+)
+
+// rateLimit rejects with 429 the requests of a client over limit on route.
+// Clients are identified like for idempotency keys, so it must run after
+// authenticate on private routes. Every response carries the RateLimit-*
+// headers of the bucket; when several limiters apply the last one wins.
+func rateLimit(store *ratelimit.LocalStorage, route string, limit ratelimit.Limit) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		d := store.Take(route+"\x00"+clientID(ctx), limit)
+		ctx.Header("RateLimit-Limit", strconv.Itoa(d.Limit))
+		ctx.Header("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		ctx.Header("RateLimit-Reset", ceilSeconds(d.Reset))
+		if !d.Allowed {
+			retry := ceilSeconds(d.RetryAfter)
+			ctx.Header("Retry-After", retry)
+			problem(ctx, http.StatusTooManyRequests, fmt.Sprintf("rate limit exceeded, retry in %s seconds", retry))
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// ceilSeconds formats d as whole seconds rounded up, as the headers expect.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
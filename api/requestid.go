@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"parte3/internal/logging"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// requestIDHeader carries the ID of the request in both directions.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits the IDs accepted from clients, anything else is
+// replaced so it cannot forge log lines or blow up their size.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestContext gives every request an ID, taken from X-Request-ID or
+// generated, and puts in its context a logger with that ID so the logs of
+// the handlers and services can be correlated. It writes one access log
+// line per request, also when the handler panics. It must go right after
+// the tracing middleware, whose trace ID it also adds to the logger.
+func requestContext(logger *zap.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		ctx.Header(requestIDHeader, id)
+
+		reqLogger := logger.With(zap.String("request_id", id))
+		span := trace.SpanFromContext(ctx.Request.Context())
+		if sc := span.SpanContext(); sc.IsValid() {
+			reqLogger = reqLogger.With(zap.String("trace_id", sc.TraceID().String()))
+		}
+		reqCtx := logging.NewContext(ctx.Request.Context(), reqLogger)
+		ctx.Request = ctx.Request.WithContext(logging.WithRequestID(reqCtx, id))
+
+		start := time.Now()
+		defer func() {
+			// aunque el handler entre en pánico hay línea de acceso, con el
+			// status que va a contestar gin.Recovery
+			rec := recover()
+			route := routeTemplate(ctx)
+			if ctx.FullPath() == "" {
+				// los métodos propios no tienen ruta en gin, el span se llama como la plantilla
+				span.SetName(route)
+			}
+			status := responseStatus(ctx, rec)
+			fields := []zap.Field{
+				zap.String("method", ctx.Request.Method),
+				zap.String("route", route),
+				zap.String("path", ctx.Request.URL.Path),
+				zap.Int("status", status),
+				zap.Duration("latency", time.Since(start)),
+				zap.Int("bytes", max(ctx.Writer.Size(), 0)),
+				zap.String("client_ip", ctx.ClientIP()),
+				zap.String("user_agent", ctx.Request.UserAgent()),
+			}
+			if principal := currentPrincipal(ctx); principal != nil {
+				fields = append(fields, zap.String("sub", principal.Subject), zap.String("sub_type", principal.Kind))
+			}
+			if len(ctx.Errors) > 0 {
+				fields = append(fields, zap.String("errors", ctx.Errors.String()))
+			}
+			if rec != nil {
+				fields = append(fields, zap.Any("panic", rec))
+			}
+			if status >= http.StatusInternalServerError {
+				reqLogger.Error("request", fields...)
+			} else {
+				reqLogger.Info("request", fields...)
+			}
+			if rec != nil {
+				panic(rec)
+			}
+		}()
+		ctx.Next()
+	}
+}
+
+// requestLogger returns the logger of the request, fallback outside of one.
+func requestLogger(ctx *gin.Context, fallback *zap.Logger) *zap.Logger {
+	return logging.FromContext(ctx.Request.Context(), fallback)
+}
+
+// log returns the logger of the request, with its ID.
+func (h *handler) log(ctx *gin.Context) *zap.Logger {
+	return requestLogger(ctx, h.logger)
+}
@@ -1,46 +1,204 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"os"
+	"parte3/internal/auth"
+	"parte3/internal/config"
+	"parte3/internal/health"
+	"parte3/internal/idempotency"
+	"parte3/internal/metrics"
+	"parte3/internal/notify"
+	"parte3/internal/ratelimit"
 	"parte3/internal/sale"
 	"parte3/internal/user"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.uber.org/zap"
 )
 
 // InitRoutes registers all user CRUD endpoints on the given Gin engine.
-// It initializes the storage, service, and handler, then binds each HTTP
-// method and path to the appropriate handler function.
-func InitRoutes(e *gin.Engine) {
-	// Initialize logger
-	logger, _ := zap.NewProduction()
-	defer logger.Sync()
+// It initializes the storage, service, and handler as described by cfg,
+// then binds each HTTP method and path to the appropriate handler function.
+// Every route except /ping, the /healthz and /readyz probes, /auth/token,
+// /auth/password/*, the JWKS and the /openapi.json and /docs documentation
+// requires an API key or a bearer token whose scopes or role grant the
+// route permission.
+// The caller owns logger and must Sync it at exit.
+func InitRoutes(e *gin.Engine, cfg *config.Config, logger *zap.Logger) {
+	// sin proxies de confianza ClientIP es la IP de la conexión, nadie
+	// puede elegir su bucket del rate limit con X-Forwarded-For
+	if err := e.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Fatal("error setting trusted proxies", zap.Error(err))
+	}
+	// primero, para trazar, loguear y medir también lo que se rechaza antes de llegar al handler
+	e.Use(otelgin.Middleware(cfg.Tracing.ServiceName))
+	e.Use(requestContext(logger))
+	var prom *metrics.Prometheus
+	if cfg.Features.Metrics {
+		prom = metrics.NewPrometheus()
+		e.Use(observeRequests(prom))
+	}
+	e.Use(requestTimeout(cfg.Server.RequestTimeout.Duration))
 
+	// cfg ya fue validado, memory es el único backend por ahora
 	storage := user.NewLocalStorage()
 	service := user.NewService(storage, logger)
+	if cfg.Notify.File != "" {
+		service.SetNotifier(notify.NewFileNotifier(cfg.Notify.File))
+	}
 	salesStorage := sale.NewLocalStorage()
 	salesService := sale.NewService(salesStorage, service, logger)
+	salesService.RequireSecondApproval(cfg.Sales.SecondApprovalAbove)
+	if prom != nil {
+		service.SetMetrics(prom)
+		salesService.SetMetrics(prom)
+	}
+	idempotencyStorage := idempotency.NewLocalStorage(idempotencyTTL)
+	authService := auth.NewService(auth.NewLocalStorage(), logger)
+	bootstrapAdminKey(authService, cfg.Auth.BootstrapKey, logger)
+	tokenService := newTokenService(cfg.Auth, logger)
+	limits := ratelimit.NewLocalStorage(rateLimitIdle)
+
+	checks := health.NewRegistry(healthCheckTimeout)
+	service.RegisterChecks(checks)
+	salesService.RegisterChecks(checks)
+	checks.Register("idempotency.storage", health.Liveness, idempotencyStorage.Check)
+	if cfg.Features.RateLimit {
+		checks.Register("ratelimit.storage", health.Liveness, limits.Check)
+	}
+
 	// Initialize handler with services
 	h := handler{
-		userService: service,
-		logger:      logger,
-		saleService: salesService,
+		userService:   service,
+		logger:        logger,
+		saleService:   salesService,
+		authService:   authService,
+		tokenService:  tokenService,
+		importReports: newImportReportStore(),
+		health:        checks,
+	}
+	if cfg.Features.PasswordGrant {
+		h.passwordVerifier = service
+	}
+
+	idempotentPost := idempotent(idempotencyStorage, logger)
+	authn := authenticate(authService, tokenService, service, logger)
+	limit := func(route string, l ratelimit.Limit) gin.HandlerFunc {
+		if !cfg.Features.RateLimit {
+			return func(ctx *gin.Context) { ctx.Next() }
+		}
+		return rateLimit(limits, route, l)
+	}
+	bulk := limit("bulk", bulkLimit)
+	usersRead := requirePermission(auth.PermUsersReadAny, auth.PermUsersReadOwn)
+	usersList := requirePermission(auth.PermUsersReadAny)
+	usersCreate := requirePermission(auth.PermUsersCreate)
+	usersUpdate := requirePermission(auth.PermUsersUpdateAny, auth.PermUsersUpdateOwn)
+	usersCredentials := requirePermission(auth.PermUsersCredentials)
+	usersDelete := requirePermission(auth.PermUsersDelete)
+	usersRestore := requirePermission(auth.PermUsersRestore)
+	salesRead := requirePermission(auth.PermSalesReadAny, auth.PermSalesReadOwn)
+	salesCreate := requirePermission(auth.PermSalesCreateAny, auth.PermSalesCreateOwn)
+	salesImport := requirePermission(auth.PermSalesCreateAny)
+	importsRead := requirePermission(auth.PermUsersCreate, auth.PermSalesCreateAny)
+	salesTransition := requirePermission(auth.PermSalesTransition)
+	reportsRead := requirePermission(auth.PermReportsRead)
+	keysManage := requirePermission(auth.PermKeysManage)
+
+	private := e.Group("", authn, limit("default", defaultLimit))
+	private.POST("/users", usersCreate, limit("POST /users", createUserLimit), idempotentPost, h.handleCreate)
+	private.POST("/sales", salesCreate, limit("POST /sales", createSaleLimit), idempotentPost, h.handleCreateSale)
+	private.GET("/users/:id", usersRead, h.handleRead)
+	private.GET("/users", usersList, h.handleListActive)
+	private.GET("/sales/:id", salesRead, h.handleReadSales)
+	private.GET("/sales/:id/:status", salesRead, h.handleReadSalesWithStatus)
+	private.PATCH("/users/:id", usersUpdate, h.handleUpdate)
+	private.DELETE("/users/:id", usersDelete, h.handleDelete)
+	private.POST("/users/:id/restore", usersRestore, h.handleRestore)
+	private.PUT("/users/:id/password", usersUpdate, h.handleChangePassword)
+	private.POST("/users/:id/password/setup", usersCredentials, h.handleSendPasswordSetup)
+	private.PATCH("/sales/:id", salesTransition, h.handleUpdateSaleStatus)
+	private.GET("/reports/top-customers", reportsRead, h.handleTopCustomers)
+	if prom != nil {
+		private.GET("/metrics", reportsRead, gin.WrapH(prom.Handler()))
+	}
+	if cfg.Features.CSV {
+		private.GET("/users.csv", usersList, h.handleExportUsers)
+		private.GET("/sales.csv", salesRead, h.handleExportSales)
+		private.POST("/users/import", usersCreate, bulk, h.handleImportUsers)
+		private.POST("/sales/import", salesImport, bulk, h.handleImportSales)
+		private.GET("/imports/:id/errors.csv", importsRead, h.handleImportErrors)
 	}
 
-	e.POST("/users", h.handleCreate)
-	e.POST("/sales", h.handleCreateSale)
-	e.GET("/users/:id", h.handleRead)
-	e.GET("/users", h.handleListActive)
-	e.GET("/sales/:id", h.handleReadSales)
-	e.GET("/sales/:id/:status", h.handleReadSalesWithStatus)
-	e.PATCH("/users/:id", h.handleUpdate)
-	e.DELETE("/users/:id", h.handleDelete)
-	e.PATCH("/sales/:id", h.handleUpdateSaleStatus)
+	private.POST("/auth/keys", keysManage, h.handleIssueKey)
+	private.GET("/auth/keys", keysManage, h.handleListKeys)
+	private.POST("/auth/keys/:id/rotate", keysManage, h.handleRotateKey)
+	private.DELETE("/auth/keys/:id", keysManage, h.handleRevokeKey)
+	private.POST("/auth/jwks/rotate", keysManage, h.handleRotateSigningKey)
 
+	e.POST("/auth/token", limit("POST /auth/token", credentialsLimit), h.handleIssueToken)
+	e.POST("/auth/password/forgot", limit("POST /auth/password/forgot", credentialsLimit), h.handleForgotPassword)
+	e.POST("/auth/password/reset", limit("POST /auth/password/reset", credentialsLimit), h.handleResetPassword)
+	e.GET("/.well-known/jwks.json", h.handleJWKS)
+
+	// gin no permite registrar rutas con ":" dentro de un segmento
+	methods := customMethods{}
+	if cfg.Features.Batch {
+		methods["POST /users:batch"] = gin.HandlersChain{authn, usersCreate, bulk, h.handleCreateUsersBatch}
+		methods["POST /sales:batch"] = gin.HandlersChain{authn, salesImport, bulk, h.handleCreateSalesBatch}
+	}
+	e.NoRoute(methods.handle)
+
+	e.GET("/healthz", h.handleHealthz)
+	e.GET("/readyz", h.handleReadyz)
 	e.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "pong",
 		})
 	})
+
+	// al final, para que el documento cubra todas las rutas
+	docs := &apiDocs{}
+	e.GET("/openapi.json", docs.handleSpec)
+	e.GET("/docs", docs.handleUI)
+	e.GET("/docs/docs.js", docs.handleScript)
+	docs.build(e.Routes(), methods, logger)
+}
+
+// bootstrapAdminKey registers the configured admin key or, when there is
+// none, generates one and prints it once to stderr so the operator can use
+// it. The key never goes to the logs.
+func bootstrapAdminKey(service *auth.Service, secret string, logger *zap.Logger) {
+	if secret != "" {
+		if _, err := service.Bootstrap("bootstrap", secret); err != nil {
+			logger.Fatal("error registering bootstrap api key", zap.Error(err))
+		}
+		return
+	}
+
+	issued, err := service.Issue("bootstrap", []string{auth.ScopeAdmin})
+	if err != nil {
+		logger.Fatal("error generating bootstrap api key", zap.Error(err))
+	}
+	logger.Warn("generated bootstrap admin api key, printed to stderr; set API_BOOTSTRAP_KEY to choose it")
+	fmt.Fprintf(os.Stderr, "bootstrap admin api key: %s\n", issued.Key)
+}
+
+// newTokenService loads the signing keys from the configured JWKS file or,
+// when there is none, generates in-memory keys that last until the process exits.
+func newTokenService(cfg config.AuthConfig, logger *zap.Logger) *auth.TokenService {
+	var keys *auth.KeySet
+	var err error
+	if cfg.JWKSFile != "" {
+		keys, err = auth.LoadKeySet(cfg.JWKSFile, cfg.JWTAlg)
+	} else {
+		keys, err = auth.NewKeySet(cfg.JWTAlg)
+	}
+	if err != nil {
+		logger.Fatal("error loading jwt signing keys", zap.Error(err))
+	}
+	return auth.NewTokenService(keys, cfg.JWTIssuer, cfg.JWTTTL.Duration)
 }
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusClientClosedRequest is the status nginx logs when the client went
+// away before the response, nobody reads it but the logs and metrics.
+const statusClientClosedRequest = 499
+
+// requestTimeout gives the context of every request a deadline, so the
+// services and storages stop working on it once it passes. 0 disables it.
+func requestTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if timeout <= 0 {
+			ctx.Next()
+			return
+		}
+		reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+		ctx.Request = ctx.Request.WithContext(reqCtx)
+		ctx.Next()
+	}
+}
+
+// errorStatus is the status of an error no handler expected: 504 when the
+// request ran out of time, 499 when the client disconnected and 500 otherwise.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// serverError answers an unexpected error with errorStatus.
+func serverError(ctx *gin.Context, err error) {
+	respond(ctx, errorStatus(err), gin.H{"error": err.Error()})
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"parte3/internal/auth"
+	"parte3/internal/user"
+)
+
+// Token is an access token issued by /auth/token.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"` // segundos
+	Scope       string `json:"scope"`
+}
+
+// PasswordToken exchanges the login and password of a user for a token,
+// pass its AccessToken to SetCredential to act as the user.
+// Wrong credentials return ErrInvalidCredentials.
+func (c *Client) PasswordToken(ctx context.Context, login, password string) (*Token, error) {
+	return c.token(ctx, url.Values{"grant_type": {"password"}, "username": {login}, "password": {password}})
+}
+
+// ClientCredentialsToken exchanges an API key for a token.
+func (c *Client) ClientCredentialsToken(ctx context.Context, apiKey string) (*Token, error) {
+	return c.token(ctx, url.Values{"grant_type": {"client_credentials"}, "client_secret": {apiKey}})
+}
+
+func (c *Client) token(ctx context.Context, form url.Values) (*Token, error) {
+	var t Token
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/auth/token", form: form, idempotent: true}, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ForgotPassword asks for a password reset token to be sent to email. It
+// succeeds whether the email is registered or not.
+func (c *Client) ForgotPassword(ctx context.Context, email string) error {
+	body := user.ForgotPasswordRequest{Email: email}
+	return c.do(ctx, request{method: http.MethodPost, path: "/auth/password/forgot", body: body}, nil)
+}
+
+// ResetPassword sets a password with a setup or reset token, an invalid
+// or used token returns ErrInvalidPasswordToken.
+func (c *Client) ResetPassword(ctx context.Context, token, password string) error {
+	body := user.ResetPasswordRequest{Token: token, Password: password}
+	return c.do(ctx, request{method: http.MethodPost, path: "/auth/password/reset", body: body}, nil)
+}
+
+// IssueKey creates an API key with scopes, e.g. "users:read". The plain
+// key is only in the result. It is not retried: a retry would issue two.
+func (c *Client) IssueKey(ctx context.Context, name string, scopes []string) (*IssuedKey, error) {
+	var k IssuedKey
+	body := auth.IssueKeyRequest{Name: name, Scopes: scopes}
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/auth/keys", body: body}, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// ListKeys returns the API keys, revoked ones included, without the keys.
+func (c *Client) ListKeys(ctx context.Context) ([]*APIKey, error) {
+	var keys []*APIKey
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/auth/keys", idempotent: true}, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RotateKey replaces the key id with a new one, the old key and its tokens
+// stop working. It is not retried.
+func (c *Client) RotateKey(ctx context.Context, id string) (*IssuedKey, error) {
+	var k IssuedKey
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/auth/keys/" + url.PathEscape(id) + "/rotate"}, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// RevokeKey revokes the key id and its tokens. It is not retried: a
+// repeated revocation fails with ErrAlreadyRevoked.
+func (c *Client) RevokeKey(ctx context.Context, id string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/auth/keys/" + url.PathEscape(id)}, nil)
+}
+
+// RotateSigningKey makes the server sign the new tokens with a new key and
+// returns its kid. The tokens already issued keep verifying for a few
+// more rotations.
+func (c *Client) RotateSigningKey(ctx context.Context) (string, error) {
+	var body struct {
+		Kid string `json:"kid"`
+	}
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/auth/jwks/rotate"}, &body); err != nil {
+		return "", err
+	}
+	return body.Kid, nil
+}
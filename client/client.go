@@ -0,0 +1,236 @@
+// Package client is a typed Go client of the parte3 API.
+//
+//	c := client.New("http://localhost:8080", apiKey)
+//	u, err := c.CreateUser(ctx, client.CreateUserRequest{Name: "Juana", ...})
+//	if errors.Is(err, client.ErrConflict) { ... }
+//
+// The types of the API and the sentinel errors of the server are exported
+// here, the server keeps its own in internal packages. The error responses
+// are decoded into *Error, which wraps the sentinel error (ErrUserNotFound,
+// ErrSaleMustBePending, ...) so they can be checked with errors.Is and
+// errors.As like in the server.
+// Reads, deletes and creations are retried with exponential backoff on
+// network errors, 429, 502, 503 and 504; creations send an Idempotency-Key
+// so a retry never creates twice.
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetryPolicy is how many times and how often a call is retried.
+type RetryPolicy struct {
+	MaxAttempts int           // intentos en total, 1 no reintenta
+	MinBackoff  time.Duration // espera antes del primer reintento
+	MaxBackoff  time.Duration // tope de la espera, se duplica en cada intento
+}
+
+// DefaultRetryPolicy is used unless SetRetryPolicy says otherwise.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, MinBackoff: 100 * time.Millisecond, MaxBackoff: 2 * time.Second}
+
+// Client calls the API. It is safe for concurrent use, also with the Set
+// methods; a call keeps the settings it started with.
+type Client struct {
+	baseURL string
+
+	mu         sync.RWMutex // protege lo que cambian los Set
+	credential string
+	httpClient *http.Client
+	retry      RetryPolicy
+}
+
+// New creates a Client of the API at baseURL authenticated with credential,
+// an API key or a token from /auth/token. An empty credential only reaches
+// the public routes.
+func New(baseURL, credential string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		credential: credential,
+		httpClient: http.DefaultClient,
+		retry:      DefaultRetryPolicy,
+	}
+}
+
+// SetHTTPClient replaces http.DefaultClient, e.g. to set timeouts or a
+// transport with tracing.
+func (c *Client) SetHTTPClient(hc *http.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.httpClient = hc
+}
+
+// SetRetryPolicy replaces DefaultRetryPolicy.
+func (c *Client) SetRetryPolicy(p RetryPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retry = p
+}
+
+// SetCredential replaces the API key or token sent with every call, e.g.
+// after exchanging it with Token.
+func (c *Client) SetCredential(credential string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.credential = credential
+}
+
+// request is one API call.
+type request struct {
+	method      string
+	path        string // ya escapado
+	query       url.Values
+	body        any // se envía como JSON, nil sin cuerpo
+	form        url.Values
+	csv         []byte // se envía como text/csv
+	accept      string // vacío pide JSON
+	idempotent  bool   // se puede reintentar
+	idempotency bool   // envía Idempotency-Key, y entonces se puede reintentar
+	result      int    // status de error que trae la respuesta y no es un error, p. ej. 422 en los batches
+}
+
+// do sends req, retrying it when allowed, and decodes the response into
+// out unless it is nil; an io.Writer out gets the body as is. Error
+// responses are returned as *Error.
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	var body []byte
+	contentType := ""
+	switch {
+	case req.form != nil:
+		body, contentType = []byte(req.form.Encode()), "application/x-www-form-urlencoded"
+	case req.csv != nil:
+		body, contentType = req.csv, "text/csv"
+	case req.body != nil:
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+	key := ""
+	if req.idempotency {
+		key = uuid.NewString() // la misma clave en todos los intentos
+	}
+
+	// los Set pueden cambiarlos mientras tanto, cada llamada usa los de su inicio
+	c.mu.RLock()
+	credential, httpClient, retry := c.credential, c.httpClient, c.retry
+	c.mu.RUnlock()
+
+	attempts := 1
+	if req.idempotent || req.idempotency {
+		attempts = max(retry.MaxAttempts, 1)
+	}
+	backoff := retry.MinBackoff
+	for attempt := 1; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		httpReq.Header.Set("Accept", cmp.Or(req.accept, "application/json"))
+		if contentType != "" {
+			httpReq.Header.Set("Content-Type", contentType)
+		}
+		if credential != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+credential)
+		}
+		if key != "" {
+			httpReq.Header.Set("Idempotency-Key", key)
+		}
+
+		resp, err := httpClient.Do(httpReq)
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil || attempt == attempts {
+				return err
+			}
+		case retryable(resp.StatusCode) && attempt < attempts:
+			wait = retryAfter(resp)
+			drain(resp)
+		default:
+			defer drain(resp)
+			if req.result != 0 && resp.StatusCode == req.result {
+				return json.NewDecoder(resp.Body).Decode(out)
+			}
+			return decode(resp, out)
+		}
+
+		wait = max(wait, jitter(backoff))
+		backoff = min(backoff*2, retry.MaxBackoff)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether a call answered with status can succeed later.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter reads the Retry-After seconds of a 429 or 503, 0 without it.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// jitter spreads d between d/2 and d so clients do not retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d < 2 {
+		return d
+	}
+	return d/2 + rand.N(d/2)
+}
+
+// drain reads what is left of the body so the connection can be reused.
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
+
+// decode decodes a successful response into out, or the error response.
+func decode(resp *http.Response, out any) error {
+	if resp.StatusCode >= http.StatusBadRequest {
+		return decodeError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if w, ok := out.(io.Writer); ok {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"parte3/api"
+	"parte3/internal/config"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testAdminKey = "sk_test_admin_key"
+
+// nuevoServidor levanta la API completa con la clave de admin de los tests.
+func nuevoServidor(t *testing.T) http.Handler {
+	gin.SetMode(gin.TestMode)
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		name := regexp.MustCompile(api.NamePattern)
+		v.RegisterValidation("regexp", func(fl validator.FieldLevel) bool {
+			return name.MatchString(fl.Field().String())
+		})
+	}
+	cfg := config.Default()
+	cfg.Server.Mode = gin.TestMode
+	cfg.Auth.BootstrapKey = testAdminKey
+	engine := gin.New()
+	api.InitRoutes(engine, cfg, zap.NewNop())
+	return engine
+}
+
+func nuevoCliente(t *testing.T, h http.Handler) *Client {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	c := New(srv.URL, testAdminKey)
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+	return c
+}
+
+func TestClient_UsuariosYVentas(t *testing.T) {
+	c := nuevoCliente(t, nuevoServidor(t))
+	ctx := context.Background()
+
+	u, err := c.CreateUser(ctx, CreateUserRequest{
+		Name:    "Juana Perez",
+		Address: &Address{Street: "Corrientes", Number: "1234", City: "CABA", Country: "AR"},
+		Email:   "juana@example.com",
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, u.ID)
+
+	got, err := c.GetUser(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, "juana@example.com", got.Email)
+
+	nick := "Juani"
+	got, err = c.UpdateUser(ctx, u.ID, UpdateFields{NickName: &nick, Address: &u.Addresses[0]})
+	require.NoError(t, err)
+	require.Equal(t, nick, got.NickName)
+
+	users, err := c.ListUsers(ctx)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+
+	// ventas hasta tener una pendiente, el estado inicial es al azar
+	var pendiente *Sale
+	for i := 0; i < 50 && pendiente == nil; i++ {
+		s, err := c.CreateSale(ctx, u.ID, 100)
+		require.NoError(t, err)
+		if s.Status == "pending" {
+			pendiente = s
+		}
+	}
+	require.NotNil(t, pendiente)
+
+	aprobada, err := c.UpdateSaleStatus(ctx, pendiente.ID, "approved")
+	require.NoError(t, err)
+	require.Equal(t, "approved", aprobada.Status)
+	_, err = c.UpdateSaleStatus(ctx, pendiente.ID, "rejected")
+	require.ErrorIs(t, err, ErrSaleMustBePending)
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusConflict, apiErr.StatusCode)
+
+	ventas, err := c.ListSales(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, len(ventas.Results), ventas.Metadata.Quantity)
+	aprobadas, err := c.ListSalesByStatus(ctx, u.ID, "approved")
+	require.NoError(t, err)
+	require.Equal(t, aprobadas.Metadata.Approved, len(aprobadas.Results))
+
+	conResumen, err := c.GetUserWithSummary(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, u.ID, conResumen.ID)
+	require.Equal(t, ventas.Metadata.Quantity, conResumen.SalesSummary.Quantity)
+
+	ranking, err := c.TopCustomers(ctx, TopCustomersQuery{By: "count", Limit: 5})
+	require.NoError(t, err)
+	require.Len(t, ranking, 1)
+
+	require.NoError(t, c.DeleteUser(ctx, u.ID))
+	_, err = c.GetUser(ctx, u.ID)
+	require.ErrorIs(t, err, ErrUserNotFound)
+	restaurado, err := c.RestoreUser(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, u.ID, restaurado.ID)
+}
+
+func TestClient_Errores(t *testing.T) {
+	c := nuevoCliente(t, nuevoServidor(t))
+	ctx := context.Background()
+
+	_, err := c.CreateSale(ctx, "no-existe", 10)
+	require.ErrorIs(t, err, ErrSaleUserNotFound)
+
+	req := CreateUserRequest{Name: "Ana", Address: &Address{Street: "Mitre 1"}, Email: "ana@example.com"}
+	_, err = c.CreateUser(ctx, req)
+	require.NoError(t, err)
+	_, err = c.CreateUser(ctx, req)
+	require.ErrorIs(t, err, ErrConflict)
+	var conflicto *ConflictError
+	require.ErrorAs(t, err, &conflicto)
+	require.Equal(t, "email", conflicto.Field)
+
+	// los problemas RFC 7807 de autenticación
+	_, err = New(c.baseURL, "").ListUsers(ctx)
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	require.Equal(t, "missing credentials", apiErr.Message)
+
+	_, err = c.PasswordToken(ctx, "ana@example.com", "incorrecta")
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// los batches devuelven los resultados aunque se deshagan
+	result, err := c.CreateSales(ctx, []CreateSaleRequest{{UserID: "no-existe", Amount: 1}}, AllOrNothing)
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Failed)
+	require.ErrorIs(t, result.Results[0].Err(), ErrSaleUserNotFound)
+}
+
+func TestClient_Reintentos(t *testing.T) {
+	var intentos atomic.Int32
+	var claves []string
+	servidor := nuevoServidor(t)
+	inestable := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users" && r.Method == http.MethodPost {
+			claves = append(claves, r.Header.Get("Idempotency-Key"))
+		}
+		if intentos.Add(1)%3 != 0 { // falla dos de cada tres
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		servidor.ServeHTTP(w, r)
+	})
+	c := nuevoCliente(t, inestable)
+	ctx := context.Background()
+
+	// las creaciones se reintentan con la misma Idempotency-Key
+	u, err := c.CreateUser(ctx, CreateUserRequest{Name: "Ana", Address: &Address{Street: "Mitre 1"}})
+	require.NoError(t, err)
+	require.Len(t, claves, 3)
+	require.NotEmpty(t, claves[0])
+	require.Equal(t, claves[0], claves[2])
+
+	_, err = c.GetUser(ctx, u.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 6, intentos.Load())
+
+	// lo que no es idempotente no se reintenta
+	_, err = c.UpdateSaleStatus(ctx, "cualquiera", "approved")
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	require.EqualValues(t, 7, intentos.Load())
+
+	// el contexto corta la espera entre intentos
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 5, MinBackoff: time.Hour, MaxBackoff: time.Hour})
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = c.GetUser(ctx, u.ID)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+// TestClient_SetConcurrente cambia la credencial mientras hay llamadas en
+// curso, go test -race falla si los Set no están sincronizados.
+func TestClient_SetConcurrente(t *testing.T) {
+	c := nuevoCliente(t, nuevoServidor(t))
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				_, err := c.ListUsers(ctx)
+				errs <- err
+			}
+		}()
+	}
+	for range 10 {
+		c.SetCredential(testAdminKey)
+		c.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+		c.SetHTTPClient(http.DefaultClient)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestClient_ClavesCSVYSalud(t *testing.T) {
+	c := nuevoCliente(t, nuevoServidor(t))
+	ctx := context.Background()
+
+	// claves: la emitida sirve hasta que se rota o se revoca
+	issued, err := c.IssueKey(ctx, "lector", []string{"users:read"})
+	require.NoError(t, err)
+	require.NotEmpty(t, issued.Key)
+	lector := New(c.baseURL, issued.Key)
+	_, err = lector.ListUsers(ctx)
+	require.NoError(t, err)
+	_, err = lector.IssueKey(ctx, "otra", []string{"users:read"})
+	require.ErrorIs(t, err, ErrForbidden)
+
+	keys, err := c.ListKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 2) // con la de admin
+
+	rotated, err := c.RotateKey(ctx, issued.ID)
+	require.NoError(t, err)
+	require.NotEqual(t, issued.Key, rotated.Key)
+	_, err = lector.ListUsers(ctx)
+	require.ErrorIs(t, err, ErrInvalidKey)
+	require.NoError(t, c.RevokeKey(ctx, issued.ID))
+	require.ErrorIs(t, c.RevokeKey(ctx, issued.ID), ErrAlreadyRevoked)
+	require.ErrorIs(t, c.RevokeKey(ctx, "no-existe"), ErrKeyNotFound)
+	_, err = c.IssueKey(ctx, "mala", []string{"nada"})
+	require.ErrorIs(t, err, ErrInvalidScope)
+
+	kid, err := c.RotateSigningKey(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, kid)
+
+	// CSV: la fila inválida queda en el reporte de errores
+	imported, err := c.ImportUsers(ctx, strings.NewReader("nombre,address\nAna,Mitre 1\nAna 2,Mitre 2\n"), ImportOptions{
+		Mode:    BestEffort,
+		Columns: map[string]string{"nombre": "name"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, imported.Created)
+	require.Equal(t, 1, imported.Failed)
+	require.NotNil(t, imported.Results[0].Data)
+	require.NotEmpty(t, imported.ErrorReport)
+
+	var report bytes.Buffer
+	require.NoError(t, c.ImportErrors(ctx, imported.ErrorReport, &report))
+	require.Contains(t, report.String(), "Ana 2")
+	var apiErr *Error
+	require.ErrorAs(t, c.ImportErrors(ctx, "no-existe", io.Discard), &apiErr)
+	require.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+
+	sales, err := c.ImportSales(ctx, strings.NewReader("user_id,amount\n"+imported.Results[0].Data.ID+",10\nno-existe,5\n"), ImportOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 0, sales.Created, "all_or_nothing por defecto")
+	require.ErrorIs(t, sales.Results[1].Err(), ErrSaleUserNotFound)
+
+	var users bytes.Buffer
+	require.NoError(t, c.ExportUsers(ctx, &users, true))
+	require.True(t, strings.HasPrefix(users.String(), "id,"), users.String())
+	require.Contains(t, users.String(), "Ana")
+	var ventas bytes.Buffer
+	require.NoError(t, c.ExportSales(ctx, &ventas, imported.Results[0].Data.ID, "approved"))
+	require.Equal(t, 1, strings.Count(ventas.String(), "\n"), "solo el encabezado")
+	require.ErrorIs(t, c.ExportSales(ctx, io.Discard, "", "cualquiera"), ErrInvalidStatus)
+
+	// salud y documentación
+	live, err := c.Liveness(ctx)
+	require.NoError(t, err)
+	require.True(t, live.Up())
+	require.NotEmpty(t, live.Checks)
+	ready, err := c.Readiness(ctx)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(ready.Checks), len(live.Checks))
+
+	doc, err := c.OpenAPI(ctx)
+	require.NoError(t, err)
+	require.Contains(t, string(doc), `"openapi"`)
+}
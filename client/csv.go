@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ImportOptions tunes a CSV import, the zero value uses the defaults of
+// the server.
+type ImportOptions struct {
+	Mode    string            // AllOrNothing o BestEffort
+	Columns map[string]string // columna del archivo -> campo, p. ej. {"correo": "email"}
+}
+
+func (o ImportOptions) query() url.Values {
+	query := batchQuery(o.Mode)
+	if len(o.Columns) > 0 {
+		pairs := make([]string, 0, len(o.Columns))
+		for column, field := range o.Columns {
+			pairs = append(pairs, column+":"+field)
+		}
+		sort.Strings(pairs)
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("map", strings.Join(pairs, ","))
+	}
+	return query
+}
+
+// ImportResult is the answer of a CSV import, like a batch. When a row
+// failed ErrorReport is the path of the report, see ImportErrors.
+type ImportResult[T any] struct {
+	BatchResult[T]
+	ErrorReport string `json:"error_report,omitempty"`
+}
+
+// ExportUsers writes the active users as CSV to w, with the columns of
+// their sales summary if withSummary.
+func (c *Client) ExportUsers(ctx context.Context, w io.Writer, withSummary bool) error {
+	var query url.Values
+	if withSummary {
+		query = url.Values{"include": {"sales_summary"}}
+	}
+	return c.do(ctx, request{method: http.MethodGet, path: "/users.csv", query: query, accept: "text/csv"}, w)
+}
+
+// ExportSales writes the sales as CSV to w. userID and status filter them
+// when they are not empty.
+func (c *Client) ExportSales(ctx context.Context, w io.Writer, userID, status string) error {
+	query := url.Values{}
+	if userID != "" {
+		query.Set("user_id", userID)
+	}
+	if status != "" {
+		query.Set("status", status)
+	}
+	return c.do(ctx, request{method: http.MethodGet, path: "/sales.csv", query: query, accept: "text/csv"}, w)
+}
+
+// ImportUsers creates the users of a CSV with the columns name, address,
+// nickname and email. It is not retried, like the batches.
+func (c *Client) ImportUsers(ctx context.Context, csv io.Reader, opts ImportOptions) (*ImportResult[User], error) {
+	var result ImportResult[User]
+	if err := c.importCSV(ctx, "/users/import", csv, opts, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ImportSales creates the sales of a CSV with the columns user_id and
+// amount. It is not retried, like the batches.
+func (c *Client) ImportSales(ctx context.Context, csv io.Reader, opts ImportOptions) (*ImportResult[Sale], error) {
+	var result ImportResult[Sale]
+	if err := c.importCSV(ctx, "/sales/import", csv, opts, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) importCSV(ctx context.Context, path string, csv io.Reader, opts ImportOptions, out any) error {
+	body, err := io.ReadAll(csv)
+	if err != nil {
+		return err
+	}
+	req := request{method: http.MethodPost, path: path, query: opts.query(), csv: body, result: http.StatusUnprocessableEntity}
+	return c.do(ctx, req, out)
+}
+
+// ImportErrors writes to w the rows that failed in an import, report is
+// its ErrorReport or the ID in it. Only who made the import can download
+// it, the rest get a 404.
+func (c *Client) ImportErrors(ctx context.Context, report string, w io.Writer) error {
+	id := strings.TrimSuffix(strings.TrimPrefix(report, "/imports/"), "/errors.csv")
+	path := "/imports/" + url.PathEscape(id) + "/errors.csv"
+	return c.do(ctx, request{method: http.MethodGet, path: path, accept: "text/csv", idempotent: true}, w)
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"parte3/internal/auth"
+	"parte3/internal/health"
+	"parte3/internal/sale"
+	"parte3/internal/user"
+)
+
+// Tipos de la API. Son alias de los del servidor, que están en paquetes
+// internal, para que los puedan usar los módulos que importan el cliente.
+type (
+	User              = user.User
+	Address           = user.Address
+	CreateUserRequest = user.CreateUserRequest
+	UpdateFields      = user.UpdateFields
+
+	Sale              = sale.Sale
+	CreateSaleRequest = sale.CreateSaleRequest
+	SalesMetadata     = sale.Metadata
+	SalesSummary      = sale.Summary
+	CustomerRank      = sale.CustomerRank
+
+	APIKey    = auth.APIKey
+	IssuedKey = auth.IssuedKey // la clave solo se ve al emitirla o rotarla
+
+	HealthReport = health.Report
+	HealthCheck  = health.Result
+)
+
+// ConflictError is wrapped by the *Error of a 409 with the field in use,
+// e.g. "email".
+type ConflictError = user.ConflictError
+
+// AddressError is wrapped by the *Error of an invalid address.
+type AddressError = user.AddressError
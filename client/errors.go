@@ -0,0 +1,151 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"parte3/internal/auth"
+	"parte3/internal/sale"
+	"parte3/internal/user"
+	"strings"
+)
+
+// Error is an error response of the API. When the message is one of the
+// sentinel errors of the server, Error wraps it:
+//
+//	errors.Is(err, client.ErrSaleMustBePending)
+//
+// Conflicts and invalid addresses wrap a *ConflictError or an
+// *AddressError with the field, like the server returns them.
+type Error struct {
+	StatusCode int
+	Message    string // error, detail de RFC 7807 o error_description de OAuth
+	Field      string // campo en conflicto o dirección inválida
+	err        error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Unwrap returns the sentinel error of the server, nil if unknown.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Errores del servidor que envuelve *Error, para errors.Is. Son los mismos
+// valores que usa el servidor; los batches de usuarios y ventas comparten
+// ErrBatchAborted.
+var (
+	ErrUserNotFound         = user.ErrNotFound
+	ErrEmptyUserID          = user.ErrEmptyID
+	ErrConflict             = user.ErrConflict // también *ConflictError con el campo
+	ErrInvalidAddress       = user.ErrInvalidAddress
+	ErrWeakPassword         = user.ErrWeakPassword
+	ErrWrongPassword        = user.ErrWrongPassword
+	ErrInvalidPasswordToken = user.ErrInvalidPasswordToken
+	ErrNoEmail              = user.ErrNoEmail
+	ErrAlreadyActive        = user.ErrAlreadyActive
+	ErrBatchAborted         = user.ErrBatchAborted
+
+	ErrSaleNotFound               = sale.ErrNotFound
+	ErrEmptySaleID                = sale.ErrEmptyID
+	ErrInvalidStatus              = sale.ErrInvalidStatus
+	ErrInvalidRankBy              = sale.ErrInvalidRankBy
+	ErrInvalidLimit               = sale.ErrInvalidLimit
+	ErrInvalidDateRange           = sale.ErrInvalidDateRange
+	ErrSaleUserNotFound           = sale.ErrUserNotFound
+	ErrInvalidAmount              = sale.ErrInvalidAmount
+	ErrSaleNotActive              = sale.ErrSaleNotActive
+	ErrInvalidSaleStateTransition = sale.ErrInvalidSaleStateTransition
+	ErrSaleMustBePending          = sale.ErrSaleMustBePending
+	ErrSelfApproval               = sale.ErrSelfApproval
+	ErrAlreadyApproved            = sale.ErrAlreadyApproved
+	ErrVersionConflict            = sale.ErrVersionConflict
+
+	ErrKeyNotFound        = auth.ErrNotFound
+	ErrInvalidToken       = auth.ErrInvalidToken
+	ErrInvalidCredentials = auth.ErrInvalidCredentials
+	ErrInvalidKey         = auth.ErrInvalidKey
+	ErrRevokedKey         = auth.ErrRevokedKey
+	ErrInvalidScope       = auth.ErrInvalidScope
+	ErrAlreadyRevoked     = auth.ErrAlreadyRevoked
+	ErrInvalidRole        = auth.ErrInvalidRole
+	ErrForbidden          = auth.ErrForbidden // cualquier 403
+)
+
+// sentinels are the errors of the server by message.
+var sentinels = map[string]error{}
+
+func init() {
+	for _, err := range []error{
+		ErrUserNotFound, ErrEmptyUserID, ErrConflict, ErrInvalidAddress,
+		ErrWeakPassword, ErrWrongPassword, ErrInvalidPasswordToken,
+		ErrNoEmail, ErrAlreadyActive, ErrBatchAborted,
+		ErrSaleNotFound, ErrEmptySaleID, ErrInvalidStatus, ErrInvalidRankBy,
+		ErrInvalidLimit, ErrInvalidDateRange, ErrSaleUserNotFound,
+		ErrInvalidAmount, ErrSaleNotActive, ErrInvalidSaleStateTransition,
+		ErrSaleMustBePending, ErrSelfApproval, ErrAlreadyApproved, ErrVersionConflict,
+		ErrKeyNotFound, ErrInvalidToken, ErrInvalidCredentials,
+		ErrInvalidKey, ErrRevokedKey, ErrInvalidScope, ErrAlreadyRevoked,
+		ErrInvalidRole,
+	} {
+		sentinels[err.Error()] = err
+	}
+}
+
+// errorBody covers the three error formats of the API: {"error": ...},
+// RFC 7807 problems and OAuth 2.0 errors.
+type errorBody struct {
+	Error            string `json:"error"`
+	Field            string `json:"field"`
+	Detail           string `json:"detail"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// decodeError builds the *Error of an error response.
+func decodeError(resp *http.Response) error {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var body errorBody
+	_ = json.Unmarshal(raw, &body) // un cuerpo inesperado deja el status
+	e := &Error{StatusCode: resp.StatusCode, Field: body.Field}
+	switch {
+	case body.Detail != "":
+		e.Message = body.Detail
+	case body.ErrorDescription != "":
+		e.Message = body.ErrorDescription
+	case body.Error != "":
+		e.Message = body.Error
+	default:
+		e.Message = strings.TrimSpace(string(raw))
+	}
+	e.err = sentinel(resp.StatusCode, e.Message, e.Field)
+	return e
+}
+
+// sentinel finds the server error behind message, nil if it is unknown.
+func sentinel(status int, message, field string) error {
+	if field != "" {
+		switch status {
+		case http.StatusConflict:
+			return &ConflictError{Field: field}
+		case http.StatusBadRequest:
+			reason := strings.TrimPrefix(message, "invalid address: "+field+" ")
+			return &AddressError{Field: field, Reason: reason}
+		}
+	}
+	if err, ok := sentinels[message]; ok {
+		return err
+	}
+	// errores envueltos con contexto: "invalid status: foo"
+	if prefix, _, ok := strings.Cut(message, ": "); ok {
+		if err, ok := sentinels[prefix]; ok {
+			return err
+		}
+	}
+	if status == http.StatusForbidden {
+		return ErrForbidden // "missing permission ..." y similares
+	}
+	return nil
+}
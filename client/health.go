@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// Liveness runs the liveness probe, GET /healthz. A failed check is not an
+// error: the report is down and says which one failed. Probes are not
+// retried.
+func (c *Client) Liveness(ctx context.Context) (*HealthReport, error) {
+	return c.health(ctx, "/healthz")
+}
+
+// Readiness runs the readiness probe, GET /readyz, like Liveness.
+func (c *Client) Readiness(ctx context.Context) (*HealthReport, error) {
+	return c.health(ctx, "/readyz")
+}
+
+func (c *Client) health(ctx context.Context, path string) (*HealthReport, error) {
+	var report HealthReport
+	req := request{method: http.MethodGet, path: path, result: http.StatusServiceUnavailable}
+	if err := c.do(ctx, req, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// OpenAPI returns the OpenAPI document of the API.
+func (c *Client) OpenAPI(ctx context.Context) (json.RawMessage, error) {
+	var doc json.RawMessage
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/openapi.json", idempotent: true}, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"parte3/internal/sale"
+	"strconv"
+	"time"
+)
+
+// Sales is a page of sales of a user with the metadata of all of them.
+type Sales struct {
+	Metadata *SalesMetadata `json:"metadata"`
+	Results  []*Sale        `json:"results"`
+}
+
+// TopCustomersQuery filters the top customers report, the zero value of
+// each field leaves it to the server.
+type TopCustomersQuery struct {
+	From  time.Time // primer día, inclusive
+	To    time.Time // último día, inclusive
+	By    string    // amount o count
+	Limit int
+}
+
+// CreateSale creates a sale of userID, the server chooses its status. It is
+// retried with the same Idempotency-Key.
+func (c *Client) CreateSale(ctx context.Context, userID string, amount float64) (*Sale, error) {
+	var s Sale
+	body := CreateSaleRequest{UserID: userID, Amount: amount}
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/sales", body: body, idempotency: true}, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ListSales returns the sales of userID and their metadata.
+func (c *Client) ListSales(ctx context.Context, userID string) (*Sales, error) {
+	return c.listSales(ctx, "/sales/"+url.PathEscape(userID))
+}
+
+// ListSalesByStatus returns the sales of userID with status and their metadata.
+func (c *Client) ListSalesByStatus(ctx context.Context, userID, status string) (*Sales, error) {
+	return c.listSales(ctx, "/sales/"+url.PathEscape(userID)+"/"+url.PathEscape(status))
+}
+
+func (c *Client) listSales(ctx context.Context, path string) (*Sales, error) {
+	var sales Sales
+	if err := c.do(ctx, request{method: http.MethodGet, path: path, idempotent: true}, &sales); err != nil {
+		return nil, err
+	}
+	return &sales, nil
+}
+
+// UpdateSaleStatus approves or rejects the pending sale id. It is not
+// retried: a repeated approval fails with ErrAlreadyApproved.
+func (c *Client) UpdateSaleStatus(ctx context.Context, id, status string) (*Sale, error) {
+	var s Sale
+	body := sale.UpdateSale{Status: status}
+	if err := c.do(ctx, request{method: http.MethodPatch, path: "/sales/" + url.PathEscape(id), body: body}, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// CreateSales creates many sales in one call, like CreateUsers.
+func (c *Client) CreateSales(ctx context.Context, reqs []CreateSaleRequest, mode string) (*BatchResult[Sale], error) {
+	var result BatchResult[Sale]
+	req := request{method: http.MethodPost, path: "/sales:batch", query: batchQuery(mode), body: reqs, result: http.StatusUnprocessableEntity}
+	if err := c.do(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// TopCustomers ranks the customers by their approved sales.
+func (c *Client) TopCustomers(ctx context.Context, q TopCustomersQuery) ([]*CustomerRank, error) {
+	query := url.Values{}
+	if !q.From.IsZero() {
+		query.Set("from", q.From.Format(time.DateOnly))
+	}
+	if !q.To.IsZero() {
+		query.Set("to", q.To.Format(time.DateOnly))
+	}
+	if q.By != "" {
+		query.Set("by", q.By)
+	}
+	if q.Limit != 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	var body struct {
+		Results []*CustomerRank `json:"results"`
+	}
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/reports/top-customers", query: query, idempotent: true}, &body); err != nil {
+		return nil, err
+	}
+	return body.Results, nil
+}
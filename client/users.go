@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"parte3/internal/user"
+)
+
+// UserWithSummary is a user with its sales summary, see GetUserWithSummary.
+type UserWithSummary struct {
+	*User
+	SalesSummary *SalesSummary `json:"sales_summary,omitempty"`
+}
+
+// Modos de los batches.
+const (
+	AllOrNothing = "all_or_nothing" // si un item falla no se crea ninguno
+	BestEffort   = "best_effort"    // se crean los items válidos
+)
+
+// BatchItem is the outcome of one item of a batch, Data is set when the
+// item was created.
+type BatchItem[T any] struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Data   *T     `json:"data,omitempty"`
+}
+
+// Err returns the error of the item decoded like the responses, nil if it
+// was created.
+func (i BatchItem[T]) Err() error {
+	if i.Error == "" {
+		return nil
+	}
+	return &Error{StatusCode: i.Status, Message: i.Error, err: sentinel(i.Status, i.Error, "")}
+}
+
+// BatchResult is the answer of a batch. An all_or_nothing batch that was
+// rolled back is not an error: Failed says how many items failed and
+// every other item has the code "aborted".
+type BatchResult[T any] struct {
+	Mode    string         `json:"mode"`
+	Created int            `json:"created"`
+	Failed  int            `json:"failed"`
+	Results []BatchItem[T] `json:"results"`
+}
+
+// CreateUser creates a user. It is retried with the same Idempotency-Key.
+func (c *Client) CreateUser(ctx context.Context, req CreateUserRequest) (*User, error) {
+	var u User
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/users", body: req, idempotency: true}, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUser returns the active user id.
+func (c *Client) GetUser(ctx context.Context, id string) (*User, error) {
+	var u User
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/users/" + url.PathEscape(id), idempotent: true}, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUserWithSummary returns the active user id with its sales summary.
+func (c *Client) GetUserWithSummary(ctx context.Context, id string) (*UserWithSummary, error) {
+	var u UserWithSummary
+	req := request{
+		method: http.MethodGet, path: "/users/" + url.PathEscape(id),
+		query: url.Values{"include": {"sales_summary"}}, idempotent: true,
+	}
+	if err := c.do(ctx, req, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ListUsers returns the active users.
+func (c *Client) ListUsers(ctx context.Context) ([]*User, error) {
+	var users []*User
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/users", idempotent: true}, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// UpdateUser changes the fields of fields that are not nil.
+func (c *Client) UpdateUser(ctx context.Context, id string, fields UpdateFields) (*User, error) {
+	var u User
+	if err := c.do(ctx, request{method: http.MethodPatch, path: "/users/" + url.PathEscape(id), body: fields}, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// DeleteUser deletes the user id, RestoreUser undoes it.
+func (c *Client) DeleteUser(ctx context.Context, id string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/users/" + url.PathEscape(id), idempotent: true}, nil)
+}
+
+// RestoreUser brings back the deleted user id.
+func (c *Client) RestoreUser(ctx context.Context, id string) (*User, error) {
+	var u User
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/users/" + url.PathEscape(id) + "/restore"}, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ChangePassword sets the password of the user id. current is required
+// when users change their own password.
+func (c *Client) ChangePassword(ctx context.Context, id, current, password string) error {
+	body := user.ChangePasswordRequest{CurrentPassword: current, NewPassword: password}
+	return c.do(ctx, request{method: http.MethodPut, path: "/users/" + url.PathEscape(id) + "/password", body: body}, nil)
+}
+
+// SendPasswordSetup sends the user id a token to set the password.
+func (c *Client) SendPasswordSetup(ctx context.Context, id string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/users/" + url.PathEscape(id) + "/password/setup"}, nil)
+}
+
+// CreateUsers creates many users in one call, mode is AllOrNothing or
+// BestEffort, "" for the default of the server (AllOrNothing). Batches are
+// not retried: the server does not keep their Idempotency-Key.
+func (c *Client) CreateUsers(ctx context.Context, reqs []CreateUserRequest, mode string) (*BatchResult[User], error) {
+	var result BatchResult[User]
+	req := request{method: http.MethodPost, path: "/users:batch", query: batchQuery(mode), body: reqs, result: http.StatusUnprocessableEntity}
+	if err := c.do(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// batchQuery sets the mode of a batch, if any.
+func batchQuery(mode string) url.Values {
+	if mode == "" {
+		return nil
+	}
+	return url.Values{"mode": {mode}}
+}
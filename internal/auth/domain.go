@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"time"
+)
+
+// Scopes que puede tener una API key.
+const (
+	ScopeUsersRead   = "users:read"
+	ScopeUsersWrite  = "users:write"
+	ScopeSalesRead   = "sales:read"
+	ScopeSalesWrite  = "sales:write"
+	ScopeReportsRead = "reports:read"
+	ScopeAdmin       = "admin" // Incluye todos los demás scopes
+)
+
+// AllScopes lists every valid scope.
+var AllScopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeSalesRead, ScopeSalesWrite, ScopeReportsRead, ScopeAdmin}
+
+// APIKey represents a credential issued to a client. Only the SHA-256 hash
+// of the secret is stored; the plain key is returned once when issued.
+type APIKey struct {
+	ID         string     `json:"id" xml:"id"`
+	Name       string     `json:"name" xml:"name"`
+	Prefix     string     `json:"prefix" xml:"prefix"` // Primeros caracteres de la clave, para identificarla
+	Hash       string     `json:"-" xml:"-"`
+	Scopes     []string   `json:"scopes" xml:"scopes>scope"`
+	CreatedAt  time.Time  `json:"created_at" xml:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" xml:"updated_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" xml:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" xml:"revoked_at,omitempty"`
+	Version    int        `json:"version" xml:"version"`
+}
+
+// Active reports whether the key can still be used.
+func (k *APIKey) Active() bool {
+	return k.RevokedAt == nil
+}
+
+// HasScope reports whether the key grants scope. The admin scope grants all.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// IssueKeyRequest is the payload accepted to issue a new API key.
+type IssueKeyRequest struct {
+	Name   string   `json:"name" xml:"name" binding:"required"`
+	Scopes []string `json:"scopes" xml:"scopes>scope" binding:"required,min=1"`
+}
+
+// IssuedKey is returned when a key is issued or rotated, it is the only
+// moment the plain key is visible.
+type IssuedKey struct {
+	*APIKey
+	Key string `json:"key" xml:"key"`
+}
+
+// Principal is the authenticated caller of a request, either an API key or
+// a user holding a token.
+type Principal struct {
+	Subject string         `json:"sub"`      // ID de la API key o del usuario
+	Kind    string         `json:"sub_type"` // SubjectAPIKey o SubjectUser
+	Role    string         `json:"role"`     // Rol del usuario, vacío para API keys
+	Scopes  []string       `json:"scopes"`
+	Claims  map[string]any `json:"-"` // Claims del token, nil para API keys
+
+	// KeyVersion is the version of the API key the principal comes from, so
+	// tokens minted before the key was rotated or revoked can be rejected.
+	KeyVersion int `json:"-"`
+}
+
+// HasScope reports whether the principal was granted scope. The admin scope grants all.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyPrincipal returns the principal of a request authenticated with key.
+func KeyPrincipal(key *APIKey) *Principal {
+	return &Principal{Subject: key.ID, Kind: SubjectAPIKey, Scopes: key.Scopes, KeyVersion: key.Version}
+}
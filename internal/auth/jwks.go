@@ -0,0 +1,415 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Algoritmos de firma soportados para los JWT.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// maxKeys is how many keys are kept after a rotation, older keys stop
+// verifying tokens once they fall out of the set.
+const maxKeys = 3
+
+// ErrUnknownKey is returned when a token was signed with a key not in the set.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// ErrUnsupportedAlg is returned for algorithms other than HS256, RS256 and EdDSA.
+var ErrUnsupportedAlg = errors.New("unsupported signing algorithm")
+
+// jwk is the JSON Web Key representation (RFC 7517) of the supported keys,
+// private members included when the key is written to the local file.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	K   string `json:"k,omitempty"`
+	D   string `json:"d,omitempty"`
+	P   string `json:"p,omitempty"`
+	Q   string `json:"q,omitempty"`
+	DP  string `json:"dp,omitempty"`
+	DQ  string `json:"dq,omitempty"`
+	QI  string `json:"qi,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set document.
+type JWKS struct {
+	Keys []jwk `json:"keys"`
+}
+
+// signingKey is a parsed key of the set.
+type signingKey struct {
+	kid     string
+	alg     string
+	secret  []byte        // HS256
+	private crypto.Signer // RS256 y EdDSA, nil si solo se conoce la clave pública
+	public  crypto.PublicKey
+}
+
+// KeySet holds the keys used to sign and verify tokens. The first key signs
+// new tokens and every key verifies. When backed by a file the set is saved
+// on rotation and reloaded when the file changes, so keys can be rotated by
+// replacing the file as well.
+type KeySet struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	keys    []*signingKey
+}
+
+var b64 = base64.RawURLEncoding
+
+// NewKeySet creates an in-memory key set with a fresh key for alg.
+func NewKeySet(alg string) (*KeySet, error) {
+	key, err := generateKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{keys: []*signingKey{key}}, nil
+}
+
+// LoadKeySet reads the JWKS file at path. If the file does not exist a key
+// for alg is generated and written to it.
+func LoadKeySet(path string, alg string) (*KeySet, error) {
+	ks := &KeySet{path: path}
+	if err := ks.load(); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		key, err := generateKey(alg)
+		if err != nil {
+			return nil, err
+		}
+		ks.keys = []*signingKey{key}
+		if err := ks.save(); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+// load parses the file, must be called with the write lock held or before
+// the set is shared.
+func (ks *KeySet) load() error {
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		return err
+	}
+	var doc JWKS
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid jwks file %s: %w", ks.path, err)
+	}
+	keys := make([]*signingKey, 0, len(doc.Keys))
+	for _, k := range doc.Keys {
+		key, err := parseJWK(k)
+		if err != nil {
+			return fmt.Errorf("invalid key %q in %s: %w", k.Kid, ks.path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("jwks file %s has no keys", ks.path)
+	}
+	ks.keys = keys
+	ks.modTime = info.ModTime()
+	return nil
+}
+
+// save writes the set with its private members, readable only by the owner.
+func (ks *KeySet) save() error {
+	doc := JWKS{}
+	for _, k := range ks.keys {
+		doc.Keys = append(doc.Keys, k.jwk(true))
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(ks.path), ".jwks-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), ks.path); err != nil {
+		return err
+	}
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return err
+	}
+	ks.modTime = info.ModTime()
+	return nil
+}
+
+// refresh reloads the file if it changed since it was read.
+func (ks *KeySet) refresh() error {
+	if ks.path == "" {
+		return nil
+	}
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if info.ModTime().Equal(ks.modTime) {
+		return nil
+	}
+	return ks.load()
+}
+
+// Rotate generates a new signing key with the algorithm of the current one.
+// The previous keys keep verifying until maxKeys rotations later.
+func (ks *KeySet) Rotate() (string, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, err := generateKey(ks.keys[0].alg)
+	if err != nil {
+		return "", err
+	}
+	keys := append([]*signingKey{key}, ks.keys...)
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+	}
+	previous := ks.keys
+	ks.keys = keys
+	if ks.path != "" {
+		if err := ks.save(); err != nil {
+			ks.keys = previous
+			return "", err
+		}
+	}
+	return key.kid, nil
+}
+
+// current returns the key that signs new tokens.
+func (ks *KeySet) current() *signingKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[0]
+}
+
+// lookup finds the key with kid, reloading the file once if it is missing.
+func (ks *KeySet) lookup(kid string) (*signingKey, error) {
+	find := func() *signingKey {
+		ks.mu.RLock()
+		defer ks.mu.RUnlock()
+		for _, k := range ks.keys {
+			if k.kid == kid {
+				return k
+			}
+		}
+		return nil
+	}
+	if k := find(); k != nil {
+		return k, nil
+	}
+	if err := ks.refresh(); err != nil {
+		return nil, err
+	}
+	if k := find(); k != nil {
+		return k, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// Public returns the public keys of the set. HS256 secrets are never published.
+func (ks *KeySet) Public() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	doc := JWKS{Keys: []jwk{}}
+	for _, k := range ks.keys {
+		if k.alg == AlgHS256 {
+			continue
+		}
+		doc.Keys = append(doc.Keys, k.jwk(false))
+	}
+	return doc
+}
+
+func newKid() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func generateKey(alg string) (*signingKey, error) {
+	kid, err := newKid()
+	if err != nil {
+		return nil, err
+	}
+	key := &signingKey{kid: kid, alg: alg}
+	switch alg {
+	case AlgHS256:
+		key.secret = make([]byte, 32)
+		if _, err := rand.Read(key.secret); err != nil {
+			return nil, err
+		}
+	case AlgRS256:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		key.private, key.public = private, &private.PublicKey
+	case AlgEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key.private, key.public = private, public
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlg, alg)
+	}
+	return key, nil
+}
+
+func encodeInt(i *big.Int) string {
+	return b64.EncodeToString(i.Bytes())
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := b64.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// jwk encodes the key, with its private members if withPrivate is true.
+func (k *signingKey) jwk(withPrivate bool) jwk {
+	out := jwk{Kid: k.kid, Alg: k.alg, Use: "sig"}
+	switch k.alg {
+	case AlgHS256:
+		out.Kty = "oct"
+		if withPrivate {
+			out.K = b64.EncodeToString(k.secret)
+		}
+	case AlgRS256:
+		public := k.public.(*rsa.PublicKey)
+		out.Kty = "RSA"
+		out.N = encodeInt(public.N)
+		out.E = encodeInt(big.NewInt(int64(public.E)))
+		if private, ok := k.private.(*rsa.PrivateKey); ok && withPrivate {
+			out.D = encodeInt(private.D)
+			out.P = encodeInt(private.Primes[0])
+			out.Q = encodeInt(private.Primes[1])
+			out.DP = encodeInt(private.Precomputed.Dp)
+			out.DQ = encodeInt(private.Precomputed.Dq)
+			out.QI = encodeInt(private.Precomputed.Qinv)
+		}
+	case AlgEdDSA:
+		out.Kty = "OKP"
+		out.Crv = "Ed25519"
+		out.X = b64.EncodeToString(k.public.(ed25519.PublicKey))
+		if private, ok := k.private.(ed25519.PrivateKey); ok && withPrivate {
+			out.D = b64.EncodeToString(private.Seed())
+		}
+	}
+	return out
+}
+
+// parseJWK decodes a key written by jwk, private members are optional.
+func parseJWK(j jwk) (*signingKey, error) {
+	key := &signingKey{kid: j.Kid, alg: j.Alg}
+	if key.kid == "" {
+		return nil, errors.New("missing kid")
+	}
+	switch {
+	case j.Kty == "oct" && j.Alg == AlgHS256:
+		secret, err := b64.DecodeString(j.K)
+		if err != nil || len(secret) < 32 {
+			return nil, errors.New("HS256 keys need a secret of at least 32 bytes")
+		}
+		key.secret = secret
+	case j.Kty == "RSA" && j.Alg == AlgRS256:
+		n, err := decodeInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		public := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		key.public = public
+		if j.D != "" {
+			private := &rsa.PrivateKey{PublicKey: *public}
+			if private.D, err = decodeInt(j.D); err != nil {
+				return nil, err
+			}
+			p, err := decodeInt(j.P)
+			if err != nil {
+				return nil, err
+			}
+			q, err := decodeInt(j.Q)
+			if err != nil {
+				return nil, err
+			}
+			private.Primes = []*big.Int{p, q}
+			if err := private.Validate(); err != nil {
+				return nil, err
+			}
+			private.Precompute()
+			key.private = private
+		}
+	case j.Kty == "OKP" && j.Crv == "Ed25519" && j.Alg == AlgEdDSA:
+		x, err := b64.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		key.public = ed25519.PublicKey(x)
+		if j.D != "" {
+			seed, err := b64.DecodeString(j.D)
+			if err != nil || len(seed) != ed25519.SeedSize {
+				return nil, errors.New("invalid Ed25519 private key")
+			}
+			key.private = ed25519.NewKeyFromSeed(seed)
+		}
+	default:
+		return nil, fmt.Errorf("%w: kty %s alg %s", ErrUnsupportedAlg, j.Kty, j.Alg)
+	}
+	return key, nil
+}
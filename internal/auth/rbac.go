@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Roles de los usuarios.
+const (
+	RoleAdmin    = "admin"
+	RoleApprover = "approver"
+	RoleSeller   = "seller"
+	RoleCustomer = "customer"
+)
+
+// Roles lists every valid role.
+var Roles = []string{RoleAdmin, RoleApprover, RoleSeller, RoleCustomer}
+
+// Permisos que se controlan en la api y en los servicios. Los que terminan
+// en ":own" solo valen para los recursos del propio usuario.
+const (
+	PermUsersReadAny     = "users:read:any"
+	PermUsersReadOwn     = "users:read:own"
+	PermUsersCreate      = "users:create"
+	PermUsersUpdateAny   = "users:update:any"
+	PermUsersUpdateOwn   = "users:update:own"
+	PermUsersDelete      = "users:delete"
+	PermUsersRestore     = "users:restore"
+	PermUsersAssignRole  = "users:assign_role"
+	PermUsersCredentials = "users:credentials" // Contraseña y email de otros usuarios
+	PermSalesReadAny     = "sales:read:any"
+	PermSalesReadOwn     = "sales:read:own"
+	PermSalesCreateAny   = "sales:create:any"
+	PermSalesCreateOwn   = "sales:create:own"
+	PermSalesTransition  = "sales:transition"
+	PermReportsRead      = "reports:read"
+	PermKeysManage       = "keys:manage"
+)
+
+// allPermissions is granted to admins.
+var allPermissions = []string{
+	PermUsersReadAny, PermUsersReadOwn, PermUsersCreate, PermUsersUpdateAny, PermUsersUpdateOwn,
+	PermUsersDelete, PermUsersRestore, PermUsersAssignRole, PermUsersCredentials,
+	PermSalesReadAny, PermSalesReadOwn, PermSalesCreateAny, PermSalesCreateOwn, PermSalesTransition,
+	PermReportsRead, PermKeysManage,
+}
+
+// rolePermissions is the permission matrix of the users' roles.
+var rolePermissions = map[string][]string{
+	RoleAdmin:    allPermissions,
+	RoleApprover: {PermUsersReadAny, PermUsersReadOwn, PermUsersUpdateOwn, PermSalesReadAny, PermSalesReadOwn, PermSalesTransition, PermReportsRead},
+	RoleSeller:   {PermUsersReadAny, PermUsersReadOwn, PermUsersCreate, PermUsersUpdateOwn, PermSalesReadAny, PermSalesReadOwn, PermSalesCreateAny, PermSalesCreateOwn, PermReportsRead},
+	RoleCustomer: {PermUsersReadOwn, PermUsersUpdateOwn, PermSalesReadOwn, PermSalesCreateOwn},
+}
+
+// scopePermissions maps the scopes of API keys to permissions. Deleting and
+// restoring users, assigning roles and changing credentials needs the admin scope.
+var scopePermissions = map[string][]string{
+	ScopeUsersRead:   {PermUsersReadAny},
+	ScopeUsersWrite:  {PermUsersCreate, PermUsersUpdateAny},
+	ScopeSalesRead:   {PermSalesReadAny},
+	ScopeSalesWrite:  {PermSalesCreateAny, PermSalesTransition},
+	ScopeReportsRead: {PermReportsRead},
+	ScopeAdmin:       allPermissions,
+}
+
+// roleRanks orders the roles by privilege, see AuthorizeCredentials.
+var roleRanks = map[string]int{
+	RoleCustomer: 1,
+	RoleSeller:   2,
+	RoleApprover: 3,
+	RoleAdmin:    4,
+}
+
+// ErrForbidden is returned when the principal lacks the needed permission.
+var ErrForbidden = errors.New("forbidden")
+
+// ErrInvalidRole is returned for roles other than the ones in Roles.
+var ErrInvalidRole = errors.New("invalid role")
+
+// ValidRole returns ErrInvalidRole if role is unknown.
+func ValidRole(role string) error {
+	for _, r := range Roles {
+		if r == role {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidRole, role)
+}
+
+// Can reports whether the principal has perm, through its role when it is a
+// user and through its scopes when it is an API key. A nil principal has no
+// permissions.
+func (p *Principal) Can(perm string) bool {
+	if p == nil {
+		return false
+	}
+	var granted []string
+	if p.Kind == SubjectUser {
+		granted = rolePermissions[p.Role]
+	} else {
+		for _, scope := range p.Scopes {
+			granted = append(granted, scopePermissions[scope]...)
+		}
+	}
+	for _, g := range granted {
+		if g == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize returns ErrForbidden if the principal does not have perm.
+func Authorize(p *Principal, perm string) error {
+	if !p.Can(perm) {
+		return fmt.Errorf("%w: missing permission %s", ErrForbidden, perm)
+	}
+	return nil
+}
+
+// AuthorizeOwn allows the principal if it has anyPerm, or ownPerm and it is
+// the user ownerID. Returns ErrForbidden otherwise.
+func AuthorizeOwn(p *Principal, anyPerm string, ownPerm string, ownerID string) error {
+	if p.Can(anyPerm) {
+		return nil
+	}
+	if p.Can(ownPerm) && p.Kind == SubjectUser && p.Subject == ownerID {
+		return nil
+	}
+	return fmt.Errorf("%w: missing permission %s", ErrForbidden, anyPerm)
+}
+
+// rank is the privilege of the principal: its role for users and admin for
+// API keys with the admin scope.
+func (p *Principal) rank() int {
+	if p.Kind == SubjectUser {
+		return roleRanks[p.Role]
+	}
+	if p.HasScope(ScopeAdmin) {
+		return roleRanks[RoleAdmin]
+	}
+	return 0
+}
+
+// AuthorizeCredentials allows the principal to change the password or the
+// email of the user ownerID, whose role is ownerRole. Users may change
+// their own with ownPerm; anyone else needs PermUsersCredentials and at
+// least the privilege of ownerRole. Returns ErrForbidden otherwise.
+func AuthorizeCredentials(p *Principal, ownPerm string, ownerID string, ownerRole string) error {
+	if p.Can(ownPerm) && p.Kind == SubjectUser && p.Subject == ownerID {
+		return nil
+	}
+	if err := Authorize(p, PermUsersCredentials); err != nil {
+		return err
+	}
+	if roleRanks[ownerRole] > p.rank() {
+		return fmt.Errorf("%w: cannot change the credentials of a %s", ErrForbidden, ownerRole)
+	}
+	return nil
+}
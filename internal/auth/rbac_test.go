@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizeCredentials(t *testing.T) {
+	admin := &Principal{Subject: "u-admin", Kind: SubjectUser, Role: RoleAdmin}
+	approver := &Principal{Subject: "u-approver", Kind: SubjectUser, Role: RoleApprover}
+	adminKey := &Principal{Subject: "k-1", Kind: SubjectAPIKey, Scopes: []string{ScopeAdmin}}
+	writeKey := &Principal{Subject: "k-2", Kind: SubjectAPIKey, Scopes: []string{ScopeUsersWrite}}
+
+	// cada uno cambia las suyas
+	require.NoError(t, AuthorizeCredentials(approver, PermUsersUpdateOwn, "u-approver", RoleApprover))
+
+	// los admins cambian las de otros, también las de otros admins
+	require.NoError(t, AuthorizeCredentials(admin, PermUsersUpdateOwn, "u-1", RoleCustomer))
+	require.NoError(t, AuthorizeCredentials(admin, PermUsersUpdateOwn, "u-2", RoleAdmin))
+	require.NoError(t, AuthorizeCredentials(adminKey, PermUsersUpdateOwn, "u-2", RoleAdmin))
+
+	// users:write puede modificar usuarios pero no sus credenciales
+	require.True(t, writeKey.Can(PermUsersUpdateAny))
+	require.ErrorIs(t, AuthorizeCredentials(writeKey, PermUsersUpdateOwn, "u-1", RoleCustomer), ErrForbidden)
+	require.ErrorIs(t, AuthorizeCredentials(approver, PermUsersUpdateOwn, "u-1", RoleCustomer), ErrForbidden)
+}
+
+func TestAuthorizeCredentials_RolSuperior(t *testing.T) {
+	// un rol con el permiso no alcanza para los roles de más privilegio
+	rolePermissions["test"] = []string{PermUsersCredentials}
+	roleRanks["test"] = roleRanks[RoleSeller]
+	t.Cleanup(func() {
+		delete(rolePermissions, "test")
+		delete(roleRanks, "test")
+	})
+	p := &Principal{Subject: "u-test", Kind: SubjectUser, Role: "test"}
+
+	require.NoError(t, AuthorizeCredentials(p, PermUsersUpdateOwn, "u-1", RoleCustomer))
+	require.NoError(t, AuthorizeCredentials(p, PermUsersUpdateOwn, "u-2", RoleSeller))
+	require.ErrorIs(t, AuthorizeCredentials(p, PermUsersUpdateOwn, "u-3", RoleApprover), ErrForbidden)
+	require.ErrorIs(t, AuthorizeCredentials(p, PermUsersUpdateOwn, "u-4", RoleAdmin), ErrForbidden)
+}
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// keyPrefix marks the secrets issued by this service.
+const keyPrefix = "sk_"
+
+// displayPrefixLength is how many characters of the key are kept in clear.
+const displayPrefixLength = 10
+
+// ErrInvalidKey is returned when the presented key does not exist.
+var ErrInvalidKey = errors.New("invalid api key")
+
+// ErrRevokedKey is returned when the presented key was revoked.
+var ErrRevokedKey = errors.New("api key revoked")
+
+// ErrInvalidScope is returned when issuing a key with an unknown scope.
+var ErrInvalidScope = errors.New("invalid scope")
+
+// ErrAlreadyRevoked is returned when revoking or rotating a revoked key.
+var ErrAlreadyRevoked = errors.New("api key already revoked")
+
+// Service provides API key management and authentication on a LocalStorage backend.
+type Service struct {
+	storage *LocalStorage
+	logger  *zap.Logger
+}
+
+// NewService creates a new Service.
+func NewService(storage *LocalStorage, logger *zap.Logger) *Service {
+	if logger == nil {
+		logger, _ = zap.NewProduction()
+		defer logger.Sync() // flushes buffer, if any
+	}
+	return &Service{
+		storage: storage,
+		logger:  logger,
+	}
+}
+
+// hashKey returns the hex encoded SHA-256 of the key. Keys are random and
+// long, so a fast hash is enough and lookups stay O(1).
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSecret generates a new random key.
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func displayPrefix(key string) string {
+	if len(key) <= displayPrefixLength {
+		return key
+	}
+	return key[:displayPrefixLength]
+}
+
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		valid := false
+		for _, s := range AllScopes {
+			if s == scope {
+				valid = true
+			}
+		}
+		if !valid {
+			return fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+	return nil
+}
+
+// Issue creates a new API key with the given name and scopes.
+// Returns ErrInvalidScope if a scope is unknown.
+func (s *Service) Issue(name string, scopes []string) (*IssuedKey, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(name, scopes, secret)
+}
+
+// Bootstrap registers secret as an admin key named name, so the first admin
+// can issue the rest of the keys. It is meant to be called at startup.
+func (s *Service) Bootstrap(name string, secret string) (*APIKey, error) {
+	issued, err := s.issue(name, []string{ScopeAdmin}, secret)
+	if err != nil {
+		return nil, err
+	}
+	return issued.APIKey, nil
+}
+
+func (s *Service) issue(name string, scopes []string, secret string) (*IssuedKey, error) {
+	if err := validateScopes(scopes); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	key := &APIKey{
+		ID:        uuid.NewString(),
+		Name:      name,
+		Prefix:    displayPrefix(secret),
+		Hash:      hashKey(secret),
+		Scopes:    scopes,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+	if err := s.storage.Set(key); err != nil {
+		s.logger.Error("failed to save api key", zap.Error(err), zap.String("id", key.ID))
+		return nil, err
+	}
+	return &IssuedKey{APIKey: key, Key: secret}, nil
+}
+
+// List returns every API key, revoked ones included.
+func (s *Service) List() ([]*APIKey, error) {
+	return s.storage.List()
+}
+
+// Get retrieves an API key by ID.
+// Returns ErrNotFound if the key does not exist.
+func (s *Service) Get(id string) (*APIKey, error) {
+	return s.storage.Get(id)
+}
+
+// Rotate replaces the secret of the key, the old secret stops working.
+// Returns ErrNotFound if the key does not exist and ErrAlreadyRevoked if it
+// was revoked.
+func (s *Service) Rotate(id string) (*IssuedKey, error) {
+	key, err := s.storage.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !key.Active() {
+		return nil, ErrAlreadyRevoked
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	key.Prefix = displayPrefix(secret)
+	key.Hash = hashKey(secret)
+	key.UpdatedAt = time.Now()
+	key.Version++
+
+	if err := s.storage.Set(key); err != nil {
+		s.logger.Error("failed to rotate api key", zap.Error(err), zap.String("id", id))
+		return nil, err
+	}
+	return &IssuedKey{APIKey: key, Key: secret}, nil
+}
+
+// Revoke disables the key permanently.
+// Returns ErrNotFound if the key does not exist and ErrAlreadyRevoked if it
+// was already revoked.
+func (s *Service) Revoke(id string) (*APIKey, error) {
+	key, err := s.storage.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !key.Active() {
+		return nil, ErrAlreadyRevoked
+	}
+
+	now := time.Now()
+	key.RevokedAt = &now
+	key.UpdatedAt = now
+	key.Version++
+
+	if err := s.storage.Set(key); err != nil {
+		s.logger.Error("failed to revoke api key", zap.Error(err), zap.String("id", id))
+		return nil, err
+	}
+	return key, nil
+}
+
+// Authenticate returns the key matching the presented secret and records
+// its last use. Returns ErrInvalidKey or ErrRevokedKey.
+func (s *Service) Authenticate(secret string) (*APIKey, error) {
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return nil, ErrInvalidKey
+	}
+
+	key, err := s.storage.GetByHash(hashKey(secret))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidKey
+		}
+		return nil, err
+	}
+	if !key.Active() {
+		return nil, ErrRevokedKey
+	}
+
+	now := time.Now()
+	s.storage.Touch(key.ID, now)
+	key.LastUsedAt = &now
+	return key, nil
+}
+
+// CheckToken verifies that the API key behind a token principal was not
+// revoked or rotated after the token was issued. Principals of users are
+// accepted as they are, the caller checks them against the stored user.
+// Returns ErrInvalidKey or ErrRevokedKey.
+func (s *Service) CheckToken(p *Principal) error {
+	if p.Kind != SubjectAPIKey {
+		return nil
+	}
+	key, err := s.storage.Get(p.Subject)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrInvalidKey
+		}
+		return err
+	}
+	if !key.Active() {
+		return ErrRevokedKey
+	}
+	if key.Version != p.KeyVersion {
+		return fmt.Errorf("%w: the key was rotated", ErrInvalidKey)
+	}
+	return nil
+}
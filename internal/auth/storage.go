@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when an API key with the given ID is not found.
+var ErrNotFound = errors.New("api key not found")
+
+// ErrEmptyID is returned when trying to store an API key with an empty ID.
+var ErrEmptyID = errors.New("empty api key ID")
+
+// LocalStorage provides an in-memory implementation for storing API keys,
+// indexed by ID and by the hash of the secret.
+type LocalStorage struct {
+	mu     sync.RWMutex
+	m      map[string]*APIKey
+	byHash map[string]string
+}
+
+// NewLocalStorage instantiates a new LocalStorage with an empty map.
+func NewLocalStorage() *LocalStorage {
+	return &LocalStorage{
+		m:      map[string]*APIKey{},
+		byHash: map[string]string{},
+	}
+}
+
+// Set stores or updates an API key. The storage keeps its own copy.
+// Returns ErrEmptyID if the key has an empty ID.
+func (l *LocalStorage) Set(key *APIKey) error {
+	if key.ID == "" {
+		return ErrEmptyID
+	}
+
+	stored := *key
+	stored.Scopes = append([]string(nil), key.Scopes...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if old, ok := l.m[key.ID]; ok {
+		delete(l.byHash, old.Hash)
+	}
+	l.m[key.ID] = &stored
+	l.byHash[stored.Hash] = stored.ID
+	return nil
+}
+
+// Get retrieves an API key by ID, revoked keys included.
+// Returns ErrNotFound if the key is not found.
+func (l *LocalStorage) Get(id string) (*APIKey, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	k, ok := l.m[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *k
+	return &cp, nil
+}
+
+// GetByHash retrieves the API key whose secret has the given hash.
+// Returns ErrNotFound if no key matches.
+func (l *LocalStorage) GetByHash(hash string) (*APIKey, error) {
+	l.mu.RLock()
+	id, ok := l.byHash[hash]
+	l.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return l.Get(id)
+}
+
+// List returns every stored API key.
+func (l *LocalStorage) List() ([]*APIKey, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	keys := make([]*APIKey, 0, len(l.m))
+	for _, k := range l.m {
+		cp := *k
+		keys = append(keys, &cp)
+	}
+	return keys, nil
+}
+
+// Touch sets the last used timestamp of the key without a full Set, so
+// authenticating does not race with admin updates of the same key.
+func (l *LocalStorage) Touch(id string, usedAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if k, ok := l.m[id]; ok {
+		k.LastUsedAt = &usedAt
+	}
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Tipos de sujeto que puede tener un token.
+const (
+	SubjectAPIKey = "api_key"
+	SubjectUser   = "user"
+)
+
+// ErrInvalidToken is returned when a token cannot be verified.
+var ErrInvalidToken = errors.New("invalid token")
+
+// ErrInvalidCredentials is returned when a login and password do not match.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// PasswordVerifier checks the credentials of a user for the password grant.
+// It returns the principal (user ID and role) to put in the token.
+type PasswordVerifier interface {
+	VerifyPassword(ctx context.Context, login, password string) (*Principal, error)
+}
+
+// TokenService issues and verifies JWTs signed with the keys of a KeySet.
+type TokenService struct {
+	keys   *KeySet
+	issuer string
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewTokenService creates a TokenService issuing tokens valid for ttl.
+func NewTokenService(keys *KeySet, issuer string, ttl time.Duration) *TokenService {
+	return &TokenService{
+		keys:   keys,
+		issuer: issuer,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// Keys returns the key set, e.g. to publish or rotate it.
+func (t *TokenService) Keys() *KeySet {
+	return t.keys
+}
+
+// Issue signs a token for the principal: its subject, kind (stored in the
+// sub_type claim), role and scopes, plus the key version (key_ver claim)
+// for API keys.
+func (t *TokenService) Issue(p *Principal) (string, time.Time, error) {
+	key := t.keys.current()
+	now := t.now()
+	expiresAt := now.Add(t.ttl)
+
+	claims := jwt.MapClaims{
+		"iss":      t.issuer,
+		"sub":      p.Subject,
+		"sub_type": p.Kind,
+		"scope":    strings.Join(p.Scopes, " "),
+		"iat":      now.Unix(),
+		"nbf":      now.Unix(),
+		"exp":      expiresAt.Unix(),
+	}
+	if p.Role != "" {
+		claims["role"] = p.Role
+	}
+	if p.Kind == SubjectAPIKey {
+		claims["key_ver"] = p.KeyVersion
+	}
+
+	var method jwt.SigningMethod
+	var signWith any
+	switch key.alg {
+	case AlgHS256:
+		method, signWith = jwt.SigningMethodHS256, key.secret
+	case AlgRS256:
+		method, signWith = jwt.SigningMethodRS256, key.private
+	case AlgEdDSA:
+		method, signWith = jwt.SigningMethodEdDSA, key.private
+	default:
+		return "", time.Time{}, fmt.Errorf("%w: %s", ErrUnsupportedAlg, key.alg)
+	}
+	if key.private == nil && key.secret == nil {
+		return "", time.Time{}, fmt.Errorf("signing key %s has no private part", key.kid)
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(signWith)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// Verify checks the signature, issuer and validity window of the token and
+// returns its principal. Returns ErrInvalidToken wrapping the cause.
+// Tokens of API keys must still be checked with Service.CheckToken and the
+// ones of users against the stored user, the role in the claims may be stale.
+func (t *TokenService) Verify(token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(tok *jwt.Token) (any, error) {
+		kid, _ := tok.Header["kid"].(string)
+		key, err := t.keys.lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		// el algoritmo lo decide la clave, no el header del token
+		if tok.Method.Alg() != key.alg {
+			return nil, fmt.Errorf("token algorithm %s does not match key %s", tok.Method.Alg(), kid)
+		}
+		if key.alg == AlgHS256 {
+			return key.secret, nil
+		}
+		return key.public, nil
+	},
+		jwt.WithValidMethods([]string{AlgHS256, AlgRS256, AlgEdDSA}),
+		jwt.WithIssuer(t.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(t.now),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+	kind, _ := claims["sub_type"].(string)
+	if kind == "" {
+		kind = SubjectUser
+	}
+	role, _ := claims["role"].(string)
+	scope, _ := claims["scope"].(string)
+	keyVersion, _ := claims["key_ver"].(float64) // los números de JSON llegan como float64
+	return &Principal{
+		Subject:    subject,
+		Kind:       kind,
+		Role:       role,
+		Scopes:     strings.Fields(scope),
+		Claims:     claims,
+		KeyVersion: int(keyVersion),
+	}, nil
+}
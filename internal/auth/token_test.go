@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenService_IssueVerify(t *testing.T) {
+	for _, alg := range []string{AlgHS256, AlgRS256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			keys, err := NewKeySet(alg)
+			require.NoError(t, err)
+			tokens := NewTokenService(keys, "test", time.Minute)
+
+			token, _, err := tokens.Issue(&Principal{Subject: "user-1", Kind: SubjectUser, Role: RoleCustomer, Scopes: []string{ScopeUsersRead, ScopeSalesRead}})
+			require.NoError(t, err)
+
+			principal, err := tokens.Verify(token)
+			require.NoError(t, err)
+			require.Equal(t, "user-1", principal.Subject)
+			require.Equal(t, SubjectUser, principal.Kind)
+			require.Equal(t, RoleCustomer, principal.Role)
+			require.True(t, principal.HasScope(ScopeSalesRead))
+			require.False(t, principal.HasScope(ScopeSalesWrite))
+
+			// otro emisor no es aceptado
+			_, err = NewTokenService(keys, "otro", time.Minute).Verify(token)
+			require.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+func TestTokenService_Rechazos(t *testing.T) {
+	keys, err := NewKeySet(AlgEdDSA)
+	require.NoError(t, err)
+	tokens := NewTokenService(keys, "test", time.Minute)
+
+	// token vencido
+	tokens.now = func() time.Time { return time.Now().Add(-time.Hour) }
+	expired, _, err := tokens.Issue(&Principal{Subject: "user-1", Kind: SubjectUser})
+	require.NoError(t, err)
+	tokens.now = time.Now
+	_, err = tokens.Verify(expired)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// token firmado con HS256 usando la clave pública como secreto (alg confusion)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": "test", "sub": "admin", "exp": time.Now().Add(time.Minute).Unix()})
+	forged.Header["kid"] = keys.current().kid
+	signed, err := forged.SignedString([]byte(keys.current().jwk(false).X))
+	require.NoError(t, err)
+	_, err = tokens.Verify(signed)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestKeySet_ArchivoYRotacion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	keys, err := LoadKeySet(path, AlgRS256)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	tokens := NewTokenService(keys, "test", time.Minute)
+	before, _, err := tokens.Issue(&Principal{Subject: "user-1", Kind: SubjectUser})
+	require.NoError(t, err)
+
+	kid, err := keys.Rotate()
+	require.NoError(t, err)
+	require.Len(t, keys.Public().Keys, 2)
+	require.Equal(t, kid, keys.Public().Keys[0].Kid)
+
+	// otra instancia que lee el mismo archivo verifica los tokens viejos y nuevos
+	reloaded, err := LoadKeySet(path, AlgRS256)
+	require.NoError(t, err)
+	other := NewTokenService(reloaded, "test", time.Minute)
+	_, err = other.Verify(before)
+	require.NoError(t, err)
+	after, _, err := tokens.Issue(&Principal{Subject: "user-1", Kind: SubjectUser})
+	require.NoError(t, err)
+	_, err = other.Verify(after)
+	require.NoError(t, err)
+
+	// las claves salen del set después de maxKeys rotaciones
+	for i := 0; i < maxKeys; i++ {
+		_, err = keys.Rotate()
+		require.NoError(t, err)
+	}
+	_, err = tokens.Verify(before)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
@@ -0,0 +1,308 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"parte3/internal/auth"
+	"parte3/internal/logging"
+
+	"github.com/pelletier/go-toml/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// BackendMemory keeps everything in memory, it is lost on restart.
+const BackendMemory = "memory"
+
+// FileEnv names the environment variable with the configuration file, the
+// -config flag takes precedence over it.
+const FileEnv = "APP_CONFIG"
+
+// ErrInvalid is wrapped by the errors returned by Validate.
+var ErrInvalid = errors.New("invalid configuration")
+
+// setting is one value that can come from the environment and a flag.
+type setting struct {
+	env   string
+	flag  string // vacío: no se puede pasar por flag (p. ej. secretos)
+	usage string
+	set   func(c *Config, v string) error
+}
+
+func setString(field func(c *Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func setBool(field func(c *Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+// settings lists everything that can be set from the environment or flags.
+// The environment names used before this package existed are kept.
+var settings = []setting{
+	{"APP_ADDR", "addr", "listen address (host:port)", setString(func(c *Config) *string { return &c.Server.Addr })},
+	{"APP_MODE", "mode", "gin mode: debug, release or test", setString(func(c *Config) *string { return &c.Server.Mode })},
+	{"APP_SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long in-flight requests may take on shutdown, e.g. 15s", func(c *Config, v string) error {
+		return c.Server.ShutdownTimeout.UnmarshalText([]byte(v))
+	}},
+	{"APP_REQUEST_TIMEOUT", "request-timeout", "deadline of every request, e.g. 30s, 0 disables it", func(c *Config, v string) error {
+		return c.Server.RequestTimeout.UnmarshalText([]byte(v))
+	}},
+	{"TRUSTED_PROXIES", "trusted-proxies", "comma separated IPs or CIDRs of the proxies allowed to set X-Forwarded-For", func(c *Config, v string) error {
+		c.Server.TrustedProxies = nil
+		for _, proxy := range strings.Split(v, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				c.Server.TrustedProxies = append(c.Server.TrustedProxies, proxy)
+			}
+		}
+		return nil
+	}},
+	{"APP_LOG_LEVEL", "log-level", "log level: debug, info, warn or error", setString(func(c *Config) *string { return &c.Log.Level })},
+	{"APP_LOG_FORMAT", "log-format", "log format: json or console", setString(func(c *Config) *string { return &c.Log.Format })},
+	{"APP_LOG_REDACT", "log-redact", "redaction of personal data by field, e.g. user.email=show,user.addresses=hide", func(c *Config, v string) error {
+		redact := map[string]string{}
+		for _, pair := range strings.Split(v, ",") {
+			field, policy, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || field == "" {
+				return fmt.Errorf("%q must be field=policy", pair)
+			}
+			redact[field] = policy
+		}
+		c.Log.Redact = redact
+		return nil
+	}},
+	{"APP_STORAGE", "storage", "storage backend: memory", setString(func(c *Config) *string { return &c.Storage.Backend })},
+	{"API_BOOTSTRAP_KEY", "", "", setString(func(c *Config) *string { return &c.Auth.BootstrapKey })},
+	{"JWT_JWKS_FILE", "jwks-file", "JWKS file with the token signing keys, created if missing", setString(func(c *Config) *string { return &c.Auth.JWKSFile })},
+	{"JWT_ALG", "jwt-alg", "token signing algorithm: HS256, RS256 or EdDSA", setString(func(c *Config) *string { return &c.Auth.JWTAlg })},
+	{"JWT_ISSUER", "jwt-issuer", "iss claim of the tokens", setString(func(c *Config) *string { return &c.Auth.JWTIssuer })},
+	{"JWT_TTL", "jwt-ttl", "token lifetime, e.g. 1h", func(c *Config, v string) error { return c.Auth.JWTTTL.UnmarshalText([]byte(v)) }},
+	{"SALES_SECOND_APPROVAL_ABOVE", "second-approval-above", "sale amount above which two approvers are needed, 0 disables it", func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		c.Sales.SecondApprovalAbove = f
+		return err
+	}},
+	{"NOTIFY_FILE", "notify-file", "file where user notifications are appended", setString(func(c *Config) *string { return &c.Notify.File })},
+	{"TRACING_EXPORTER", "tracing-exporter", "where traces go: none, stdout or file", setString(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"TRACING_FILE", "tracing-file", "file where the file exporter appends the spans", setString(func(c *Config) *string { return &c.Tracing.File })},
+	{"TRACING_SAMPLE_RATIO", "tracing-sample-ratio", "fraction of the new traces that are recorded, from 0 to 1", func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		c.Tracing.SampleRatio = f
+		return err
+	}},
+	{"OTEL_SERVICE_NAME", "tracing-service-name", "service.name of the traces", setString(func(c *Config) *string { return &c.Tracing.ServiceName })},
+	{"APP_FEATURE_PASSWORD_GRANT", "feature-password-grant", "enable the password grant", setBool(func(c *Config) *bool { return &c.Features.PasswordGrant })},
+	{"APP_FEATURE_BATCH", "feature-batch", "enable the batch endpoints", setBool(func(c *Config) *bool { return &c.Features.Batch })},
+	{"APP_FEATURE_CSV", "feature-csv", "enable CSV export and import", setBool(func(c *Config) *bool { return &c.Features.CSV })},
+	{"APP_FEATURE_RATE_LIMIT", "feature-rate-limit", "enable rate limiting", setBool(func(c *Config) *bool { return &c.Features.RateLimit })},
+	{"APP_FEATURE_METRICS", "feature-metrics", "enable the Prometheus metrics", setBool(func(c *Config) *bool { return &c.Features.Metrics })},
+}
+
+// Load builds the configuration from, in increasing order of precedence:
+// the defaults, GIN_MODE for the mode, the YAML or TOML file given by
+// -config or APP_CONFIG, the environment and the command-line flags in args. The result is validated.
+// It returns flag.ErrHelp if args asked for the usage.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("parte3", flag.ContinueOnError)
+	path := fs.String("config", "", "YAML or TOML configuration file (env "+FileEnv+")")
+	flags := map[string]string{}
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		name := s.flag
+		fs.Func(name, s.usage+" (env "+s.env+")", func(v string) error {
+			flags[name] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	// gin.SetMode pisa GIN_MODE, así que vale cuando nada más fija el modo
+	if mode := getenv("GIN_MODE"); mode != "" {
+		cfg.Server.Mode = mode
+	}
+
+	if *path == "" {
+		*path = getenv(FileEnv)
+	}
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("%w: %s=%q: %v", ErrInvalid, s.env, v, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flags[s.flag]; ok {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("%w: -%s=%q: %v", ErrInvalid, s.flag, v, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile decodes the file over cfg, by its extension. Unknown keys are
+// an error so typos do not go unnoticed.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+		if errors.Is(err, io.EOF) {
+			err = nil // archivo vacío
+		}
+	case ".toml":
+		dec := toml.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	default:
+		return fmt.Errorf("%w: unsupported config file extension %q", ErrInvalid, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalid, path, err)
+	}
+	return nil
+}
+
+// Validate checks every setting and reports all the problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		add("server.addr %q must be host:port", c.Server.Addr)
+	}
+	switch c.Server.Mode {
+	case "debug", "release", "test":
+	default:
+		add("server.mode %q must be debug, release or test", c.Server.Mode)
+	}
+	if c.Server.ShutdownTimeout.Duration <= 0 {
+		add("server.shutdown_timeout %s must be positive", c.Server.ShutdownTimeout)
+	}
+	if c.Server.RequestTimeout.Duration < 0 {
+		add("server.request_timeout %s must not be negative", c.Server.RequestTimeout)
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				add("server.trusted_proxies %q must be an IP or a CIDR", proxy)
+			}
+		}
+	}
+	if _, err := zapcore.ParseLevel(c.Log.Level); err != nil {
+		add("log.level %q is not a valid level", c.Log.Level)
+	}
+	if c.Log.Format != "json" && c.Log.Format != "console" {
+		add("log.format %q must be json or console", c.Log.Format)
+	}
+	for field, policy := range c.Log.Redact {
+		if _, err := logging.ParsePolicy(policy); err != nil {
+			add("log.redact.%s: %v", field, err)
+		}
+	}
+	if c.Storage.Backend != BackendMemory {
+		add("storage.backend %q is not supported, use %s", c.Storage.Backend, BackendMemory)
+	}
+	switch c.Auth.JWTAlg {
+	case auth.AlgHS256, auth.AlgRS256, auth.AlgEdDSA:
+	default:
+		add("auth.jwt_alg %q must be HS256, RS256 or EdDSA", c.Auth.JWTAlg)
+	}
+	if c.Auth.JWTIssuer == "" {
+		add("auth.jwt_issuer must not be empty")
+	}
+	if c.Auth.JWTTTL.Duration <= 0 || c.Auth.JWTTTL.Duration > 24*time.Hour {
+		add("auth.jwt_ttl %s must be between 0 and 24h", c.Auth.JWTTTL)
+	}
+	if c.Sales.SecondApprovalAbove < 0 {
+		add("sales.second_approval_above must not be negative")
+	}
+	switch c.Tracing.Exporter {
+	case TraceExporterNone, TraceExporterStdout:
+	case TraceExporterFile:
+		if c.Tracing.File == "" {
+			add("tracing.file must be set with the file exporter")
+		}
+	default:
+		add("tracing.exporter %q must be none, stdout or file", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sample_ratio must be between 0 and 1")
+	}
+	if c.Tracing.ServiceName == "" {
+		add("tracing.service_name must not be empty")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrInvalid, errors.Join(errs...))
+}
+
+// Policies returns the redaction overrides of Redact, it must be valid.
+func (c LogConfig) Policies() map[string]logging.Policy {
+	policies := make(map[string]logging.Policy, len(c.Redact))
+	for field, policy := range c.Redact {
+		policies[field] = logging.Policy(policy)
+	}
+	return policies
+}
+
+// Logger builds the zap logger described by the configuration.
+func (c LogConfig) Logger() (*zap.Logger, error) {
+	zc := zap.NewProductionConfig()
+	if c.Format == "console" {
+		zc = zap.NewDevelopmentConfig()
+	}
+	level, err := zap.ParseAtomicLevel(c.Level)
+	if err != nil {
+		return nil, err
+	}
+	zc.Level = level
+	return zc.Build()
+}
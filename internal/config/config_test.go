@@ -0,0 +1,127 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(k string) string { return vars[k] }
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := Load(nil, env(nil))
+	require.NoError(t, err)
+	require.Equal(t, Default(), cfg)
+	require.Equal(t, "release", cfg.Server.Mode)
+
+	// GIN_MODE vale si ni el archivo ni APP_MODE fijan el modo
+	cfg, err = Load(nil, env(map[string]string{"GIN_MODE": "debug"}))
+	require.NoError(t, err)
+	require.Equal(t, "debug", cfg.Server.Mode)
+	cfg, err = Load(nil, env(map[string]string{"GIN_MODE": "debug", "APP_MODE": "test"}))
+	require.NoError(t, err)
+	require.Equal(t, "test", cfg.Server.Mode)
+}
+
+func TestLoad_Precedencia(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+server:
+  addr: ":9000"
+  mode: test
+log:
+  level: warn
+auth:
+  jwt_ttl: 30m
+sales:
+  second_approval_above: 1000
+features:
+  batch: false
+`)
+
+	// archivo < entorno < flags
+	cfg, err := Load([]string{"-config", path, "-log-level", "debug"}, env(map[string]string{
+		"APP_ADDR":  ":9001",
+		"GIN_MODE":  "debug", // el archivo manda
+		"LOG_LEVEL": "error", // no es una variable conocida
+	}))
+	require.NoError(t, err)
+	require.Equal(t, ":9001", cfg.Server.Addr)
+	require.Equal(t, "test", cfg.Server.Mode)
+	require.Equal(t, "debug", cfg.Log.Level)
+	require.Equal(t, 30*time.Minute, cfg.Auth.JWTTTL.Duration)
+	require.Equal(t, 1000.0, cfg.Sales.SecondApprovalAbove)
+	require.False(t, cfg.Features.Batch)
+	require.True(t, cfg.Features.CSV) // lo que el archivo no menciona queda por defecto
+	require.Empty(t, cfg.Server.TrustedProxies)
+
+	// el archivo también puede venir del entorno
+	cfg, err = Load([]string{"-feature-batch=true"}, env(map[string]string{FileEnv: path}))
+	require.NoError(t, err)
+	require.Equal(t, ":9000", cfg.Server.Addr)
+	require.True(t, cfg.Features.Batch)
+}
+
+func TestLoad_TOML(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+[server]
+addr = "127.0.0.1:8081"
+trusted_proxies = ["10.0.0.0/8", "192.168.1.10"]
+
+[auth]
+jwt_alg = "HS256"
+jwt_ttl = "2h"
+
+[features]
+rate_limit = false
+`)
+	cfg, err := Load([]string{"-config", path}, env(nil))
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:8081", cfg.Server.Addr)
+	require.Equal(t, []string{"10.0.0.0/8", "192.168.1.10"}, cfg.Server.TrustedProxies)
+	require.Equal(t, "HS256", cfg.Auth.JWTAlg)
+	require.Equal(t, 2*time.Hour, cfg.Auth.JWTTTL.Duration)
+	require.False(t, cfg.Features.RateLimit)
+}
+
+func TestLoad_Invalida(t *testing.T) {
+	// todos los problemas juntos
+	_, err := Load([]string{"-addr", "8080", "-storage", "postgres", "-jwt-alg", "none"}, env(map[string]string{
+		"SALES_SECOND_APPROVAL_ABOVE": "-1",
+		"APP_REQUEST_TIMEOUT":         "-1s",
+		"TRUSTED_PROXIES":             "10.0.0.1, proxy.local",
+		"TRACING_EXPORTER":            "file",
+		"TRACING_SAMPLE_RATIO":        "2",
+		"APP_LOG_REDACT":              "user.email=partial",
+	}))
+	require.ErrorIs(t, err, ErrInvalid)
+	for _, field := range []string{"server.addr", "server.request_timeout", "storage.backend", "auth.jwt_alg", "sales.second_approval_above", "server.trusted_proxies", "tracing.file", "tracing.sample_ratio", "log.redact.user.email"} {
+		require.ErrorContains(t, err, field)
+	}
+
+	_, err = Load(nil, env(map[string]string{"APP_FEATURE_CSV": "quizás"}))
+	require.ErrorIs(t, err, ErrInvalid)
+	require.ErrorContains(t, err, "APP_FEATURE_CSV")
+
+	// las claves desconocidas del archivo son un error
+	_, err = Load([]string{"-config", writeFile(t, "config.yml", "server:\n  adress: \":1\"\n")}, env(nil))
+	require.ErrorIs(t, err, ErrInvalid)
+
+	_, err = Load([]string{"-config", writeFile(t, "config.json", "{}")}, env(nil))
+	require.ErrorIs(t, err, ErrInvalid)
+
+	_, err = Load([]string{"-h"}, env(nil))
+	require.True(t, errors.Is(err, flag.ErrHelp))
+}
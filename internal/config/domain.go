@@ -0,0 +1,146 @@
+package config
+
+import "time"
+
+// Config holds every setting of the server. It is loaded once at startup by
+// Load and must not be changed afterwards.
+type Config struct {
+	Server   ServerConfig  `yaml:"server" toml:"server"`
+	Log      LogConfig     `yaml:"log" toml:"log"`
+	Storage  StorageConfig `yaml:"storage" toml:"storage"`
+	Auth     AuthConfig    `yaml:"auth" toml:"auth"`
+	Sales    SalesConfig   `yaml:"sales" toml:"sales"`
+	Notify   NotifyConfig  `yaml:"notify" toml:"notify"`
+	Tracing  TracingConfig `yaml:"tracing" toml:"tracing"`
+	Features FeatureConfig `yaml:"features" toml:"features"`
+}
+
+// ServerConfig is how the HTTP server listens.
+type ServerConfig struct {
+	Addr string `yaml:"addr" toml:"addr"` // host:puerto
+	Mode string `yaml:"mode" toml:"mode"` // modo de gin: debug, release o test
+
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// once the server is asked to stop.
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+
+	// RequestTimeout is the deadline of every request, after it the work is
+	// cancelled and the client gets 504. 0 disables it.
+	RequestTimeout Duration `yaml:"request_timeout" toml:"request_timeout"`
+
+	// TrustedProxies are the IPs or CIDRs of the proxies whose
+	// X-Forwarded-For and X-Real-IP headers are believed to find the client
+	// IP, e.g. for rate limiting. Empty trusts none: the client is the peer.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// LogConfig configures the zap logger.
+type LogConfig struct {
+	Level  string `yaml:"level" toml:"level"`   // debug, info, warn, error
+	Format string `yaml:"format" toml:"format"` // json o console
+
+	// Redact overrides the policy of the fields with personal data, e.g.
+	// {"user.email": "show"}. The fields not listed are masked.
+	Redact map[string]string `yaml:"redact" toml:"redact"`
+}
+
+// StorageConfig selects where users and sales are kept.
+type StorageConfig struct {
+	Backend string `yaml:"backend" toml:"backend"` // por ahora solo memory
+}
+
+// AuthConfig configures the API keys and JWT tokens.
+type AuthConfig struct {
+	BootstrapKey string   `yaml:"bootstrap_key" toml:"bootstrap_key"` // vacía genera una y la imprime en stderr, nunca en los logs
+	JWKSFile     string   `yaml:"jwks_file" toml:"jwks_file"`         // vacío usa claves en memoria
+	JWTAlg       string   `yaml:"jwt_alg" toml:"jwt_alg"`
+	JWTIssuer    string   `yaml:"jwt_issuer" toml:"jwt_issuer"`
+	JWTTTL       Duration `yaml:"jwt_ttl" toml:"jwt_ttl"`
+}
+
+// SalesConfig is the approval policy of sales.
+type SalesConfig struct {
+	// SecondApprovalAbove is the amount above which a sale needs two
+	// approvers, 0 disables it.
+	SecondApprovalAbove float64 `yaml:"second_approval_above" toml:"second_approval_above"`
+}
+
+// NotifyConfig configures how users are notified (password tokens).
+type NotifyConfig struct {
+	File string `yaml:"file" toml:"file"` // vacío solo las loguea, sin el cuerpo ni los tokens
+}
+
+// Exporters de trazas.
+const (
+	TraceExporterNone   = "none"
+	TraceExporterStdout = "stdout"
+	TraceExporterFile   = "file"
+)
+
+// TracingConfig configures the OpenTelemetry traces.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter"`         // none, stdout o file
+	File        string  `yaml:"file" toml:"file"`                 // JSON por línea, con exporter file
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"` // de 0 a 1, las trazas entrantes mandan
+	ServiceName string  `yaml:"service_name" toml:"service_name"`
+}
+
+// FeatureConfig turns optional parts of the API on and off.
+type FeatureConfig struct {
+	PasswordGrant bool `yaml:"password_grant" toml:"password_grant"` // grant password en /auth/token
+	Batch         bool `yaml:"batch" toml:"batch"`                   // POST /users:batch y /sales:batch
+	CSV           bool `yaml:"csv" toml:"csv"`                       // exportación e importación CSV
+	RateLimit     bool `yaml:"rate_limit" toml:"rate_limit"`
+	Metrics       bool `yaml:"metrics" toml:"metrics"` // GET /metrics para Prometheus
+}
+
+// Duration is a time.Duration written as "1h30m" in files.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses the duration, used by the YAML and TOML decoders.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalText writes the duration like time.Duration.String.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Default returns the configuration used when nothing else is set.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			Mode:            "release",
+			ShutdownTimeout: Duration{15 * time.Second},
+			RequestTimeout:  Duration{30 * time.Second},
+		},
+		Log:     LogConfig{Level: "info", Format: "json"},
+		Storage: StorageConfig{Backend: BackendMemory},
+		Auth: AuthConfig{
+			JWTAlg:    "EdDSA",
+			JWTIssuer: "parte3",
+			JWTTTL:    Duration{time.Hour},
+		},
+		Tracing: TracingConfig{
+			Exporter:    TraceExporterNone,
+			SampleRatio: 1,
+			ServiceName: "parte3",
+		},
+		Features: FeatureConfig{
+			PasswordGrant: true,
+			Batch:         true,
+			CSV:           true,
+			RateLimit:     true,
+			Metrics:       true,
+		},
+	}
+}
@@ -0,0 +1,62 @@
+package health
+
+import (
+	"context"
+	"time"
+)
+
+// Kind tells which probe runs a check.
+type Kind int
+
+const (
+	// Liveness checks fail when only a restart can fix the process, e.g. a
+	// storage lock that is never released. /healthz runs only these.
+	Liveness Kind = iota
+	// Readiness checks fail while the process should not receive traffic,
+	// e.g. a dependency that is down. /readyz runs these and the liveness ones.
+	Readiness
+)
+
+func (k Kind) String() string {
+	if k == Liveness {
+		return "liveness"
+	}
+	return "readiness"
+}
+
+// Estado de un check y del reporte completo.
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// CheckFunc reports whether a component works, a nil error means it does.
+// It must give up when ctx is done.
+type CheckFunc func(ctx context.Context) error
+
+// Checker is implemented by the components that know how to check
+// themselves, so whoever owns them can register the check.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Name      string        `json:"name"`
+	Kind      string        `json:"kind"`
+	Status    string        `json:"status"`
+	Latency   time.Duration `json:"-"`
+	LatencyMS float64       `json:"latency_ms"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Report is the outcome of a probe: it is up only if every check is up.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Up reports whether every check passed.
+func (r Report) Up() bool {
+	return r.Status == StatusUp
+}
@@ -0,0 +1,33 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// lockPollInterval is how often Lock retries a taken lock.
+const lockPollInterval = 5 * time.Millisecond
+
+// Lock is the check of the memory components, which can only fail when
+// their lock is stuck: it succeeds if tryLock takes the lock before ctx is
+// done, and releases it right away with unlock. It polls instead of
+// blocking, so a lock that is never released does not leave a goroutine
+// waiting on it, e.g.
+//
+//	return health.Lock(ctx, l.mu.TryRLock, l.mu.RUnlock)
+func Lock(ctx context.Context, tryLock func() bool, unlock func()) error {
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		if tryLock() {
+			unlock()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("lock not released: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	var mu sync.RWMutex
+	require.NoError(t, Lock(context.Background(), mu.TryLock, mu.Unlock))
+	require.True(t, mu.TryLock(), "Lock suelta el lock")
+	mu.Unlock()
+
+	// trabado, se rinde cuando vence el contexto sin dejar a nadie esperando
+	mu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := Lock(ctx, mu.TryRLock, mu.RUnlock)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	mu.Unlock()
+
+	// se libera mientras espera
+	mu.Lock()
+	time.AfterFunc(10*time.Millisecond, mu.Unlock)
+	require.NoError(t, Lock(context.Background(), mu.TryRLock, mu.RUnlock))
+}
@@ -0,0 +1,111 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// check is a registered check.
+type check struct {
+	name string
+	kind Kind
+	fn   CheckFunc
+}
+
+// Registry keeps the checks registered by the components and runs them for
+// the probes. It is safe for concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	checks  []check
+	timeout time.Duration
+}
+
+// NewRegistry creates an empty Registry. Every check gets at most timeout
+// to answer, after that it is reported down.
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{timeout: timeout}
+}
+
+// Register adds a check. Names should be "<component>.<part>", e.g.
+// "users.storage", and are reported in the order they were registered.
+func (r *Registry) Register(name string, kind Kind, fn CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, check{name: name, kind: kind, fn: fn})
+}
+
+// Liveness runs the liveness checks.
+func (r *Registry) Liveness(ctx context.Context) Report {
+	return r.run(ctx, func(c check) bool { return c.kind == Liveness })
+}
+
+// Readiness runs every check, a process that is not alive is not ready either.
+func (r *Registry) Readiness(ctx context.Context) Report {
+	return r.run(ctx, func(check) bool { return true })
+}
+
+// run runs the selected checks concurrently, each with its own deadline.
+func (r *Registry) run(ctx context.Context, selected func(check) bool) Report {
+	r.mu.RLock()
+	var checks []check
+	for _, c := range r.checks {
+		if selected(c) {
+			checks = append(checks, c)
+		}
+	}
+	r.mu.RUnlock()
+
+	report := Report{Status: StatusUp, Checks: make([]Result, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = r.runOne(ctx, c)
+		}()
+	}
+	wg.Wait()
+
+	for _, res := range report.Checks {
+		if res.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+// runOne runs a check and waits for it until the timeout. A check that
+// ignores its context keeps running in the background but is reported down.
+func (r *Registry) runOne(ctx context.Context, c check) Result {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", r.timeout)
+	}
+	latency := time.Since(start)
+
+	res := Result{
+		Name:      c.name,
+		Kind:      c.kind.String(),
+		Status:    StatusUp,
+		Latency:   latency,
+		LatencyMS: float64(latency.Microseconds()) / 1000,
+	}
+	if err != nil {
+		res.Status = StatusDown
+		res.Error = err.Error()
+	}
+	return res
+}
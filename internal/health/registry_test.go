@@ -0,0 +1,71 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func ok(context.Context) error { return nil }
+
+func TestRegistry_TodoBien(t *testing.T) {
+	r := NewRegistry(time.Second)
+	r.Register("users.storage", Liveness, ok)
+	r.Register("users.notifier", Readiness, ok)
+
+	live := r.Liveness(context.Background())
+	require.True(t, live.Up())
+	require.Len(t, live.Checks, 1)
+	require.Equal(t, "users.storage", live.Checks[0].Name)
+	require.Equal(t, "liveness", live.Checks[0].Kind)
+
+	ready := r.Readiness(context.Background())
+	require.True(t, ready.Up())
+	require.Len(t, ready.Checks, 2)
+	require.Equal(t, "users.notifier", ready.Checks[1].Name)
+	require.Equal(t, StatusUp, ready.Checks[1].Status)
+}
+
+func TestRegistry_UnCheckFallaTodoElReporte(t *testing.T) {
+	r := NewRegistry(time.Second)
+	r.Register("users.storage", Liveness, ok)
+	r.Register("notify", Readiness, func(context.Context) error { return errors.New("disk full") })
+
+	// la liveness no corre los checks de readiness
+	require.True(t, r.Liveness(context.Background()).Up())
+
+	ready := r.Readiness(context.Background())
+	require.False(t, ready.Up())
+	require.Equal(t, StatusDown, ready.Status)
+	require.Equal(t, StatusUp, ready.Checks[0].Status)
+	require.Equal(t, StatusDown, ready.Checks[1].Status)
+	require.Equal(t, "disk full", ready.Checks[1].Error)
+}
+
+func TestRegistry_Timeout(t *testing.T) {
+	r := NewRegistry(50 * time.Millisecond)
+	bloqueado := make(chan struct{})
+	defer close(bloqueado)
+	// un check que ignora el contexto no traba el reporte
+	r.Register("stuck", Liveness, func(context.Context) error {
+		<-bloqueado
+		return nil
+	})
+	r.Register("lento", Liveness, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	start := time.Now()
+	report := r.Liveness(context.Background())
+	require.Less(t, time.Since(start), time.Second, "los checks corren en paralelo")
+	require.False(t, report.Up())
+	for _, res := range report.Checks {
+		require.Equal(t, StatusDown, res.Status)
+		require.Contains(t, res.Error, "timed out")
+		require.GreaterOrEqual(t, res.Latency, 50*time.Millisecond)
+	}
+}
@@ -0,0 +1,20 @@
+package idempotency
+
+import (
+	"time"
+)
+
+// Record is the first response served for an idempotency key.
+// While the original request is still running Completed is false and the
+// response fields are empty.
+type Record struct {
+	Key         string    `json:"key"`
+	Scope       string    `json:"scope"`       // Cliente que envió la clave (usuario o IP)
+	Fingerprint string    `json:"fingerprint"` // Hash del método, ruta y cuerpo de la request
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	Completed   bool      `json:"completed"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
@@ -0,0 +1,122 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"parte3/internal/health"
+	"sync"
+	"time"
+)
+
+// ErrKeyReused is returned when a key is sent again with a different payload.
+var ErrKeyReused = errors.New("idempotency key already used with a different payload")
+
+// ErrInProgress is returned when the first request with the key has not finished yet.
+var ErrInProgress = errors.New("a request with this idempotency key is still in progress")
+
+// ErrEmptyKey is returned when trying to reserve an empty key.
+var ErrEmptyKey = errors.New("empty idempotency key")
+
+// LocalStorage provides an in-memory implementation for storing idempotency
+// records. Expired records are evicted lazily while reserving new keys.
+type LocalStorage struct {
+	mu        sync.Mutex
+	m         map[string]*Record
+	ttl       time.Duration
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLocalStorage instantiates a new LocalStorage whose records live for ttl.
+func NewLocalStorage(ttl time.Duration) *LocalStorage {
+	return &LocalStorage{
+		m:   map[string]*Record{},
+		ttl: ttl,
+		now: time.Now,
+	}
+}
+
+func mapKey(scope, key string) string {
+	return scope + "\x00" + key
+}
+
+// Reserve registers the key for the scope before running the request.
+// If the key was already used it returns the stored record: the caller must
+// replay it when Completed is true. Returns ErrKeyReused if the fingerprint
+// differs and ErrInProgress if the first request is still running.
+// A nil record means the key is new and the caller owns it.
+func (l *LocalStorage) Reserve(scope, key, fingerprint string) (*Record, error) {
+	if key == "" {
+		return nil, ErrEmptyKey
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	if r, ok := l.m[mapKey(scope, key)]; ok && now.Before(r.ExpiresAt) {
+		if r.Fingerprint != fingerprint {
+			return nil, ErrKeyReused
+		}
+		if !r.Completed {
+			return nil, ErrInProgress
+		}
+		cp := *r
+		return &cp, nil
+	}
+
+	l.m[mapKey(scope, key)] = &Record{
+		Key:         key,
+		Scope:       scope,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(l.ttl),
+	}
+	return nil, nil
+}
+
+// Complete stores the response of a reserved key so it can be replayed.
+func (l *LocalStorage) Complete(scope, key string, status int, contentType string, body []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.m[mapKey(scope, key)]
+	if !ok {
+		return
+	}
+	r.Status = status
+	r.ContentType = contentType
+	r.Body = append([]byte(nil), body...)
+	r.Completed = true
+}
+
+// Release forgets a reserved key, e.g. when the request failed with a server
+// error and the client should be able to retry it.
+func (l *LocalStorage) Release(scope, key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.m, mapKey(scope, key))
+}
+
+// sweep evicts expired records at most once per ttl.
+// Must be called with the lock held.
+func (l *LocalStorage) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.ttl {
+		return
+	}
+	for k, r := range l.m {
+		if !now.Before(r.ExpiresAt) {
+			delete(l.m, k)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Check implements health.Checker: every POST with an Idempotency-Key
+// takes the lock.
+func (l *LocalStorage) Check(ctx context.Context) error {
+	return health.Lock(ctx, l.mu.TryLock, l.mu.Unlock)
+}
@@ -0,0 +1,97 @@
+package idempotency
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStorage_ReserveYComplete(t *testing.T) {
+	storage := NewLocalStorage(time.Hour)
+
+	r, err := storage.Reserve("ana", "k1", "fp")
+	require.NoError(t, err)
+	require.Nil(t, r, "una clave nueva le pertenece al que la reserva")
+
+	// mientras corre la primera request
+	_, err = storage.Reserve("ana", "k1", "fp")
+	require.ErrorIs(t, err, ErrInProgress)
+
+	storage.Complete("ana", "k1", http.StatusCreated, "application/json", []byte(`{"id":"1"}`))
+	r, err = storage.Reserve("ana", "k1", "fp")
+	require.NoError(t, err)
+	require.True(t, r.Completed)
+	require.Equal(t, http.StatusCreated, r.Status)
+	require.Equal(t, "application/json", r.ContentType)
+	require.JSONEq(t, `{"id":"1"}`, string(r.Body))
+
+	// la misma clave con otro cuerpo
+	_, err = storage.Reserve("ana", "k1", "otro-fp")
+	require.ErrorIs(t, err, ErrKeyReused)
+
+	// las claves son por cliente
+	r, err = storage.Reserve("bob", "k1", "otro-fp")
+	require.NoError(t, err)
+	require.Nil(t, r)
+
+	_, err = storage.Reserve("ana", "", "fp")
+	require.ErrorIs(t, err, ErrEmptyKey)
+}
+
+func TestLocalStorage_CompleteCopiaElCuerpo(t *testing.T) {
+	storage := NewLocalStorage(time.Hour)
+	_, err := storage.Reserve("ana", "k1", "fp")
+	require.NoError(t, err)
+
+	body := []byte("original")
+	storage.Complete("ana", "k1", http.StatusOK, "text/plain", body)
+	copy(body, "cambiado")
+
+	r, err := storage.Reserve("ana", "k1", "fp")
+	require.NoError(t, err)
+	require.Equal(t, "original", string(r.Body))
+
+	// completar una clave que no se reservó no la crea
+	storage.Complete("ana", "k2", http.StatusOK, "text/plain", body)
+	r, err = storage.Reserve("ana", "k2", "fp")
+	require.NoError(t, err)
+	require.Nil(t, r)
+}
+
+func TestLocalStorage_Release(t *testing.T) {
+	storage := NewLocalStorage(time.Hour)
+	_, err := storage.Reserve("ana", "k1", "fp")
+	require.NoError(t, err)
+
+	// liberada, el cliente puede reintentar aunque cambie el cuerpo
+	storage.Release("ana", "k1")
+	r, err := storage.Reserve("ana", "k1", "otro-fp")
+	require.NoError(t, err)
+	require.Nil(t, r)
+}
+
+func TestLocalStorage_Expiracion(t *testing.T) {
+	now := time.Unix(1000, 0)
+	storage := NewLocalStorage(time.Minute)
+	storage.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		_, err := storage.Reserve("ana", fmt.Sprint("k", i), "fp")
+		require.NoError(t, err)
+		storage.Complete("ana", fmt.Sprint("k", i), http.StatusCreated, "", nil)
+	}
+
+	// vencida, la clave vuelve a ser nueva aunque cambie el cuerpo
+	now = now.Add(time.Minute)
+	r, err := storage.Reserve("ana", "k0", "otro-fp")
+	require.NoError(t, err)
+	require.Nil(t, r)
+
+	// el barrido se llevó las demás
+	storage.mu.Lock()
+	require.Len(t, storage.m, 1)
+	storage.mu.Unlock()
+}
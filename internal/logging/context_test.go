@@ -0,0 +1,32 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestFromContext(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	base := zap.New(core)
+	fallback := zap.NewNop()
+
+	// sin logger en el contexto se usa el de respaldo
+	require.Same(t, fallback, FromContext(context.Background(), fallback))
+
+	ctx := NewContext(context.Background(), base.With(zap.String("request_id", "abc")))
+	FromContext(ctx, fallback).Warn("user not found for sale")
+
+	require.Equal(t, 1, logs.Len())
+	entry := logs.All()[0]
+	require.Equal(t, "user not found for sale", entry.Message)
+	require.Equal(t, "abc", entry.ContextMap()["request_id"])
+}
+
+func TestRequestID(t *testing.T) {
+	require.Empty(t, RequestID(context.Background()))
+	require.Equal(t, "abc", RequestID(WithRequestID(context.Background(), "abc")))
+}
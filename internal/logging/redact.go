@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Policy is what the logs show of a field with personal data.
+type Policy string
+
+// Políticas de los campos con datos personales.
+const (
+	PolicyMask Policy = "mask" // por defecto: solo el primer carácter, "J***"
+	PolicyHide Policy = "hide" // el campo no se loguea
+	PolicyShow Policy = "show" // el valor completo, solo para depurar
+)
+
+// ParsePolicy returns the policy named s.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case PolicyMask, PolicyHide, PolicyShow:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown redaction policy %q, use mask, hide or show", s)
+}
+
+// policies are the overrides by field, e.g. "user.email", set at startup.
+var policies atomic.Pointer[map[string]Policy]
+
+// SetPolicies replaces the policy overrides. The fields not in p are masked.
+func SetPolicies(p map[string]Policy) {
+	copied := make(map[string]Policy, len(p))
+	for field, policy := range p {
+		copied[field] = policy
+	}
+	policies.Store(&copied)
+}
+
+// PolicyOf returns the policy of field, PolicyMask unless overridden.
+func PolicyOf(field string) Policy {
+	if p := policies.Load(); p != nil {
+		if policy, ok := (*p)[field]; ok {
+			return policy
+		}
+	}
+	return PolicyMask
+}
+
+// Mask keeps the first character of value so logs can still tell values
+// apart at a glance, and hides the rest and its length.
+func Mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	r, _ := utf8.DecodeRuneInString(value)
+	return string(r) + "***"
+}
+
+// AddPII adds value under key following the policy of field. It is meant
+// for the MarshalLogObject implementations of the domain types:
+//
+//	logging.AddPII(enc, "user.email", "email", u.Email)
+func AddPII(enc zapcore.ObjectEncoder, field, key, value string) {
+	switch PolicyOf(field) {
+	case PolicyHide:
+	case PolicyShow:
+		enc.AddString(key, value)
+	default:
+		enc.AddString(key, Mask(value))
+	}
+}
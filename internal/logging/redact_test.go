@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestAddPII(t *testing.T) {
+	t.Cleanup(func() { SetPolicies(nil) })
+
+	add := func() map[string]any {
+		enc := zapcore.NewMapObjectEncoder()
+		AddPII(enc, "user.email", "email", "juana@example.com")
+		AddPII(enc, "user.name", "name", "Juana")
+		AddPII(enc, "user.nickname", "nickname", "")
+		return enc.Fields
+	}
+
+	// por defecto todo se enmascara, sin delatar el largo
+	require.Equal(t, map[string]any{"email": "j***", "name": "J***", "nickname": ""}, add())
+
+	SetPolicies(map[string]Policy{"user.email": PolicyShow, "user.name": PolicyHide})
+	require.Equal(t, map[string]any{"email": "juana@example.com", "nickname": ""}, add())
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("hide")
+	require.NoError(t, err)
+	require.Equal(t, PolicyHide, p)
+
+	_, err = ParsePolicy("partial")
+	require.Error(t, err)
+}
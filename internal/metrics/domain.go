@@ -0,0 +1,20 @@
+package metrics
+
+// Recorder receives the domain events the services count. The services use
+// Nop until one is set, so they work the same without metrics.
+type Recorder interface {
+	UserCreated()
+	UserDeleted()
+	// SaleCreated records a new sale with its initial status.
+	SaleCreated(status string, amount float64)
+	// SaleTransitioned records a status change of a sale.
+	SaleTransitioned(from, to string)
+}
+
+// Nop is a Recorder that discards everything.
+type Nop struct{}
+
+func (Nop) UserCreated()                    {}
+func (Nop) UserDeleted()                    {}
+func (Nop) SaleCreated(string, float64)     {}
+func (Nop) SaleTransitioned(string, string) {}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus records the HTTP and domain metrics in its own registry, so
+// several instances (e.g. one per test) do not collide.
+type Prometheus struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	usersCreated    prometheus.Counter
+	usersDeleted    prometheus.Counter
+	salesCreated    *prometheus.CounterVec
+	saleTransitions *prometheus.CounterVec
+	saleAmount      *prometheus.HistogramVec
+}
+
+var _ Recorder = (*Prometheus)(nil)
+
+// NewPrometheus creates the collectors and registers them, together with
+// the Go runtime and process ones.
+func NewPrometheus() *Prometheus {
+	p := &Prometheus{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by method, route template and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		usersCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "users_created_total",
+			Help: "Users created.",
+		}),
+		usersDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "users_deleted_total",
+			Help: "Users logically deleted.",
+		}),
+		salesCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sales_created_total",
+			Help: "Sales created by initial status.",
+		}, []string{"status"}),
+		saleTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sale_transitions_total",
+			Help: "Sale status changes by previous and new status.",
+		}, []string{"from", "to"}),
+		saleAmount: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "sale_amount",
+			Help:    "Amount of the created sales by initial status.",
+			Buckets: prometheus.ExponentialBuckets(10, 10, 6), // 10 a 1.000.000
+		}, []string{"status"}),
+	}
+	p.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		p.requests,
+		p.requestDuration,
+		p.usersCreated,
+		p.usersDeleted,
+		p.salesCreated,
+		p.saleTransitions,
+		p.saleAmount,
+	)
+	return p
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{Registry: p.registry})
+}
+
+// ObserveRequest records a served request. route must be the route
+// template, e.g. /users/:id, never the raw path: every distinct value is a
+// new time series.
+func (p *Prometheus) ObserveRequest(method, route string, status int, elapsed time.Duration) {
+	code := strconv.Itoa(status)
+	p.requests.WithLabelValues(method, route, code).Inc()
+	p.requestDuration.WithLabelValues(method, route, code).Observe(elapsed.Seconds())
+}
+
+func (p *Prometheus) UserCreated() {
+	p.usersCreated.Inc()
+}
+
+func (p *Prometheus) UserDeleted() {
+	p.usersDeleted.Inc()
+}
+
+func (p *Prometheus) SaleCreated(status string, amount float64) {
+	p.salesCreated.WithLabelValues(status).Inc()
+	p.saleAmount.WithLabelValues(status).Observe(amount)
+}
+
+func (p *Prometheus) SaleTransitioned(from, to string) {
+	p.saleTransitions.WithLabelValues(from, to).Inc()
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheus_Dominio(t *testing.T) {
+	p := NewPrometheus()
+	p.UserCreated()
+	p.UserCreated()
+	p.UserDeleted()
+	p.SaleCreated("pending", 150)
+	p.SaleCreated("approved", 20000)
+	p.SaleCreated("pending", 50)
+	p.SaleTransitioned("pending", "approved")
+
+	require.Equal(t, 2.0, testutil.ToFloat64(p.usersCreated))
+	require.Equal(t, 1.0, testutil.ToFloat64(p.usersDeleted))
+	require.Equal(t, 2.0, testutil.ToFloat64(p.salesCreated.WithLabelValues("pending")))
+	require.Equal(t, 1.0, testutil.ToFloat64(p.salesCreated.WithLabelValues("approved")))
+	require.Equal(t, 1.0, testutil.ToFloat64(p.saleTransitions.WithLabelValues("pending", "approved")))
+	require.Equal(t, 0.0, testutil.ToFloat64(p.saleTransitions.WithLabelValues("pending", "rejected")))
+
+	// el histograma de montos cuenta cada venta en su bucket
+	require.NoError(t, testutil.CollectAndCompare(p.saleAmount, strings.NewReader(`
+# HELP sale_amount Amount of the created sales by initial status.
+# TYPE sale_amount histogram
+sale_amount_bucket{status="approved",le="10"} 0
+sale_amount_bucket{status="approved",le="100"} 0
+sale_amount_bucket{status="approved",le="1000"} 0
+sale_amount_bucket{status="approved",le="10000"} 0
+sale_amount_bucket{status="approved",le="100000"} 1
+sale_amount_bucket{status="approved",le="1e+06"} 1
+sale_amount_bucket{status="approved",le="+Inf"} 1
+sale_amount_sum{status="approved"} 20000
+sale_amount_count{status="approved"} 1
+sale_amount_bucket{status="pending",le="10"} 0
+sale_amount_bucket{status="pending",le="100"} 1
+sale_amount_bucket{status="pending",le="1000"} 2
+sale_amount_bucket{status="pending",le="10000"} 2
+sale_amount_bucket{status="pending",le="100000"} 2
+sale_amount_bucket{status="pending",le="1e+06"} 2
+sale_amount_bucket{status="pending",le="+Inf"} 2
+sale_amount_sum{status="pending"} 200
+sale_amount_count{status="pending"} 2
+`)))
+}
+
+func TestPrometheus_HandlerFormatoTexto(t *testing.T) {
+	p := NewPrometheus()
+	p.ObserveRequest(http.MethodGet, "/users/:id", http.StatusOK, 30*time.Millisecond)
+	p.ObserveRequest(http.MethodGet, "/users/:id", http.StatusNotFound, time.Millisecond)
+
+	rr := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Header().Get("Content-Type"), "text/plain")
+
+	body, _ := io.ReadAll(rr.Body)
+	text := string(body)
+	require.Contains(t, text, `http_requests_total{method="GET",route="/users/:id",status="200"} 1`)
+	require.Contains(t, text, `http_requests_total{method="GET",route="/users/:id",status="404"} 1`)
+	require.Contains(t, text, `http_request_duration_seconds_bucket{method="GET",route="/users/:id",status="200",le="0.05"} 1`)
+	require.Contains(t, text, "go_goroutines")
+
+	// las métricas tienen nombres y ayudas válidas
+	problems, err := testutil.GatherAndLint(p.registry)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+}
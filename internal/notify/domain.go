@@ -0,0 +1,20 @@
+package notify
+
+import "time"
+
+// Message is a notification for a single recipient, e.g. the email with a
+// password reset link. Data carries machine readable values (like the
+// token itself) for notifiers that do not render the body.
+type Message struct {
+	To      string            `json:"to"`
+	Subject string            `json:"subject"`
+	Body    string            `json:"body"`
+	Data    map[string]string `json:"data,omitempty"`
+	SentAt  time.Time         `json:"sent_at"`
+}
+
+// Notifier delivers messages to users. Implementations must be safe for
+// concurrent use.
+type Notifier interface {
+	Notify(msg Message) error
+}
@@ -0,0 +1,105 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"parte3/internal/logging"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogFieldTo is the field of the recipient for logging.SetPolicies.
+const LogFieldTo = "notify.to"
+
+// LogNotifier writes every message to the logger instead of sending it,
+// when no real notifier is configured. The body and the values of Data
+// carry the password tokens, so only the keys of Data are logged and the
+// recipient follows the policy of LogFieldTo.
+type LogNotifier struct {
+	logger *zap.Logger
+}
+
+// NewLogNotifier creates a LogNotifier.
+func NewLogNotifier(logger *zap.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+// Notify logs the message without its secrets.
+func (n *LogNotifier) Notify(msg Message) error {
+	n.logger.Info("notification not delivered, no notifier configured", zap.Object("notification", msg))
+	return nil
+}
+
+// MarshalLogObject logs the message without the body nor the values of
+// Data, the recipient is redacted following the logging policies.
+func (m Message) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	logging.AddPII(enc, LogFieldTo, "to", m.To)
+	enc.AddString("subject", m.Subject)
+	keys := make([]string, 0, len(m.Data))
+	for k := range m.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return enc.AddArray("data_keys", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+		for _, k := range keys {
+			arr.AppendString(k)
+		}
+		return nil
+	}))
+}
+
+// FileNotifier appends every message as a JSON line to a file, so local
+// setups and tests can read what would have been sent.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileNotifier creates a FileNotifier writing to path. The file is
+// created on the first message with permissions 0600.
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+// Notify appends the message to the file.
+func (n *FileNotifier) Notify(msg Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now()
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Check implements health.Checker: the file must be writable, otherwise
+// the password tokens cannot be delivered. It creates the file if missing
+// and does not take the lock, appending does not need it.
+func (n *FileNotifier) Check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,30 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogNotifier_NoLogueaSecretos(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	n := NewLogNotifier(zap.New(core))
+
+	require.NoError(t, n.Notify(Message{
+		To:      "ana@example.com",
+		Subject: "Reset your password",
+		Body:    "Use this token: secreto-123",
+		Data:    map[string]string{"token": "secreto-123", "purpose": "reset"},
+	}))
+
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()["notification"].(map[string]any)
+	require.Equal(t, "a***", fields["to"])
+	require.Equal(t, "Reset your password", fields["subject"])
+	require.Equal(t, []any{"purpose", "token"}, fields["data_keys"])
+	require.NotContains(t, fields, "body")
+	require.NotContains(t, fields, "data")
+}
@@ -0,0 +1,240 @@
+package openapi
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(time.Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// Generator builds schemas from Go types. Named structs are described once
+// in the components and referenced with $ref; the json tags give the
+// property names and the binding tags the validation rules.
+type Generator struct {
+	schemas  map[string]*Schema
+	names    map[reflect.Type]string
+	patterns map[string]string
+}
+
+// NewGenerator creates an empty Generator.
+func NewGenerator() *Generator {
+	return &Generator{
+		schemas:  map[string]*Schema{},
+		names:    map[reflect.Type]string{},
+		patterns: map[string]string{},
+	}
+}
+
+// Pattern declares the regular expression checked by a custom binding
+// validation, e.g. "regexp", so it is documented as the pattern of the
+// fields that use it.
+func (g *Generator) Pattern(validation, regex string) {
+	g.patterns[validation] = regex
+}
+
+// Schema returns the schema of the type of v, nil for a nil v.
+func (g *Generator) Schema(v any) *Schema {
+	if v == nil {
+		return nil
+	}
+	return g.typeSchema(reflect.TypeOf(v))
+}
+
+// Schemas returns the components built so far, by name.
+func (g *Generator) Schemas() map[string]*Schema {
+	return g.schemas
+}
+
+func (g *Generator) typeSchema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &Schema{Type: "integer", Description: "nanoseconds"}
+	case rawMessageType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.typeSchema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + g.component(t)}
+	default:
+		return &Schema{} // any e interfaces: cualquier valor
+	}
+}
+
+// component registers the named struct t and returns its component name.
+func (g *Generator) component(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := componentName(t.Name())
+	if _, taken := g.schemas[name]; taken {
+		name = componentName(path.Base(t.PkgPath())) + name
+	}
+	g.names[t] = name
+	g.schemas[name] = &Schema{} // reservado antes de recorrer, por los tipos recursivos
+	*g.schemas[name] = *g.structSchema(t)
+	return name
+}
+
+// componentName capitalizes name and drops the type arguments of generics.
+func componentName(name string) string {
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		name = name[:i]
+	}
+	r := []rune(name)
+	if len(r) > 0 {
+		r[0] = unicode.ToUpper(r[0])
+	}
+	return string(r)
+}
+
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	g.addFields(s, t)
+	return s
+}
+
+// addFields adds the fields of t to s, flattening the embedded structs like
+// encoding/json does.
+func (g *Generator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			g.addFields(s, ft)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := g.typeSchema(f.Type)
+		if g.applyBinding(prop, f.Type, f.Tag.Get("binding")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = prop
+	}
+}
+
+// applyBinding documents the validator rules of a binding tag on prop and
+// reports whether the field is required. Rules it does not know, like
+// required_without, are left to the description of the operation.
+func (g *Generator) applyBinding(prop *Schema, t reflect.Type, tag string) (required bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(rule, "=")
+		switch key {
+		case "dive":
+			return required // lo que sigue valida los elementos
+		case "required":
+			required = true
+		case "email":
+			prop.Format = "email"
+		case "oneof":
+			prop.Enum = strings.Fields(value)
+		case "gt", "gte", "lt", "lte":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "gt":
+				prop.ExclusiveMinimum = &n
+			case "gte":
+				prop.Minimum = &n
+			case "lt":
+				prop.ExclusiveMaximum = &n
+			case "lte":
+				prop.Maximum = &n
+			}
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			applyLength(prop, t, key, n)
+		default:
+			if p, ok := g.patterns[key]; ok {
+				prop.Pattern = p
+			}
+		}
+	}
+	return required
+}
+
+// applyLength documents min, max and len, which validate the length of
+// strings and slices and the value of numbers.
+func applyLength(prop *Schema, t reflect.Type, key string, n float64) {
+	switch t.Kind() {
+	case reflect.String:
+		l := int(n)
+		if key != "max" {
+			prop.MinLength = &l
+		}
+		if key != "min" {
+			prop.MaxLength = &l
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		l := int(n)
+		if key != "max" {
+			prop.MinItems = &l
+		}
+		if key != "min" {
+			prop.MaxItems = &l
+		}
+	default:
+		if key != "max" {
+			prop.Minimum = &n
+		}
+		if key != "min" {
+			prop.Maximum = &n
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package openapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type direccion struct {
+	Calle string `json:"calle" binding:"required"`
+}
+
+type pedido struct {
+	Nombre     string      `json:"nombre" binding:"required,regexp"`
+	Email      string      `json:"email,omitempty" binding:"omitempty,email"`
+	Monto      float64     `json:"monto" binding:"required,gt=0"`
+	Estado     string      `json:"estado" binding:"oneof=a b"`
+	Clave      string      `json:"clave" binding:"min=8,max=128"`
+	Direccion  *direccion  `json:"direccion"`
+	Anteriores []direccion `json:"anteriores"`
+	Creado     time.Time   `json:"creado"`
+	Oculto     string      `json:"-"`
+	privado    string
+}
+
+type conEmbebido struct {
+	*pedido
+	Extra int `json:"extra"`
+}
+
+func TestGenerator_Schema(t *testing.T) {
+	gen := NewGenerator()
+	gen.Pattern("regexp", "^[a-z]+$")
+
+	ref := gen.Schema(pedido{})
+	require.Equal(t, "#/components/schemas/Pedido", ref.Ref)
+
+	s := gen.Schemas()["Pedido"]
+	require.Equal(t, []string{"nombre", "monto"}, s.Required)
+	require.Equal(t, "^[a-z]+$", s.Properties["nombre"].Pattern)
+	require.Equal(t, "email", s.Properties["email"].Format)
+	require.Equal(t, 0.0, *s.Properties["monto"].ExclusiveMinimum)
+	require.Equal(t, []string{"a", "b"}, s.Properties["estado"].Enum)
+	require.Equal(t, 8, *s.Properties["clave"].MinLength)
+	require.Equal(t, 128, *s.Properties["clave"].MaxLength)
+	require.Equal(t, "#/components/schemas/Direccion", s.Properties["direccion"].Ref)
+	require.Equal(t, "#/components/schemas/Direccion", s.Properties["anteriores"].Items.Ref)
+	require.Equal(t, "date-time", s.Properties["creado"].Format)
+	require.NotContains(t, s.Properties, "Oculto")
+	require.NotContains(t, s.Properties, "privado")
+	require.Equal(t, []string{"calle"}, gen.Schemas()["Direccion"].Required)
+
+	// los structs embebidos se aplanan como en encoding/json
+	gen.Schema(conEmbebido{})
+	embebido := gen.Schemas()["ConEmbebido"]
+	require.Contains(t, embebido.Properties, "nombre")
+	require.Contains(t, embebido.Properties, "extra")
+	require.Equal(t, []string{"nombre", "monto"}, embebido.Required)
+
+	require.Nil(t, gen.Schema(nil))
+	require.Equal(t, "array", gen.Schema([]int{}).Type)
+}
@@ -0,0 +1,34 @@
+package ratelimit
+
+import "time"
+
+// Limit is the policy of a token bucket: it holds up to Burst tokens and
+// refills Rate tokens per second. Every request takes one token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerSecond allows n requests per second with bursts of burst requests.
+func PerSecond(n float64, burst int) Limit {
+	return Limit{Rate: n, Burst: burst}
+}
+
+// PerMinute allows n requests per minute with bursts of burst requests.
+func PerMinute(n float64, burst int) Limit {
+	return Limit{Rate: n / 60, Burst: burst}
+}
+
+// fill is how long an empty bucket takes to be full again.
+func (l Limit) fill() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+// Decision is the result of taking a token from a bucket.
+type Decision struct {
+	Allowed    bool
+	Limit      int           // tamaño del bucket
+	Remaining  int           // tokens enteros que quedan
+	Reset      time.Duration // hasta que el bucket vuelve a estar lleno
+	RetryAfter time.Duration // hasta el próximo token, solo si no se permitió
+}
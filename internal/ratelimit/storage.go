@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"parte3/internal/health"
+	"sync"
+	"time"
+)
+
+// bucket is the state of one client on one route.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// refill adds the tokens earned since the last request.
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+		b.last = now
+	}
+}
+
+// LocalStorage provides an in-memory implementation of token buckets.
+// Buckets that are full again and were not used for idle are evicted
+// lazily while taking tokens, so the map does not grow with every client
+// that ever made a request.
+type LocalStorage struct {
+	mu        sync.Mutex
+	m         map[string]*bucket
+	idle      time.Duration
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLocalStorage instantiates a new LocalStorage evicting buckets idle for idle.
+func NewLocalStorage(idle time.Duration) *LocalStorage {
+	return &LocalStorage{
+		m:    map[string]*bucket{},
+		idle: idle,
+		now:  time.Now,
+	}
+}
+
+// Take takes a token from the bucket of key, created full with limit the
+// first time. The request must be rejected when Decision.Allowed is false.
+func (l *LocalStorage) Take(key string, limit Limit) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.m[key]
+	if !ok || b.limit != limit {
+		b = &bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		l.m[key] = b
+	}
+	b.refill(now)
+
+	d := Decision{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+	return d
+}
+
+// Len returns how many buckets are kept.
+func (l *LocalStorage) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.m)
+}
+
+// sweep evicts the idle buckets at most once per idle period. A bucket is
+// only evicted once it would be full anyway, so evicting it never gives a
+// client more tokens than it had.
+// Must be called with the lock held.
+func (l *LocalStorage) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idle {
+		return
+	}
+	for k, b := range l.m {
+		idle := now.Sub(b.last)
+		if idle >= l.idle && idle >= b.limit.fill() {
+			delete(l.m, k)
+		}
+	}
+	l.lastSweep = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Check implements health.Checker: every request takes the lock.
+func (l *LocalStorage) Check(ctx context.Context) error {
+	return health.Lock(ctx, l.mu.TryLock, l.mu.Unlock)
+}
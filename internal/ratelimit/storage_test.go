@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStorage_Take(t *testing.T) {
+	now := time.Unix(1000, 0)
+	storage := NewLocalStorage(time.Minute)
+	storage.now = func() time.Time { return now }
+	limit := PerSecond(2, 3)
+
+	// el bucket arranca lleno
+	for i := 2; i >= 0; i-- {
+		d := storage.Take("a", limit)
+		require.True(t, d.Allowed)
+		require.Equal(t, i, d.Remaining)
+	}
+	d := storage.Take("a", limit)
+	require.False(t, d.Allowed)
+	require.Equal(t, 500*time.Millisecond, d.RetryAfter)
+	require.Equal(t, 1500*time.Millisecond, d.Reset)
+
+	// otro cliente tiene su propio bucket
+	require.True(t, storage.Take("b", limit).Allowed)
+
+	// recarga 2 tokens por segundo, sin pasar el máximo
+	now = now.Add(500 * time.Millisecond)
+	require.True(t, storage.Take("a", limit).Allowed)
+	require.False(t, storage.Take("a", limit).Allowed)
+	now = now.Add(time.Hour)
+	d = storage.Take("a", limit)
+	require.True(t, d.Allowed)
+	require.Equal(t, 2, d.Remaining)
+}
+
+func TestLocalStorage_EvictaBucketsInactivos(t *testing.T) {
+	now := time.Unix(1000, 0)
+	storage := NewLocalStorage(time.Minute)
+	storage.now = func() time.Time { return now }
+	slow := PerMinute(1, 5) // tarda 5 minutos en llenarse
+
+	for i := 0; i < 100; i++ {
+		storage.Take(fmt.Sprint("fast-", i), PerSecond(10, 10))
+	}
+	storage.Take("slow", slow)
+	require.Equal(t, 101, storage.Len())
+
+	// pasado el tiempo de inactividad solo quedan los que no se llenaron
+	now = now.Add(2 * time.Minute)
+	storage.Take("otro", PerSecond(10, 10))
+	require.Equal(t, 2, storage.Len())
+
+	now = now.Add(5 * time.Minute)
+	storage.Take("otro", PerSecond(10, 10))
+	require.Equal(t, 1, storage.Len())
+}
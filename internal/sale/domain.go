@@ -6,18 +6,23 @@ import (
 
 // User represents a system user with metadata for auditing and versioning.
 type Sale struct {
-	ID        string    `json:"id"`
-	UserID    string    `json:"user_id"`
-	Amount    float64   `json:"amount"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Version   int       `json:"version"`
-	Status    string    `json:"status"` // Estado de la venta (pending, approved, rejected)
+	ID        string    `json:"id" xml:"id"`
+	UserID    string    `json:"user_id" xml:"user_id"`
+	Amount    float64   `json:"amount" xml:"amount"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+	Version   int       `json:"version" xml:"version"`
+	Status    string    `json:"status" xml:"status"`                                    // Estado de la venta (pending, awaiting_approval, approved, rejected)
+	Approvers []string  `json:"approvers,omitempty" xml:"approvers>approver,omitempty"` // Quienes aprobaron la venta, en orden
 }
 
+// StatusAwaitingApproval is the status of a sale above the second approval
+// threshold that was approved once and waits for a different approver.
+const StatusAwaitingApproval = "awaiting_approval"
+
 type CreateSaleRequest struct {
-	UserID string  `json:"user_id" binding:"required"`
-	Amount float64 `json:"amount" binding:"required,gt=0"` // Monto de la venta
+	UserID string  `json:"user_id" xml:"user_id" binding:"required"`
+	Amount float64 `json:"amount" xml:"amount" binding:"required,gt=0"` // Monto de la venta
 }
 
 type GetSalesRequest struct {
@@ -26,13 +31,24 @@ type GetSalesRequest struct {
 // UpdateFields represents the optional fields for updating a User.
 // A nil pointer means “no change” for that field.
 type UpdateSale struct {
-	Status string `json:"status" binding:"required,oneof=approved rejected"`
+	Status string `json:"status" xml:"status" binding:"required,oneof=approved rejected"`
 }
 
 type Metadata struct {
-	Quantity    int     `json:"quantity"`
-	Approved    int     `json:"approved"`
-	Rejected    int     `json:"rejected"`
-	Pending     int     `json:"pending"`
-	TotalAmount float64 `json:"total_amount"`
+	Quantity    int     `json:"quantity" xml:"quantity"`
+	Approved    int     `json:"approved" xml:"approved"`
+	Rejected    int     `json:"rejected" xml:"rejected"`
+	Pending     int     `json:"pending" xml:"pending"`
+	Awaiting    int     `json:"awaiting_approval" xml:"awaiting_approval"`
+	TotalAmount float64 `json:"total_amount" xml:"total_amount"`
+}
+
+// Summary represents the lifetime sales activity of a single user.
+// It is meant to be embedded in other resources (e.g. the user profile)
+// so clients do not need a second call to GET /sales/:id.
+type Summary struct {
+	Metadata
+	FirstSaleAt *time.Time `json:"first_sale_at,omitempty" xml:"first_sale_at,omitempty"`
+	LastSaleAt  *time.Time `json:"last_sale_at,omitempty" xml:"last_sale_at,omitempty"`
+	LargestSale *Sale      `json:"largest_sale,omitempty" xml:"largest_sale,omitempty"`
 }
@@ -0,0 +1,26 @@
+package sale
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// MarshalLogObject logs the sale for zap. A sale only references its user
+// and approvers by ID, so unlike User nothing is redacted.
+func (s Sale) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("id", s.ID)
+	enc.AddString("user_id", s.UserID)
+	enc.AddFloat64("amount", s.Amount)
+	enc.AddString("status", s.Status)
+	enc.AddInt("version", s.Version)
+	enc.AddTime("created_at", s.CreatedAt)
+	enc.AddTime("updated_at", s.UpdatedAt)
+	if len(s.Approvers) > 0 {
+		return enc.AddArray("approvers", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+			for _, approver := range s.Approvers {
+				arr.AppendString(approver)
+			}
+			return nil
+		}))
+	}
+	return nil
+}
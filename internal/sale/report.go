@@ -0,0 +1,158 @@
+package sale
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Criterios válidos para ordenar el ranking de clientes.
+const (
+	RankByAmount = "amount"
+	RankByCount  = "count"
+)
+
+// DefaultRankingLimit is used when the caller does not ask for a limit.
+const DefaultRankingLimit = 10
+
+// MaxRankingLimit caps how many entries a ranking can return.
+const MaxRankingLimit = 100
+
+// ErrInvalidRankBy is returned when the ranking criteria is not amount or count.
+var ErrInvalidRankBy = errors.New("invalid ranking criteria, must be amount or count")
+
+// ErrInvalidLimit is returned when the ranking limit is out of range.
+var ErrInvalidLimit = errors.New("invalid ranking limit")
+
+// ErrInvalidDateRange is returned when the window ends before it starts.
+var ErrInvalidDateRange = errors.New("invalid date range")
+
+// CustomerRank is one row of the top customers report.
+// Name is not known by the sale package and is filled by the caller.
+type CustomerRank struct {
+	Rank        int     `json:"rank" xml:"rank"`
+	UserID      string  `json:"user_id" xml:"user_id"`
+	Name        string  `json:"name" xml:"name"`
+	Approved    int     `json:"approved" xml:"approved"`
+	TotalAmount float64 `json:"total_amount" xml:"total_amount"`
+}
+
+// approvedBucket accumulates the approved sales of a user for a single day.
+type approvedBucket struct {
+	count  int
+	amount float64
+}
+
+// approvedEntry remembers how a sale was counted in the leaderboard, so it
+// can be discounted later even if the caller mutated the *Sale in place.
+type approvedEntry struct {
+	userID string
+	day    int64
+	amount float64
+}
+
+// leaderboard keeps per-user daily aggregates of approved sales. It is
+// updated incrementally on every write so rankings never scan all sales.
+type leaderboard struct {
+	days    map[string]map[int64]*approvedBucket
+	counted map[string]approvedEntry
+}
+
+func newLeaderboard() *leaderboard {
+	return &leaderboard{
+		days:    map[string]map[int64]*approvedBucket{},
+		counted: map[string]approvedEntry{},
+	}
+}
+
+// dayOf truncates t to the UTC day it belongs to.
+func dayOf(t time.Time) int64 {
+	return t.UTC().Truncate(24 * time.Hour).Unix()
+}
+
+// track updates the aggregates with the current state of the sale.
+func (b *leaderboard) track(sale *Sale) {
+	b.untrack(sale.ID)
+	if sale.Status != "approved" {
+		return
+	}
+
+	entry := approvedEntry{userID: sale.UserID, day: dayOf(sale.CreatedAt), amount: sale.Amount}
+	days, ok := b.days[entry.userID]
+	if !ok {
+		days = map[int64]*approvedBucket{}
+		b.days[entry.userID] = days
+	}
+	bucket, ok := days[entry.day]
+	if !ok {
+		bucket = &approvedBucket{}
+		days[entry.day] = bucket
+	}
+	bucket.count++
+	bucket.amount += entry.amount
+	b.counted[sale.ID] = entry
+}
+
+// untrack removes a previously counted sale from the aggregates.
+func (b *leaderboard) untrack(saleID string) {
+	entry, ok := b.counted[saleID]
+	if !ok {
+		return
+	}
+	delete(b.counted, saleID)
+
+	days := b.days[entry.userID]
+	bucket := days[entry.day]
+	bucket.count--
+	bucket.amount -= entry.amount
+	if bucket.count == 0 {
+		delete(days, entry.day)
+	}
+	if len(days) == 0 {
+		delete(b.days, entry.userID)
+	}
+}
+
+// rank sums the buckets inside [from, to] and returns the best limit users.
+// A zero from or to leaves that side of the window open.
+func (b *leaderboard) rank(from, to time.Time, by string, limit int) []*CustomerRank {
+	var ranking []*CustomerRank
+	for userID, days := range b.days {
+		row := &CustomerRank{UserID: userID}
+		for day, bucket := range days {
+			if !from.IsZero() && day < dayOf(from) {
+				continue
+			}
+			if !to.IsZero() && day > dayOf(to) {
+				continue
+			}
+			row.Approved += bucket.count
+			row.TotalAmount += bucket.amount
+		}
+		if row.Approved > 0 {
+			ranking = append(ranking, row)
+		}
+	}
+
+	sort.Slice(ranking, func(i, j int) bool {
+		a, b := ranking[i], ranking[j]
+		if by == RankByCount && a.Approved != b.Approved {
+			return a.Approved > b.Approved
+		}
+		if a.TotalAmount != b.TotalAmount {
+			return a.TotalAmount > b.TotalAmount
+		}
+		if a.Approved != b.Approved {
+			return a.Approved > b.Approved
+		}
+		return a.UserID < b.UserID
+	})
+
+	if len(ranking) > limit {
+		ranking = ranking[:limit]
+	}
+	for i, row := range ranking {
+		row.Rank = i + 1
+	}
+	return ranking
+}
@@ -1,21 +1,36 @@
 package sale
 
 import (
+	"context"
 	"errors"
 	"math/rand"
+	"parte3/internal/auth"
+	"parte3/internal/health"
+	"parte3/internal/logging"
+	"parte3/internal/metrics"
+	"parte3/internal/tracing"
 	"parte3/internal/user" // <-- Importante
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+// tracer creates the spans of the sales service and storage.
+var tracer = otel.Tracer("parte3/internal/sale")
+
 // Define errores específicos para ventas si es necesario
 var ErrUserNotFound = errors.New("user not found for sale")
 var ErrInvalidAmount = errors.New("sale amount must be positive")
 var ErrSaleNotActive = errors.New("sale is not active and cannot be updated")
 var ErrInvalidSaleStateTransition = errors.New("invalid state transition for sale")
 var ErrSaleMustBePending = errors.New("sale status must be pending to be updated")
+var ErrBatchAborted = errors.New("batch aborted because another item failed")
+var ErrSelfApproval = errors.New("users cannot approve or reject their own sales")
+var ErrAlreadyApproved = errors.New("sale was already approved by this actor, a different approver is required")
 
 // Service provides high-level user management operations on a LocalStorage backend.
 type Service struct {
@@ -23,6 +38,11 @@ type Service struct {
 	salesStorage *LocalStorage // Para guardar ventas (¡usa el storage de ventas!)
 	userService  user.Getter   // Para validar usuarios
 	logger       *zap.Logger
+	metrics      metrics.Recorder
+
+	// secondApprovalAbove is the amount above which a sale needs two
+	// distinct approvers, 0 disables the second approval.
+	secondApprovalAbove float64
 }
 
 // NewService creates a new Service.
@@ -35,28 +55,55 @@ func NewService(salesStorage *LocalStorage, userService user.Getter, logger *zap
 		salesStorage: salesStorage,
 		userService:  userService,
 		logger:       logger,
+		metrics:      metrics.Nop{},
 	}
 }
 
+// RequireSecondApproval makes sales with an amount above the given one need
+// two distinct approvers before they are approved. 0 disables it.
+func (s *Service) RequireSecondApproval(above float64) {
+	s.secondApprovalAbove = above
+}
+
+// log returns the logger of the request ctx belongs to, so the warnings of
+// the service can be correlated with it.
+func (s *Service) log(ctx context.Context) *zap.Logger {
+	return logging.FromContext(ctx, s.logger)
+}
+
+// SetMetrics sets where the sales created and their transitions are counted.
+func (s *Service) SetMetrics(m metrics.Recorder) {
+	s.metrics = m
+}
+
+// RegisterChecks registers the health check of the sales storage.
+func (s *Service) RegisterChecks(r *health.Registry) {
+	r.Register("sales.storage", health.Liveness, s.salesStorage.Check)
+}
+
 // Create adds a brand-new user to the system.
 // It sets CreatedAt and UpdatedAt to the current time and initializes Version to 1.
 // Returns ErrEmptyID if user.ID is empty.
-func (s *Service) Create(userID string, amount float64) (*Sale, error) {
+func (s *Service) Create(ctx context.Context, userID string, amount float64) (_ *Sale, err error) {
+	ctx, span := tracer.Start(ctx, "sale.Service.Create", trace.WithAttributes(tracing.KeyUserID.String(userID)))
+	defer tracing.End(span, &err)
 
-	// 1. Validar que el user_id exista
-	_, err := s.userService.Get(userID)
+	sale, err := s.create(ctx, userID, amount)
 	if err != nil {
-		if errors.Is(err, user.ErrNotFound) { // Comprueba si el error es 'user.ErrNotFound'
-			s.logger.Warn("user not found for sale", zap.String("userID", userID))
-			return nil, ErrUserNotFound // Devuelve nuestro error específico
-		}
-		return nil, err // Devuelve otros errores (ej: problemas internos del servicio de usuario)
+		return nil, err
 	}
+	span.SetAttributes(tracing.KeySaleID.String(sale.ID), tracing.KeySaleStatus.String(sale.Status))
+	s.metrics.SaleCreated(sale.Status, sale.Amount)
+	return sale, nil
+}
 
-	// 2. Validar monto
-	if amount <= 0 {
-		s.logger.Warn("invalid sale amount", zap.Float64("amount", amount))
-		return nil, ErrInvalidAmount
+// create stores a new sale without counting it, CreateBatch only counts
+// the sales that survive the batch.
+func (s *Service) create(ctx context.Context, userID string, amount float64) (*Sale, error) {
+
+	// 1. y 2. Validar usuario y monto
+	if err := s.Validate(ctx, userID, amount); err != nil {
+		return nil, err
 	}
 
 	// 3. Asignar estado aleatorio
@@ -76,8 +123,8 @@ func (s *Service) Create(userID string, amount float64) (*Sale, error) {
 	}
 
 	// 5. Guardar la venta
-	if err := s.salesStorage.Set(sale); err != nil {
-		s.logger.Error("failed to save sale", zap.Error(err), zap.Any("sale", sale))
+	if err := s.salesStorage.Set(ctx, sale); err != nil {
+		s.log(ctx).Error("failed to save sale", zap.Error(err), zap.Object("sale", sale))
 		return nil, err // Devuelve error si falla el guardado
 	}
 
@@ -85,73 +132,286 @@ func (s *Service) Create(userID string, amount float64) (*Sale, error) {
 	return sale, nil
 }
 
-func (s *Service) Get(userID string) ([]*Sale, *Metadata, error) {
-	sales, err := s.salesStorage.GetByUserID(userID)
+// Validate checks that a sale for userID and amount could be created.
+// Returns ErrUserNotFound if the user does not exist and ErrInvalidAmount if
+// the amount is not positive.
+func (s *Service) Validate(ctx context.Context, userID string, amount float64) (err error) {
+	ctx, span := tracer.Start(ctx, "sale.Service.Validate", trace.WithAttributes(tracing.KeyUserID.String(userID)))
+	defer tracing.End(span, &err)
+
+	// 1. Validar que el user_id exista
+	_, err = s.userService.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) { // Comprueba si el error es 'user.ErrNotFound'
+			s.log(ctx).Warn("user not found for sale", zap.String("userID", userID))
+			return ErrUserNotFound // Devuelve nuestro error específico
+		}
+		return err // Devuelve otros errores (ej: problemas internos del servicio de usuario)
+	}
+
+	// 2. Validar monto
+	if amount <= 0 {
+		s.log(ctx).Warn("invalid sale amount", zap.Float64("amount", amount))
+		return ErrInvalidAmount
+	}
+	return nil
+}
+
+// CreateBatch creates one sale per request and returns the created sales
+// and one error per item, index aligned with reqs. When atomic is true every
+// item is validated before creating anything, and if one still fails the
+// sales already stored are removed; the other items get ErrBatchAborted.
+func (s *Service) CreateBatch(ctx context.Context, reqs []CreateSaleRequest, atomic bool) ([]*Sale, []error) {
+	ctx, span := tracer.Start(ctx, "sale.Service.CreateBatch", trace.WithAttributes(attribute.Int("batch.size", len(reqs))))
+	defer span.End()
+
+	sales := make([]*Sale, len(reqs))
+	errs := make([]error, len(reqs))
+
+	if atomic {
+		failed := false
+		for i, req := range reqs {
+			if err := s.Validate(ctx, req.UserID, req.Amount); err != nil {
+				errs[i] = err
+				failed = true
+			}
+		}
+		if failed {
+			for i := range errs {
+				if errs[i] == nil {
+					errs[i] = ErrBatchAborted
+				}
+			}
+			return sales, errs
+		}
+	}
+
+	failed := false
+	for i, req := range reqs {
+		if failed && atomic {
+			errs[i] = ErrBatchAborted
+			continue
+		}
+		sales[i], errs[i] = s.create(ctx, req.UserID, req.Amount)
+		if errs[i] != nil {
+			failed = true
+		}
+	}
+	if !failed || !atomic {
+		for _, sale := range sales {
+			if sale != nil {
+				s.metrics.SaleCreated(sale.Status, sale.Amount)
+			}
+		}
+		return sales, errs
+	}
+
+	// el rollback se completa aunque el pedido se haya cancelado
+	rollback := context.WithoutCancel(ctx)
+	for i, sale := range sales {
+		if sale == nil {
+			continue
+		}
+		if err := s.salesStorage.Delete(rollback, sale.ID); err != nil {
+			s.log(ctx).Error("failed to rollback sale batch", zap.Error(err), zap.String("saleID", sale.ID))
+		}
+		sales[i] = nil
+		errs[i] = ErrBatchAborted
+	}
+	return sales, errs
+}
+
+func (s *Service) Get(ctx context.Context, userID string) (_ []*Sale, _ *Metadata, err error) {
+	ctx, span := tracer.Start(ctx, "sale.Service.Get", trace.WithAttributes(tracing.KeyUserID.String(userID)))
+	defer tracing.End(span, &err)
+
+	sales, err := s.salesStorage.GetByUserID(ctx, userID)
 	if err != nil {
 		return nil, nil, err
 	}
-	meta, err := s.salesStorage.FillMetadata(sales)
+	meta, err := s.salesStorage.Metadata(ctx, userID, "")
 	if err != nil {
 		return nil, nil, err
 	}
 	return sales, meta, nil
 }
 
-func (s *Service) GetByStatus(userID string, status *string) ([]*Sale, *Metadata, error) {
-	err := s.salesStorage.ValidStatus(*status)
+// Each walks the sales filtered by userID and status (both optional) without
+// building the whole result set, see LocalStorage.Each.
+func (s *Service) Each(ctx context.Context, userID string, status string, fn func(*Sale) error) (err error) {
+	ctx, span := tracer.Start(ctx, "sale.Service.Each", trace.WithAttributes(tracing.KeyUserID.String(userID), tracing.KeySaleStatus.String(status)))
+	defer tracing.End(span, &err)
+
+	if status != "" {
+		if err := s.salesStorage.ValidStatus(status); err != nil {
+			return err
+		}
+	}
+	return s.salesStorage.Each(ctx, userID, status, fn)
+}
+
+func (s *Service) GetByStatus(ctx context.Context, userID string, status *string) (_ []*Sale, _ *Metadata, err error) {
+	ctx, span := tracer.Start(ctx, "sale.Service.GetByStatus", trace.WithAttributes(tracing.KeyUserID.String(userID)))
+	defer tracing.End(span, &err)
+
+	err = s.salesStorage.ValidStatus(*status)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	sales, err := s.salesStorage.getByUserIdAndStatus(userID, *status)
+	sales, err := s.salesStorage.getByUserIdAndStatus(ctx, userID, *status)
 	if err != nil {
 		return nil, nil, err
 	}
-	meta, err := s.salesStorage.FillMetadata(sales)
+	meta, err := s.salesStorage.Metadata(ctx, userID, *status)
 	if err != nil {
 		return nil, nil, err
 	}
 	return sales, meta, nil
 }
 
-func (s *Service) Update(saleID string, status string) (*Sale, error) {
+// Update approves or rejects a pending sale.
+// Only actors allowed to transition sales can do it, auth.ErrForbidden otherwise,
+// and never on their own sales (ErrSelfApproval). When the sale amount is
+// above the second approval threshold the first approval moves it to
+// StatusAwaitingApproval and a different approver has to confirm it
+// (ErrAlreadyApproved if the same one tries again); either of them may reject.
+// Returns ErrVersionConflict if another transition of the sale won the race.
+func (s *Service) Update(ctx context.Context, actor *auth.Principal, saleID string, status string) (_ *Sale, err error) {
+	ctx, span := tracer.Start(ctx, "sale.Service.Update", trace.WithAttributes(tracing.KeySaleID.String(saleID)))
+	defer tracing.End(span, &err)
+
+	if err := auth.Authorize(actor, auth.PermSalesTransition); err != nil {
+		s.log(ctx).Warn("sale transition forbidden", zap.String("saleID", saleID))
+		return nil, err
+	}
+
 	// 1. Validar que la venta exista
-	sale, err := s.salesStorage.GetForUpdate(saleID) // Asumiendo que tienes GetForUpdate como discutimos
+	sale, err := s.salesStorage.GetForUpdate(ctx, saleID) // Asumiendo que tienes GetForUpdate como discutimos
 	if err != nil {
 		if errors.Is(err, ErrNotFound) { // ErrNotFound de sales.storage
-			s.logger.Warn("sale not found for update", zap.String("saleID", saleID))
+			s.log(ctx).Warn("sale not found for update", zap.String("saleID", saleID))
 			return nil, ErrNotFound
 		}
 		return nil, err // Otro error del storage
 	}
 
 	if sale.Version == 0 {
-		s.logger.Warn("sale not active for update", zap.String("saleID", saleID))
+		s.log(ctx).Warn("sale not active for update", zap.String("saleID", saleID))
 		return nil, ErrSaleNotActive // devuelve error si la venta no está activa
 	}
 
-	if sale.Status != "pending" {
-		s.logger.Warn("sale must be pending for status update", zap.String("saleID", saleID), zap.String("current_status", sale.Status))
+	if sale.Status != "pending" && sale.Status != StatusAwaitingApproval {
+		s.log(ctx).Warn("sale must be pending for status update", zap.String("saleID", saleID), zap.String("current_status", sale.Status))
 		return nil, ErrSaleMustBePending // Devuelve error si el estado no es válido
 	}
 
 	if status != "approved" && status != "rejected" {
-		s.logger.Warn("invalid sale state transition", zap.String("saleID", saleID), zap.String("new_status", status))
+		s.log(ctx).Warn("invalid sale state transition", zap.String("saleID", saleID), zap.String("new_status", status))
 		return nil, ErrInvalidSaleStateTransition // Devuelve error si el estado no es válido
 	}
 
+	// regla de los cuatro ojos: nadie decide sobre sus propias ventas
+	if actor.Kind == auth.SubjectUser && actor.Subject == sale.UserID {
+		s.log(ctx).Warn("self approval rejected", zap.String("saleID", saleID), zap.String("actor", actor.Subject))
+		return nil, ErrSelfApproval
+	}
+
 	// 2. Actualizar estado
+	from := sale.Status
+	if status == "approved" {
+		for _, approver := range sale.Approvers {
+			if approver == actor.Subject {
+				s.log(ctx).Warn("sale already approved by actor", zap.String("saleID", saleID), zap.String("actor", actor.Subject))
+				return nil, ErrAlreadyApproved
+			}
+		}
+		// copia nueva: el slice guardado en el storage no se toca
+		sale.Approvers = append(append([]string(nil), sale.Approvers...), actor.Subject)
+		if s.secondApprovalAbove > 0 && sale.Amount > s.secondApprovalAbove && len(sale.Approvers) < 2 {
+			status = StatusAwaitingApproval
+		}
+	}
+	read := sale.Version
 	sale.Status = status
 	sale.UpdatedAt = time.Now()
 	sale.Version++
 
-	// 5. Guardar la venta
-	if err := s.salesStorage.Set(sale); err != nil {
-		s.logger.Error("failed to update sale status", zap.Error(err), zap.Any("sale", sale))
+	// 5. Guardar la venta, solo si nadie la cambió desde que la leímos
+	if err := s.salesStorage.SetIfVersion(ctx, sale, read); err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			s.log(ctx).Warn("concurrent sale status update", zap.String("saleID", saleID))
+			return nil, err
+		}
+		s.log(ctx).Error("failed to update sale status", zap.Error(err), zap.Object("sale", sale))
 		return nil, err // Devuelve error si falla el guardado
 	}
+	span.SetAttributes(tracing.KeySaleStatus.String(status))
+	s.metrics.SaleTransitioned(from, status)
 
 	// 6. Devolver la venta creada
 	return sale, nil
 
 }
+
+// Summary returns the lifetime sales summary for the given user.
+// A user without sales gets an empty summary instead of ErrNotFound.
+func (s *Service) Summary(ctx context.Context, userID string) (_ *Summary, err error) {
+	ctx, span := tracer.Start(ctx, "sale.Service.Summary", trace.WithAttributes(tracing.KeyUserID.String(userID)))
+	defer tracing.End(span, &err)
+
+	sales, err := s.salesStorage.GetByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return &Summary{}, nil
+		}
+		return nil, err
+	}
+
+	meta, err := s.salesStorage.Metadata(ctx, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return &Summary{}, nil
+	}
+
+	summary := &Summary{Metadata: *meta}
+	for _, sale := range sales {
+		createdAt := sale.CreatedAt
+		if summary.FirstSaleAt == nil || createdAt.Before(*summary.FirstSaleAt) {
+			summary.FirstSaleAt = &createdAt
+		}
+		if summary.LastSaleAt == nil || createdAt.After(*summary.LastSaleAt) {
+			summary.LastSaleAt = &createdAt
+		}
+		if summary.LargestSale == nil || sale.Amount > summary.LargestSale.Amount {
+			summary.LargestSale = sale
+		}
+	}
+	return summary, nil
+}
+
+// TopCustomers returns the users with the most approved sales between from
+// and to, ranked by amount or count. A limit of 0 uses DefaultRankingLimit.
+func (s *Service) TopCustomers(ctx context.Context, from, to time.Time, by string, limit int) (_ []*CustomerRank, err error) {
+	ctx, span := tracer.Start(ctx, "sale.Service.TopCustomers")
+	defer tracing.End(span, &err)
+
+	if by == "" {
+		by = RankByAmount
+	}
+	if by != RankByAmount && by != RankByCount {
+		return nil, ErrInvalidRankBy
+	}
+	if limit == 0 {
+		limit = DefaultRankingLimit
+	}
+	if limit < 0 || limit > MaxRankingLimit {
+		return nil, ErrInvalidLimit
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return nil, ErrInvalidDateRange
+	}
+	return s.salesStorage.TopCustomers(ctx, from, to, by, limit)
+}
@@ -2,8 +2,14 @@
 package sale
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	"parte3/internal/auth"
 	"parte3/internal/user"
 
 	"github.com/stretchr/testify/require"
@@ -12,7 +18,7 @@ import (
 // Mock de user.Service que siempre devuelve ErrNotFound
 type mockUserService struct{}
 
-func (m *mockUserService) Get(id string) (*user.User, error) {
+func (m *mockUserService) Get(ctx context.Context, id string) (*user.User, error) {
 	return nil, user.ErrNotFound
 }
 
@@ -24,9 +30,182 @@ func TestService_Crear_ConUser_Inexistente(t *testing.T) {
 
 	//paso donde se ejecuta la lógica (act)
 	//se intenta crear una venta con un usuario que no existe y se espera que falle
-	sale, err := saleService.Create("non-existent-user", 150.0)
+	sale, err := saleService.Create(ctx, "non-existent-user", 150.0)
 
 	// validar que el código se comporta como debería (assert)
 	require.Nil(t, sale)                     //no devuelve ninguna venta si el user no existe
 	require.ErrorIs(t, err, ErrUserNotFound) //se verifica que verifica que el error devuelto por saleSvc.Create debe ser ErrUserNotFound (de ventas).
 }
+
+func TestService_Summary(t *testing.T) {
+	salesStorage := NewLocalStorage()
+	saleService := NewService(salesStorage, &mockUserService{}, nil)
+
+	// un usuario sin ventas tiene un resumen vacío
+	summary, err := saleService.Summary(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, 0, summary.Quantity)
+	require.Nil(t, summary.LargestSale)
+
+	first := time.Now().Add(-time.Hour)
+	last := time.Now()
+	require.NoError(t, salesStorage.Set(ctx, &Sale{ID: "a", UserID: "user-1", Amount: 10, Status: "approved", CreatedAt: first}))
+	require.NoError(t, salesStorage.Set(ctx, &Sale{ID: "b", UserID: "user-1", Amount: 30, Status: "pending", CreatedAt: last}))
+	require.NoError(t, salesStorage.Set(ctx, &Sale{ID: "c", UserID: "user-2", Amount: 99, Status: "approved", CreatedAt: last}))
+
+	summary, err = saleService.Summary(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.Quantity)
+	require.Equal(t, 1, summary.Approved)
+	require.Equal(t, 1, summary.Pending)
+	require.Equal(t, 40.0, summary.TotalAmount)
+	require.True(t, first.Equal(*summary.FirstSaleAt))
+	require.True(t, last.Equal(*summary.LastSaleAt))
+	require.Equal(t, "b", summary.LargestSale.ID)
+}
+
+func TestService_TopCustomers(t *testing.T) {
+	salesStorage := NewLocalStorage()
+	saleService := NewService(salesStorage, &mockUserService{}, nil)
+
+	jan := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	feb := time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, salesStorage.Set(ctx, &Sale{ID: "a", UserID: "ana", Amount: 100, Status: "approved", CreatedAt: jan}))
+	require.NoError(t, salesStorage.Set(ctx, &Sale{ID: "b", UserID: "ana", Amount: 50, Status: "rejected", CreatedAt: jan}))
+	require.NoError(t, salesStorage.Set(ctx, &Sale{ID: "c", UserID: "bob", Amount: 40, Status: "approved", CreatedAt: jan}))
+	require.NoError(t, salesStorage.Set(ctx, &Sale{ID: "d", UserID: "bob", Amount: 30, Status: "approved", CreatedAt: feb}))
+	pending := &Sale{ID: "e", UserID: "bob", Amount: 70, Status: "pending", CreatedAt: feb}
+	require.NoError(t, salesStorage.Set(ctx, pending))
+
+	ranking, err := saleService.TopCustomers(ctx, time.Time{}, time.Time{}, RankByCount, 0)
+	require.NoError(t, err)
+	require.Len(t, ranking, 2)
+	require.Equal(t, "bob", ranking[0].UserID)
+	require.Equal(t, 2, ranking[0].Approved)
+
+	// la venta se aprueba modificando el mismo puntero, como hace Service.Update
+	pending.Status = "approved"
+	require.NoError(t, salesStorage.Set(ctx, pending))
+
+	ranking, err = saleService.TopCustomers(ctx, feb, feb, RankByAmount, 1)
+	require.NoError(t, err)
+	require.Len(t, ranking, 1)
+	require.Equal(t, "bob", ranking[0].UserID)
+	require.Equal(t, 100.0, ranking[0].TotalAmount)
+	require.Equal(t, 1, ranking[0].Rank)
+
+	require.NoError(t, salesStorage.Delete(ctx, "a"))
+	ranking, err = saleService.TopCustomers(ctx, jan, jan, RankByAmount, 0)
+	require.NoError(t, err)
+	require.Len(t, ranking, 1)
+	require.Equal(t, "bob", ranking[0].UserID)
+
+	_, err = saleService.TopCustomers(ctx, time.Time{}, time.Time{}, "name", 0)
+	require.ErrorIs(t, err, ErrInvalidRankBy)
+	_, err = saleService.TopCustomers(ctx, feb, jan, RankByAmount, 0)
+	require.ErrorIs(t, err, ErrInvalidDateRange)
+}
+
+func TestService_Update_CuatroOjos(t *testing.T) {
+	salesStorage := NewLocalStorage()
+	saleService := NewService(salesStorage, &mockUserService{}, nil)
+	saleService.RequireSecondApproval(100)
+
+	approver := func(id string) *auth.Principal {
+		return &auth.Principal{Subject: id, Kind: auth.SubjectUser, Role: auth.RoleApprover}
+	}
+	require.NoError(t, salesStorage.Set(ctx, &Sale{ID: "chica", UserID: "ana", Amount: 50, Status: "pending", Version: 1}))
+	require.NoError(t, salesStorage.Set(ctx, &Sale{ID: "grande", UserID: "ana", Amount: 500, Status: "pending", Version: 1}))
+
+	// nadie aprueba ni rechaza sus propias ventas
+	_, err := saleService.Update(ctx, approver("ana"), "chica", "approved")
+	require.ErrorIs(t, err, ErrSelfApproval)
+	_, err = saleService.Update(ctx, approver("ana"), "chica", "rejected")
+	require.ErrorIs(t, err, ErrSelfApproval)
+
+	// debajo del umbral alcanza con un aprobador
+	s, err := saleService.Update(ctx, approver("bob"), "chica", "approved")
+	require.NoError(t, err)
+	require.Equal(t, "approved", s.Status)
+	require.Equal(t, []string{"bob"}, s.Approvers)
+
+	// encima del umbral hacen falta dos aprobadores distintos
+	s, err = saleService.Update(ctx, approver("bob"), "grande", "approved")
+	require.NoError(t, err)
+	require.Equal(t, StatusAwaitingApproval, s.Status)
+
+	meta, err := salesStorage.Metadata(ctx, "ana", "")
+	require.NoError(t, err)
+	require.Equal(t, 1, meta.Awaiting)
+	require.Equal(t, 1, meta.Approved)
+
+	_, err = saleService.Update(ctx, approver("bob"), "grande", "approved")
+	require.ErrorIs(t, err, ErrAlreadyApproved)
+
+	s, err = saleService.Update(ctx, approver("carla"), "grande", "approved")
+	require.NoError(t, err)
+	require.Equal(t, "approved", s.Status)
+	require.Equal(t, []string{"bob", "carla"}, s.Approvers)
+
+	meta, err = salesStorage.Metadata(ctx, "ana", "")
+	require.NoError(t, err)
+	require.Equal(t, 0, meta.Awaiting)
+	require.Equal(t, 2, meta.Approved)
+}
+
+func TestService_Update_Concurrente(t *testing.T) {
+	salesStorage := NewLocalStorage()
+	saleService := NewService(salesStorage, &mockUserService{}, nil)
+	saleService.RequireSecondApproval(100)
+
+	// varios aprobadores, el último rechaza
+	var actors []*auth.Principal
+	for i := 0; i < 8; i++ {
+		actors = append(actors, &auth.Principal{Subject: fmt.Sprint("aprobador-", i), Kind: auth.SubjectUser, Role: auth.RoleApprover})
+	}
+	decision := func(j int) string {
+		if j == len(actors)-1 {
+			return "rejected"
+		}
+		return "approved"
+	}
+
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprint("venta-", i)
+		require.NoError(t, salesStorage.Set(ctx, &Sale{ID: id, UserID: "ana", Amount: 500, Status: "pending", Version: 1}))
+
+		// todos deciden a la vez sobre la misma venta
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		errs := make([]error, len(actors))
+		for j := range actors {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				<-start
+				_, errs[j] = saleService.Update(ctx, actors[j], id, decision(j))
+			}(j)
+		}
+		close(start)
+		wg.Wait()
+
+		// ninguna decisión exitosa se pierde: la versión cuenta cada una
+		ok := 0
+		for _, err := range errs {
+			if err == nil {
+				ok++
+				continue
+			}
+			require.True(t, errors.Is(err, ErrVersionConflict) || errors.Is(err, ErrSaleMustBePending), err)
+		}
+		stored, err := salesStorage.Get(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, 1+ok, stored.Version)
+		switch stored.Status {
+		case "approved":
+			require.Len(t, stored.Approvers, 2)
+		case StatusAwaitingApproval:
+			require.Len(t, stored.Approvers, 1)
+		}
+	}
+}
@@ -1,6 +1,15 @@
 package sale
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"parte3/internal/health"
+	"parte3/internal/tracing"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
 
 // ErrNotFound is returned when a user with the given ID is not found.
 var ErrNotFound = errors.New("sale not found")
@@ -10,32 +19,187 @@ var ErrEmptyID = errors.New("empty sale ID")
 
 var ErrInvalidStatus = errors.New("invalid status")
 
+// ErrVersionConflict is returned by SetIfVersion when the stored sale
+// changed since it was read.
+var ErrVersionConflict = errors.New("sale was modified concurrently, read it again and retry")
+
+// scanCheckEvery is how many sales a scan visits between checks of its context.
+const scanCheckEvery = 256
+
+// statusTotals accumulates the sales of a user that share the same status.
+type statusTotals struct {
+	count  int
+	amount float64
+}
+
+// indexEntry remembers how a sale was indexed, so it can be discounted on the
+// next write even if the caller changed its user, status or amount.
+type indexEntry struct {
+	userID string
+	status string
+	amount float64
+}
+
 // LocalStorage provides an in-memory implementation for storing users.
+// Besides the sales themselves it keeps a secondary index by user ID and
+// running per-user/per-status totals, both updated atomically on Set and
+// Delete so listings and metadata never walk the whole map.
+// Every method returns ctx.Err() once ctx is done; GetByUserID and Each also
+// check it while walking the sales.
 type LocalStorage struct {
-	m map[string]*Sale
+	mu          sync.RWMutex
+	m           map[string]*Sale
+	indexed     map[string]indexEntry
+	byUser      map[string]map[string]map[string]*Sale // userID -> status -> saleID
+	totals      map[string]map[string]*statusTotals    // userID -> status
+	leaderboard *leaderboard
 }
 
 // NewLocalStorage instantiates a new LocalStorage with an empty map.
 func NewLocalStorage() *LocalStorage {
 	return &LocalStorage{
-		m: map[string]*Sale{},
+		m:           map[string]*Sale{},
+		indexed:     map[string]indexEntry{},
+		byUser:      map[string]map[string]map[string]*Sale{},
+		totals:      map[string]map[string]*statusTotals{},
+		leaderboard: newLeaderboard(),
 	}
 }
 
 // Set stores or updates a user in the local storage.
 // Returns ErrEmptyID if the user has an empty ID.
-func (l *LocalStorage) Set(sale *Sale) error {
+// The storage keeps its own copy, later changes to sale are not visible until
+// Set is called again.
+func (l *LocalStorage) Set(ctx context.Context, sale *Sale) error {
+	ctx, span := tracer.Start(ctx, "sale.LocalStorage.Set", trace.WithAttributes(tracing.KeySaleID.String(sale.ID), tracing.KeyUserID.String(sale.UserID), tracing.KeySaleStatus.String(sale.Status)))
+	defer span.End()
+
 	if sale.ID == "" {
 		return ErrEmptyID
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stored := *sale
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	l.m[sale.ID] = sale
+	l.unindex(sale.ID)
+	l.m[sale.ID] = &stored
+	l.index(&stored)
+	l.leaderboard.track(&stored)
 	return nil
 }
 
+// SetIfVersion stores sale like Set, but only if the stored sale still has
+// the given version. Returns ErrVersionConflict otherwise, so two writers
+// that read the same version cannot overwrite each other.
+func (l *LocalStorage) SetIfVersion(ctx context.Context, sale *Sale, version int) error {
+	ctx, span := tracer.Start(ctx, "sale.LocalStorage.SetIfVersion", trace.WithAttributes(tracing.KeySaleID.String(sale.ID), tracing.KeySaleStatus.String(sale.Status)))
+	defer span.End()
+
+	if sale.ID == "" {
+		return ErrEmptyID
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stored := *sale
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.m[sale.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if current.Version != version {
+		return ErrVersionConflict
+	}
+	l.unindex(sale.ID)
+	l.m[sale.ID] = &stored
+	l.index(&stored)
+	l.leaderboard.track(&stored)
+	return nil
+}
+
+// index adds the sale to the user index and the running totals.
+// Must be called with the write lock held.
+func (l *LocalStorage) index(sale *Sale) {
+	statuses, ok := l.byUser[sale.UserID]
+	if !ok {
+		statuses = map[string]map[string]*Sale{}
+		l.byUser[sale.UserID] = statuses
+	}
+	sales, ok := statuses[sale.Status]
+	if !ok {
+		sales = map[string]*Sale{}
+		statuses[sale.Status] = sales
+	}
+	sales[sale.ID] = sale
+
+	totals, ok := l.totals[sale.UserID]
+	if !ok {
+		totals = map[string]*statusTotals{}
+		l.totals[sale.UserID] = totals
+	}
+	t, ok := totals[sale.Status]
+	if !ok {
+		t = &statusTotals{}
+		totals[sale.Status] = t
+	}
+	t.count++
+	t.amount += sale.Amount
+
+	l.indexed[sale.ID] = indexEntry{userID: sale.UserID, status: sale.Status, amount: sale.Amount}
+}
+
+// unindex removes a previously indexed sale from the user index and totals.
+// Must be called with the write lock held.
+func (l *LocalStorage) unindex(id string) {
+	entry, ok := l.indexed[id]
+	if !ok {
+		return
+	}
+	delete(l.indexed, id)
+
+	statuses := l.byUser[entry.userID]
+	delete(statuses[entry.status], id)
+	if len(statuses[entry.status]) == 0 {
+		delete(statuses, entry.status)
+	}
+	if len(statuses) == 0 {
+		delete(l.byUser, entry.userID)
+	}
+
+	totals := l.totals[entry.userID]
+	t := totals[entry.status]
+	t.count--
+	t.amount -= entry.amount
+	if t.count == 0 {
+		delete(totals, entry.status)
+	}
+	if len(totals) == 0 {
+		delete(l.totals, entry.userID)
+	}
+}
+
 // Read retrieves a user from the local storage by ID.
 // Returns ErrNotFound if the user is not found.
-func (l *LocalStorage) Get(id string) (*Sale, error) {
+func (l *LocalStorage) Get(ctx context.Context, id string) (*Sale, error) {
+	ctx, span := tracer.Start(ctx, "sale.LocalStorage.Get", trace.WithAttributes(tracing.KeySaleID.String(id)))
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
 	//lado izquierdo tipo de mapa (tipo mapa), booleano si existe o no en el mapa
 	s, ok := l.m[id]
 	if !ok {
@@ -43,14 +207,31 @@ func (l *LocalStorage) Get(id string) (*Sale, error) {
 		return nil, ErrNotFound
 	}
 
-	return s, nil
+	cp := *s
+	return &cp, nil
 }
 
-func (l *LocalStorage) GetByUserID(userID string) ([]*Sale, error) {
+// GetByUserID returns every sale of the user using the secondary index.
+// Returns ErrNotFound if the user has no sales.
+func (l *LocalStorage) GetByUserID(ctx context.Context, userID string) ([]*Sale, error) {
+	ctx, span := tracer.Start(ctx, "sale.LocalStorage.GetByUserID", trace.WithAttributes(tracing.KeyUserID.String(userID)))
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
 	var sales []*Sale
-	for _, sale := range l.m {
-		if sale.UserID == userID {
-			sales = append(sales, sale)
+	for _, byID := range l.byUser[userID] {
+		for _, sale := range byID {
+			if len(sales)%scanCheckEvery == 0 && ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			cp := *sale
+			sales = append(sales, &cp)
 		}
 	}
 	if len(sales) == 0 {
@@ -59,17 +240,22 @@ func (l *LocalStorage) GetByUserID(userID string) ([]*Sale, error) {
 	return sales, nil
 }
 
-func (l *LocalStorage) getByUserIdAndStatus(userID string, status string) ([]*Sale, error) {
+func (l *LocalStorage) getByUserIdAndStatus(ctx context.Context, userID string, status string) ([]*Sale, error) {
 	err := l.ValidStatus(status)
 	if err != nil {
 		return nil, err
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
 	var sales []*Sale
-	for _, sale := range l.m {
-		if sale.UserID == userID && sale.Status == status {
-			sales = append(sales, sale)
-		}
+	for _, sale := range l.byUser[userID][status] {
+		cp := *sale
+		sales = append(sales, &cp)
 	}
 	if len(sales) == 0 {
 		return nil, ErrNotFound
@@ -79,7 +265,7 @@ func (l *LocalStorage) getByUserIdAndStatus(userID string, status string) ([]*Sa
 }
 
 func (l *LocalStorage) ValidStatus(status string) error {
-	if status != "rejected" && status != "pending" && status != "approved" {
+	if status != "rejected" && status != "pending" && status != "approved" && status != StatusAwaitingApproval {
 		return ErrInvalidStatus
 	}
 	return nil
@@ -87,26 +273,138 @@ func (l *LocalStorage) ValidStatus(status string) error {
 
 // Delete removes a user from the local storage by ID.
 // Returns ErrNotFound if the user does not exist.
-func (l *LocalStorage) Delete(id string) error {
-	_, err := l.Get(id)
-	if err != nil {
+func (l *LocalStorage) Delete(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "sale.LocalStorage.Delete", trace.WithAttributes(tracing.KeySaleID.String(id)))
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.m[id]; !ok {
+		return ErrNotFound
+	}
+
+	l.unindex(id)
+	l.leaderboard.untrack(id)
 	delete(l.m, id) //eliminar keys de un mapa, parametro derecho que quiero eliminar, parametro lado izquierdo el mapa; elimina clave-valor
 	return nil
 }
 
+// Each calls fn with a copy of every sale matching userID and status, an
+// empty value matches everything. The matching sales are collected under the
+// read lock and fn runs without it, so slow consumers (e.g. a CSV download)
+// do not block writers. It stops at the first error returned by fn.
+func (l *LocalStorage) Each(ctx context.Context, userID string, status string, fn func(*Sale) error) error {
+	ctx, span := tracer.Start(ctx, "sale.LocalStorage.Each", trace.WithAttributes(tracing.KeyUserID.String(userID), tracing.KeySaleStatus.String(status)))
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	var matches []*Sale
+	switch {
+	case userID != "" && status != "":
+		for _, sale := range l.byUser[userID][status] {
+			matches = append(matches, sale)
+		}
+	case userID != "":
+		for _, byID := range l.byUser[userID] {
+			for _, sale := range byID {
+				matches = append(matches, sale)
+			}
+		}
+	default:
+		scanned := 0
+		for _, sale := range l.m {
+			if scanned++; scanned%scanCheckEvery == 0 && ctx.Err() != nil {
+				l.mu.RUnlock()
+				return ctx.Err()
+			}
+			if status == "" || sale.Status == status {
+				matches = append(matches, sale)
+			}
+		}
+	}
+	l.mu.RUnlock()
+
+	// las ventas guardadas no se modifican nunca (Set guarda una copia nueva),
+	// así que es seguro leerlas fuera del lock
+	for _, sale := range matches {
+		if err := ctx.Err(); err != nil {
+			return err // p. ej. el cliente cortó la descarga
+		}
+		cp := *sale
+		if err := fn(&cp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetForUpdate recupera una venta por ID, sin importar su estado 'Estado'.
 // Es útil para operaciones internas como actualizar o borrar donde necesitas la entidad tal cual está.
-func (l *LocalStorage) GetForUpdate(id string) (*Sale, error) {
-	s, ok := l.m[id]
+// Devuelve una copia: los cambios se guardan recién al llamar a Set.
+func (l *LocalStorage) GetForUpdate(ctx context.Context, id string) (*Sale, error) {
+	return l.Get(ctx, id)
+}
+
+// Metadata returns the running totals of the user's sales. When status is
+// not empty only the sales with that status are counted. It returns nil if
+// there is nothing to report, like FillMetadata does.
+func (l *LocalStorage) Metadata(ctx context.Context, userID string, status string) (*Metadata, error) {
+	ctx, span := tracer.Start(ctx, "sale.LocalStorage.Metadata", trace.WithAttributes(tracing.KeyUserID.String(userID)))
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if status != "" {
+		if err := l.ValidStatus(status); err != nil {
+			return nil, err
+		}
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	totals, ok := l.totals[userID]
 	if !ok {
-		return nil, ErrNotFound
+		return nil, nil
+	}
+
+	meta := new(Metadata)
+	for st, t := range totals {
+		if status != "" && st != status {
+			continue
+		}
+		meta.Quantity += t.count
+		meta.TotalAmount += t.amount
+		switch st {
+		case "pending":
+			meta.Pending += t.count
+		case "approved":
+			meta.Approved += t.count
+		case StatusAwaitingApproval:
+			meta.Awaiting += t.count
+		default:
+			meta.Rejected += t.count
+		}
+	}
+	if meta.Quantity == 0 {
+		return nil, nil
 	}
-	return s, nil
+	return meta, nil
 }
 
+// FillMetadata computes the metadata of an arbitrary list of sales.
+// Prefer Metadata when the list is all the sales of a user.
 func (l *LocalStorage) FillMetadata(sales []*Sale) (*Metadata, error) {
 	meta := new(Metadata)
 
@@ -130,6 +428,8 @@ func (l *LocalStorage) FillMetadata(sales []*Sale) (*Metadata, error) {
 			meta.Pending++
 		} else if sale.Status == "approved" {
 			meta.Approved++
+		} else if sale.Status == StatusAwaitingApproval {
+			meta.Awaiting++
 		} else {
 			meta.Rejected++
 		}
@@ -138,3 +438,24 @@ func (l *LocalStorage) FillMetadata(sales []*Sale) (*Metadata, error) {
 
 	return meta, nil
 }
+
+// TopCustomers ranks users by their approved sales inside [from, to] using
+// the incrementally maintained leaderboard instead of walking every sale.
+func (l *LocalStorage) TopCustomers(ctx context.Context, from, to time.Time, by string, limit int) ([]*CustomerRank, error) {
+	ctx, span := tracer.Start(ctx, "sale.LocalStorage.TopCustomers")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.leaderboard.rank(from, to, by, limit), nil
+}
+
+// Check implements health.Checker, see health.Lock.
+func (l *LocalStorage) Check(ctx context.Context) error {
+	return health.Lock(ctx, l.mu.TryRLock, l.mu.RUnlock)
+}
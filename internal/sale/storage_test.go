@@ -0,0 +1,193 @@
+package sale
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ctx es el contexto de los tests, nunca se cancela.
+var ctx = context.Background()
+
+func TestLocalStorage_MetadataConsistente(t *testing.T) {
+	storage := NewLocalStorage()
+	require.NoError(t, storage.Set(ctx, &Sale{ID: "a", UserID: "u1", Amount: 10, Status: "pending"}))
+	require.NoError(t, storage.Set(ctx, &Sale{ID: "b", UserID: "u1", Amount: 20, Status: "approved"}))
+	require.NoError(t, storage.Set(ctx, &Sale{ID: "c", UserID: "u2", Amount: 5, Status: "rejected"}))
+
+	// se modifica la venta obtenida y se vuelve a guardar, como hace Service.Update
+	s, err := storage.GetForUpdate(ctx, "a")
+	require.NoError(t, err)
+	s.Status = "rejected"
+	require.NoError(t, storage.Set(ctx, s))
+
+	meta, err := storage.Metadata(ctx, "u1", "")
+	require.NoError(t, err)
+	require.Equal(t, &Metadata{Quantity: 2, Approved: 1, Rejected: 1, TotalAmount: 30}, meta)
+
+	sales, err := storage.GetByUserID(ctx, "u1")
+	require.NoError(t, err)
+	expected, err := storage.FillMetadata(sales)
+	require.NoError(t, err)
+	require.Equal(t, expected, meta)
+
+	_, err = storage.getByUserIdAndStatus(ctx, "u1", "pending")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, storage.Delete(ctx, "b"))
+	meta, err = storage.Metadata(ctx, "u1", "")
+	require.NoError(t, err)
+	require.Equal(t, &Metadata{Quantity: 1, Rejected: 1, TotalAmount: 10}, meta)
+
+	require.NoError(t, storage.Delete(ctx, "a"))
+	meta, err = storage.Metadata(ctx, "u1", "")
+	require.NoError(t, err)
+	require.Nil(t, meta)
+	_, err = storage.GetByUserID(ctx, "u1")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLocalStorage_SetConcurrente(t *testing.T) {
+	storage := NewLocalStorage()
+	statuses := []string{"pending", "approved", "rejected"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_ = storage.Set(ctx, &Sale{ID: fmt.Sprintf("s%d", j), UserID: "u1", Amount: 1, Status: statuses[(i+j)%3]})
+				_, _ = storage.Metadata(ctx, "u1", "")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	sales, err := storage.GetByUserID(ctx, "u1")
+	require.NoError(t, err)
+	require.Len(t, sales, 20)
+	expected, err := storage.FillMetadata(sales)
+	require.NoError(t, err)
+	meta, err := storage.Metadata(ctx, "u1", "")
+	require.NoError(t, err)
+	require.Equal(t, expected, meta)
+}
+
+var (
+	benchStorage     *LocalStorage
+	benchStorageOnce sync.Once
+)
+
+// storageConUnMillon arma (una sola vez) un storage con 1M de ventas
+// repartidas entre 100k usuarios, 10 ventas por usuario.
+func storageConUnMillon(b *testing.B) *LocalStorage {
+	benchStorageOnce.Do(func() {
+		statuses := []string{"pending", "approved", "rejected"}
+		benchStorage = NewLocalStorage()
+		for i := 0; i < 1_000_000; i++ {
+			_ = benchStorage.Set(ctx, &Sale{
+				ID:     fmt.Sprintf("sale-%d", i),
+				UserID: fmt.Sprintf("user-%d", i%100_000),
+				Amount: float64(i%1000) + 1,
+				Status: statuses[i%3],
+			})
+		}
+	})
+	b.ResetTimer()
+	return benchStorage
+}
+
+func BenchmarkLocalStorage_GetByUserID_1M(b *testing.B) {
+	storage := storageConUnMillon(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := storage.GetByUserID(ctx, fmt.Sprintf("user-%d", i%100_000)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLocalStorage_Metadata_1M(b *testing.B) {
+	storage := storageConUnMillon(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := storage.Metadata(ctx, fmt.Sprintf("user-%d", i%100_000), ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLocalStorage_Set_1M(b *testing.B) {
+	storage := storageConUnMillon(b)
+	for i := 0; i < b.N; i++ {
+		s, err := storage.GetForUpdate(ctx, fmt.Sprintf("sale-%d", i%1_000_000))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := storage.Set(ctx, s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestLocalStorage_ContextoCancelado(t *testing.T) {
+	storage := NewLocalStorage()
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, storage.Set(ctx, &Sale{ID: fmt.Sprint(i), UserID: "ana", Amount: 10, Status: "approved"}))
+	}
+
+	cancelado, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := storage.GetByUserID(cancelado, "ana")
+	require.ErrorIs(t, err, context.Canceled)
+
+	// Each deja de llamar a fn en cuanto se cancela, p. ej. si el cliente cortó la descarga
+	recorrido, cancel := context.WithCancel(ctx)
+	defer cancel()
+	vistas := 0
+	err = storage.Each(recorrido, "", "", func(*Sale) error {
+		vistas++
+		if vistas == 10 {
+			cancel()
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 10, vistas)
+
+	vencido, cancel := context.WithTimeout(ctx, -time.Second)
+	defer cancel()
+	_, err = storage.TopCustomers(vencido, time.Time{}, time.Time{}, RankByAmount, 10)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestLocalStorage_SetIfVersion(t *testing.T) {
+	storage := NewLocalStorage()
+	require.NoError(t, storage.Set(ctx, &Sale{ID: "a", UserID: "ana", Amount: 10, Status: "pending", Version: 1}))
+
+	// dos aprobadores leen la misma versión
+	first, err := storage.GetForUpdate(ctx, "a")
+	require.NoError(t, err)
+	second, err := storage.GetForUpdate(ctx, "a")
+	require.NoError(t, err)
+
+	first.Status, first.Version = "approved", 2
+	require.NoError(t, storage.SetIfVersion(ctx, first, 1))
+
+	// el segundo no pisa la decisión del primero
+	second.Status, second.Version = "rejected", 2
+	require.ErrorIs(t, storage.SetIfVersion(ctx, second, 1), ErrVersionConflict)
+
+	stored, err := storage.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, "approved", stored.Status)
+	meta, err := storage.Metadata(ctx, "ana", "")
+	require.NoError(t, err)
+	require.Equal(t, 1, meta.Approved)
+	require.Equal(t, 0, meta.Rejected)
+
+	require.ErrorIs(t, storage.SetIfVersion(ctx, &Sale{ID: "b", Version: 1}, 0), ErrNotFound)
+}
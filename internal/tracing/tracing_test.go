@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetup_ExporterFile(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := Setup(ExporterFile, path, "parte3-test", 1)
+	require.NoError(t, err)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "padre")
+	_, child := otel.Tracer("test").Start(ctx, "hijo")
+	child.SetAttributes(KeySaleID.String("venta-1"))
+	child.End()
+	parent.End()
+
+	// Shutdown escribe lo que quedaba en el batch
+	require.NoError(t, shutdown(context.Background()))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	type span struct {
+		Name        string
+		SpanContext struct{ TraceID string }
+		Parent      struct{ SpanID string }
+		Attributes  []struct{ Key string }
+		Resource    []struct {
+			Key   string
+			Value struct{ Value string }
+		}
+	}
+	var spans []span
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var s span
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &s))
+		spans = append(spans, s)
+	}
+	require.Len(t, spans, 2)
+	require.Equal(t, "hijo", spans[0].Name)
+	require.Equal(t, "padre", spans[1].Name)
+	require.Equal(t, spans[1].SpanContext.TraceID, spans[0].SpanContext.TraceID)
+	require.Equal(t, "sale.id", spans[0].Attributes[0].Key)
+	require.Contains(t, spans[0].Resource, struct {
+		Key   string
+		Value struct{ Value string }
+	}{Key: "service.name", Value: struct{ Value string }{"parte3-test"}})
+}
+
+func TestEnd_RegistraElError(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	_, ok := tracer.Start(context.Background(), "ok")
+	var sinError error
+	End(ok, &sinError)
+
+	_, mal := tracer.Start(context.Background(), "mal")
+	err := errors.New("sale not found")
+	End(mal, &err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+	require.Equal(t, codes.Error, spans[1].Status().Code)
+	require.Equal(t, "sale not found", spans[1].Status().Description)
+	require.Len(t, spans[1].Events(), 1) // el evento exception
+}
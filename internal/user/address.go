@@ -0,0 +1,415 @@
+package user
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Etiquetas de las direcciones de un usuario, cada una puede usarse una vez.
+const (
+	AddressHome     = "home" // por defecto
+	AddressBilling  = "billing"
+	AddressShipping = "shipping"
+)
+
+// ErrInvalidAddress is returned when an address does not pass validation.
+// The returned error is an *AddressError naming the field.
+var ErrInvalidAddress = errors.New("invalid address")
+
+// AddressError tells which field of an address is invalid and why.
+type AddressError struct {
+	Field  string
+	Reason string
+}
+
+func (e *AddressError) Error() string {
+	return fmt.Sprintf("invalid address: %s %s", e.Field, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrInvalidAddress) true.
+func (e *AddressError) Is(target error) bool {
+	return target == ErrInvalidAddress
+}
+
+// Address is a postal address of a user. Country is the ISO 3166-1 alpha-2
+// code; when it is one of the countries in countryRules the postal code
+// and province are validated and normalized with that country's rules.
+//
+// For compatibility with the old free-form field an address can also be
+// sent as a single string ("Av. Corrientes 1234, CABA, C1043AAZ, Argentina"),
+// see ParseAddress.
+type Address struct {
+	Label      string `json:"label" xml:"label"`
+	Street     string `json:"street" xml:"street"`
+	Number     string `json:"number,omitempty" xml:"number,omitempty"`
+	City       string `json:"city,omitempty" xml:"city,omitempty"`
+	Province   string `json:"province,omitempty" xml:"province,omitempty"` // provincia o estado
+	PostalCode string `json:"postal_code,omitempty" xml:"postal_code,omitempty"`
+	Country    string `json:"country,omitempty" xml:"country,omitempty"`
+}
+
+// String formats the address in one line, the inverse of ParseAddress.
+func (a Address) String() string {
+	street := strings.TrimSpace(a.Street + " " + a.Number)
+	var parts []string
+	for _, p := range []string{street, a.City, a.Province, a.PostalCode, a.Country} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// UnmarshalJSON accepts an object or a legacy string.
+func (a *Address) UnmarshalJSON(data []byte) error {
+	var legacy string
+	if err := json.Unmarshal(data, &legacy); err == nil {
+		*a = ParseAddress(legacy)
+		return nil
+	}
+	type plain Address // sin métodos, evita la recursión
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+// UnmarshalXML accepts child elements or, for legacy clients, only text:
+// <address>Calle 1</address>.
+func (a *Address) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type plain Address // sin métodos, evita la recursión
+	var v struct {
+		Text string `xml:",chardata"`
+		plain
+	}
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	if v.plain == (plain{}) {
+		*a = ParseAddress(v.Text)
+		return nil
+	}
+	*a = Address(v.plain)
+	return nil
+}
+
+// UnmarshalText parses a legacy string, used by the CSV decoder.
+func (a *Address) UnmarshalText(text []byte) error {
+	*a = ParseAddress(string(text))
+	return nil
+}
+
+var (
+	streetNumber = regexp.MustCompile(`(?i)^(.*\S)\s+(\d+[a-z]?|s/n)$`)
+	spaces       = regexp.MustCompile(`\s+`)
+)
+
+// ParseAddress parses the legacy one-line format
+// "street number, city, province, postal code, country", where everything
+// after the street is optional. It never fails: what cannot be recognized
+// stays in Street, and the result is checked by NormalizeAddress as usual.
+func ParseAddress(s string) Address {
+	var parts []string
+	for _, p := range strings.Split(s, ",") {
+		if p = collapse(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return Address{}
+	}
+
+	var a Address
+	a.Street = parts[0]
+	if m := streetNumber.FindStringSubmatch(parts[0]); m != nil {
+		a.Street, a.Number = m[1], m[2]
+	}
+	rest := parts[1:]
+
+	if len(rest) > 0 {
+		if code, ok := countryCode(rest[len(rest)-1]); ok {
+			a.Country = code
+			rest = rest[:len(rest)-1]
+		}
+	}
+	for _, p := range rest {
+		switch {
+		case a.PostalCode == "" && looksLikePostalCode(p):
+			a.PostalCode = p
+		case a.City == "":
+			a.City = p
+		case a.Province == "":
+			a.Province = p
+		default:
+			a.Street += ", " + p
+		}
+	}
+	return a
+}
+
+// looksLikePostalCode reports whether s is a single token with digits.
+func looksLikePostalCode(s string) bool {
+	return len(s) <= 10 && !strings.Contains(s, " ") && strings.ContainsAny(s, "0123456789")
+}
+
+// NormalizeAddress trims and canonicalizes every field, fills the default
+// label and validates the result with the rules of its country.
+// Returns an *AddressError if it is not valid.
+func NormalizeAddress(a Address) (Address, error) {
+	a.Label = strings.ToLower(collapse(a.Label))
+	a.Street = collapse(a.Street)
+	a.Number = collapse(a.Number)
+	a.City = collapse(a.City)
+	a.Province = collapse(a.Province)
+	a.PostalCode = strings.ToUpper(strings.ReplaceAll(a.PostalCode, " ", ""))
+	a.Country = collapse(a.Country)
+
+	if a.Label == "" {
+		a.Label = AddressHome
+	}
+	if a.Label != AddressHome && a.Label != AddressBilling && a.Label != AddressShipping {
+		return a, &AddressError{Field: "label", Reason: "must be home, billing or shipping"}
+	}
+	if a.Street == "" {
+		return a, &AddressError{Field: "street", Reason: "is required"}
+	}
+	if a.Country == "" {
+		return a, nil // direcciones viejas sin país: solo se exige la calle
+	}
+
+	code, ok := countryCode(a.Country)
+	if !ok {
+		if len(a.Country) != 2 {
+			return a, &AddressError{Field: "country", Reason: "must be an ISO 3166-1 alpha-2 code"}
+		}
+		code = strings.ToUpper(a.Country)
+	}
+	a.Country = code
+
+	rule, ok := countryRules[code]
+	if !ok {
+		return a, nil
+	}
+	if a.City == "" {
+		return a, &AddressError{Field: "city", Reason: "is required"}
+	}
+	if a.Province != "" && rule.provinces != nil {
+		canonical, ok := rule.provinces[fold(a.Province)]
+		if !ok {
+			return a, &AddressError{Field: "province", Reason: "is not a valid province or state of " + code}
+		}
+		a.Province = canonical
+	}
+	if a.Province == "" && rule.requireProvince {
+		return a, &AddressError{Field: "province", Reason: "is required"}
+	}
+	if a.PostalCode != "" {
+		if !rule.postalCode.MatchString(a.PostalCode) {
+			return a, &AddressError{Field: "postal_code", Reason: "must look like " + rule.postalExample}
+		}
+		if rule.normalizePostalCode != nil {
+			a.PostalCode = rule.normalizePostalCode(a.PostalCode)
+		}
+		if rule.check != nil {
+			if err := rule.check(a); err != nil {
+				return a, err
+			}
+		}
+	}
+	return a, nil
+}
+
+// NormalizeAddresses normalizes every address of a user. The list must not
+// be empty and each label can be used once.
+// Field names in the returned *AddressError are prefixed with the position,
+// e.g. addresses[1].postal_code.
+func NormalizeAddresses(list []Address) ([]Address, error) {
+	if len(list) == 0 {
+		return nil, &AddressError{Field: "addresses", Reason: "must have at least one address"}
+	}
+
+	out := make([]Address, len(list))
+	labels := map[string]bool{}
+	for i, a := range list {
+		n, err := NormalizeAddress(a)
+		if err != nil {
+			var ae *AddressError
+			if errors.As(err, &ae) {
+				return nil, &AddressError{Field: fmt.Sprintf("addresses[%d].%s", i, ae.Field), Reason: ae.Reason}
+			}
+			return nil, err
+		}
+		if labels[n.Label] {
+			return nil, &AddressError{Field: fmt.Sprintf("addresses[%d].label", i), Reason: "is repeated"}
+		}
+		labels[n.Label] = true
+		out[i] = n
+	}
+	return out, nil
+}
+
+// SetAddress adds a to list, replacing the address with the same label.
+func SetAddress(list []Address, a Address) []Address {
+	a.Label = strings.ToLower(strings.TrimSpace(a.Label))
+	if a.Label == "" {
+		a.Label = AddressHome
+	}
+	out := make([]Address, 0, len(list)+1)
+	replaced := false
+	for _, existing := range list {
+		if existing.Label == a.Label {
+			out = append(out, a)
+			replaced = true
+			continue
+		}
+		out = append(out, existing)
+	}
+	if !replaced {
+		out = append(out, a)
+	}
+	return out
+}
+
+func collapse(s string) string {
+	return strings.TrimSpace(spaces.ReplaceAllString(s, " "))
+}
+
+// fold lowers s and removes the accents, to compare names.
+var accents = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n", "ã", "a", "õ", "o", "ç", "c", "â", "a", "ê", "e", "ô", "o")
+
+func fold(s string) string {
+	return accents.Replace(strings.ToLower(collapse(s)))
+}
+
+// countryNames maps country names and codes to the ISO code.
+var countryNames = map[string]string{
+	"ar": "AR", "argentina": "AR",
+	"uy": "UY", "uruguay": "UY",
+	"cl": "CL", "chile": "CL",
+	"br": "BR", "brasil": "BR", "brazil": "BR",
+	"mx": "MX", "mexico": "MX",
+	"es": "ES", "espana": "ES", "spain": "ES",
+	"us": "US", "usa": "US", "estados unidos": "US", "united states": "US",
+}
+
+func countryCode(s string) (string, bool) {
+	code, ok := countryNames[fold(s)]
+	return code, ok
+}
+
+// countryRule is how the addresses of a country are validated.
+type countryRule struct {
+	postalCode          *regexp.Regexp
+	postalExample       string
+	normalizePostalCode func(string) string
+	provinces           map[string]string // nombre sin acentos en minúsculas -> nombre canónico; nil acepta cualquiera
+	requireProvince     bool
+	check               func(Address) error
+}
+
+// provinceIndex builds the lookup of provinces from their canonical names
+// and aliases (canonical -> aliases).
+func provinceIndex(names map[string][]string) map[string]string {
+	index := map[string]string{}
+	for canonical, aliases := range names {
+		index[fold(canonical)] = canonical
+		for _, alias := range aliases {
+			index[fold(alias)] = canonical
+		}
+	}
+	return index
+}
+
+// arCPALetters is the province of each initial letter of the Argentinian
+// CPA (Código Postal Argentino).
+var arCPALetters = map[byte]string{
+	'A': "Salta", 'B': "Buenos Aires", 'C': "Ciudad Autónoma de Buenos Aires",
+	'D': "San Luis", 'E': "Entre Ríos", 'F': "La Rioja", 'G': "Santiago del Estero",
+	'H': "Chaco", 'J': "San Juan", 'K': "Catamarca", 'L': "La Pampa", 'M': "Mendoza",
+	'N': "Misiones", 'P': "Formosa", 'Q': "Neuquén", 'R': "Río Negro", 'S': "Santa Fe",
+	'T': "Tucumán", 'U': "Chubut", 'V': "Tierra del Fuego", 'W': "Corrientes",
+	'X': "Córdoba", 'Y': "Jujuy", 'Z': "Santa Cruz",
+}
+
+func arProvinces() map[string]string {
+	names := map[string][]string{}
+	for _, name := range arCPALetters {
+		names[name] = nil
+	}
+	names["Ciudad Autónoma de Buenos Aires"] = append(names["Ciudad Autónoma de Buenos Aires"], "CABA", "Capital Federal")
+	names["Buenos Aires"] = append(names["Buenos Aires"], "Provincia de Buenos Aires", "PBA")
+	names["Tierra del Fuego"] = append(names["Tierra del Fuego"], "Tierra del Fuego, Antártida e Islas del Atlántico Sur")
+	return provinceIndex(names)
+}
+
+// codes builds a lookup where every code is its own canonical name.
+func codes(list ...string) map[string]string {
+	index := map[string]string{}
+	for _, c := range list {
+		index[strings.ToLower(c)] = c
+	}
+	return index
+}
+
+var countryRules = map[string]countryRule{
+	"AR": {
+		// CPA (C1425ABC) o el código viejo de 4 dígitos
+		postalCode:    regexp.MustCompile(`^([A-Z]\d{4}[A-Z]{3}|\d{4})$`),
+		postalExample: "C1425ABC or 1425",
+		provinces:     arProvinces(),
+		check: func(a Address) error {
+			if len(a.PostalCode) != 8 || a.Province == "" {
+				return nil
+			}
+			if want := arCPALetters[a.PostalCode[0]]; want != a.Province {
+				return &AddressError{Field: "postal_code", Reason: "does not belong to " + a.Province}
+			}
+			return nil
+		},
+	},
+	"UY": {
+		postalCode:    regexp.MustCompile(`^\d{5}$`),
+		postalExample: "11000",
+		provinces: provinceIndex(map[string][]string{
+			"Artigas": nil, "Canelones": nil, "Cerro Largo": nil, "Colonia": nil,
+			"Durazno": nil, "Flores": nil, "Florida": nil, "Lavalleja": nil,
+			"Maldonado": nil, "Montevideo": nil, "Paysandú": nil, "Río Negro": nil,
+			"Rivera": nil, "Rocha": nil, "Salto": nil, "San José": nil,
+			"Soriano": nil, "Tacuarembó": nil, "Treinta y Tres": nil,
+		}),
+	},
+	"CL": {
+		postalCode:    regexp.MustCompile(`^\d{7}$`),
+		postalExample: "8320000",
+	},
+	"BR": {
+		postalCode:    regexp.MustCompile(`^\d{5}-?\d{3}$`),
+		postalExample: "01310-100",
+		normalizePostalCode: func(s string) string {
+			s = strings.ReplaceAll(s, "-", "")
+			return s[:5] + "-" + s[5:]
+		},
+		provinces: codes("AC", "AL", "AP", "AM", "BA", "CE", "DF", "ES", "GO", "MA", "MT", "MS", "MG", "PA",
+			"PB", "PR", "PE", "PI", "RJ", "RN", "RS", "RO", "RR", "SC", "SP", "SE", "TO"),
+		requireProvince: true,
+	},
+	"MX": {
+		postalCode:    regexp.MustCompile(`^\d{5}$`),
+		postalExample: "06600",
+	},
+	"ES": {
+		// los dos primeros dígitos son la provincia (01 a 52)
+		postalCode:    regexp.MustCompile(`^(0[1-9]|[1-4]\d|5[0-2])\d{3}$`),
+		postalExample: "28013",
+	},
+	"US": {
+		postalCode:    regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+		postalExample: "94105 or 94105-1234",
+		provinces: codes("AL", "AK", "AZ", "AR", "CA", "CO", "CT", "DE", "DC", "FL", "GA", "HI", "ID", "IL",
+			"IN", "IA", "KS", "KY", "LA", "ME", "MD", "MA", "MI", "MN", "MS", "MO", "MT", "NE", "NV", "NH",
+			"NJ", "NM", "NY", "NC", "ND", "OH", "OK", "OR", "PA", "RI", "SC", "SD", "TN", "TX", "UT", "VT",
+			"VA", "WA", "WV", "WI", "WY"),
+		requireProvince: true,
+	},
+}
@@ -0,0 +1,135 @@
+package user
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddress(t *testing.T) {
+	cases := map[string]Address{
+		"Calle 1":         {Street: "Calle", Number: "1"},
+		"25 de mayo 1234": {Street: "25 de mayo", Number: "1234"},
+		"Sin numero":      {Street: "Sin numero"},
+		"Av. Corrientes  1234 , CABA, C1043AAZ, Argentina": {
+			Street: "Av. Corrientes", Number: "1234", City: "CABA", PostalCode: "C1043AAZ", Country: "AR",
+		},
+		"Rua Augusta 500, São Paulo, SP, 01305-000, Brasil": {
+			Street: "Rua Augusta", Number: "500", City: "São Paulo", Province: "SP", PostalCode: "01305-000", Country: "BR",
+		},
+	}
+	for in, want := range cases {
+		require.Equal(t, want, ParseAddress(in), in)
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	cases := []struct {
+		name  string
+		in    Address
+		want  Address
+		field string
+	}{
+		{
+			name: "argentina con CPA",
+			in:   Address{Street: " Av.  Santa Fe ", Number: "1234", City: "Rosario", Province: "santa fe", PostalCode: "s2000 abc", Country: "argentina"},
+			want: Address{Label: "home", Street: "Av. Santa Fe", Number: "1234", City: "Rosario", Province: "Santa Fe", PostalCode: "S2000ABC", Country: "AR"},
+		},
+		{
+			name: "alias de provincia",
+			in:   Address{Label: "Billing", Street: "Florida", Number: "100", City: "Buenos Aires", Province: "CABA", PostalCode: "C1005AAA", Country: "AR"},
+			want: Address{Label: "billing", Street: "Florida", Number: "100", City: "Buenos Aires", Province: "Ciudad Autónoma de Buenos Aires", PostalCode: "C1005AAA", Country: "AR"},
+		},
+		{
+			name:  "CPA de otra provincia",
+			in:    Address{Street: "Florida", City: "Córdoba", Province: "Córdoba", PostalCode: "C1005AAA", Country: "AR"},
+			field: "postal_code",
+		},
+		{
+			name:  "provincia inexistente",
+			in:    Address{Street: "Florida", City: "X", Province: "Narnia", Country: "AR"},
+			field: "province",
+		},
+		{
+			name: "brasil normaliza el CEP",
+			in:   Address{Street: "Rua Augusta", City: "São Paulo", Province: "sp", PostalCode: "01305000", Country: "BR"},
+			want: Address{Label: "home", Street: "Rua Augusta", City: "São Paulo", Province: "SP", PostalCode: "01305-000", Country: "BR"},
+		},
+		{
+			name:  "estados unidos exige estado",
+			in:    Address{Street: "Market St", City: "San Francisco", PostalCode: "94105", Country: "US"},
+			field: "province",
+		},
+		{
+			name:  "código postal español inválido",
+			in:    Address{Street: "Gran Vía", City: "Madrid", PostalCode: "99999", Country: "ES"},
+			field: "postal_code",
+		},
+		{
+			name:  "ciudad requerida con país",
+			in:    Address{Street: "18 de Julio", Country: "UY"},
+			field: "city",
+		},
+		{
+			name: "país sin reglas",
+			in:   Address{Street: "Rue de Rivoli", Country: "fr"},
+			want: Address{Label: "home", Street: "Rue de Rivoli", Country: "FR"},
+		},
+		{
+			name:  "país inválido",
+			in:    Address{Street: "Calle", Country: "Narnia"},
+			field: "country",
+		},
+		{
+			name:  "etiqueta inválida",
+			in:    Address{Label: "oficina", Street: "Calle"},
+			field: "label",
+		},
+		{
+			name:  "calle requerida",
+			in:    Address{City: "Montevideo"},
+			field: "street",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := NormalizeAddress(c.in)
+			if c.field == "" {
+				require.NoError(t, err)
+				require.Equal(t, c.want, got)
+				return
+			}
+			var ae *AddressError
+			require.ErrorAs(t, err, &ae)
+			require.Equal(t, c.field, ae.Field)
+		})
+	}
+}
+
+func TestNormalizeAddresses_Etiquetas(t *testing.T) {
+	_, err := NormalizeAddresses(nil)
+	require.ErrorIs(t, err, ErrInvalidAddress)
+
+	_, err = NormalizeAddresses([]Address{{Street: "Calle 1"}, {Label: "HOME", Street: "Calle 2"}})
+	var ae *AddressError
+	require.ErrorAs(t, err, &ae)
+	require.Equal(t, "addresses[1].label", ae.Field)
+
+	list := SetAddress([]Address{{Label: "home", Street: "Calle 1"}}, Address{Label: "shipping", Street: "Calle 2"})
+	list = SetAddress(list, Address{Street: "Calle 3"})
+	require.Equal(t, []Address{{Label: "home", Street: "Calle 3"}, {Label: "shipping", Street: "Calle 2"}}, list)
+}
+
+func TestAddress_Unmarshal(t *testing.T) {
+	var req CreateUserRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"address":"Calle 1","addresses":[{"label":"billing","street":"Calle","number":"2"}]}`), &req))
+	require.Equal(t, &Address{Street: "Calle", Number: "1"}, req.Address)
+	require.Equal(t, []Address{{Label: "billing", Street: "Calle", Number: "2"}}, req.Addresses)
+
+	req = CreateUserRequest{}
+	require.NoError(t, xml.Unmarshal([]byte(`<user><address>Calle 1</address><addresses><address><label>billing</label><street>Calle</street></address></addresses></user>`), &req))
+	require.Equal(t, &Address{Street: "Calle", Number: "1"}, req.Address)
+	require.Equal(t, []Address{{Label: "billing", Street: "Calle"}}, req.Addresses)
+}
@@ -6,20 +6,60 @@ import (
 
 // User represents a system user with metadata for auditing and versioning.
 type User struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name" binding:"required,regexp`
-	Address   string    `json:"address" binding:"required"` // Opcional, pero requerido si se proporciona
-	NickName  string    `json:"nickname" binding:"omitempty,regexp`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Version   int       `json:"version"`
-	Estado    bool      `json:"estado"` // Estado del usuario (activo/inactivo)
+	ID        string    `json:"id" xml:"id"`
+	Name      string    `json:"name" xml:"name" binding:"required,regexp"`
+	Addresses []Address `json:"addresses" xml:"addresses>address"` // Al menos una, con etiquetas distintas
+	NickName  string    `json:"nickname" xml:"nickname" binding:"omitempty,regexp"`
+	Email     string    `json:"email,omitempty" xml:"email,omitempty"` // Normalizado en minúsculas, único
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+	Version   int       `json:"version" xml:"version"`
+	Estado    bool      `json:"estado" xml:"estado"` // Estado del usuario (activo/inactivo)
+	Role      string    `json:"role" xml:"role"`     // Rol del usuario (admin, approver, seller, customer)
+
+	// PasswordHash is the argon2id (or legacy bcrypt) hash of the password.
+	// It is never serialized.
+	PasswordHash string `json:"-" xml:"-" codec:"-"`
+}
+
+// CreateUserRequest is the payload accepted to create a User.
+// Address and Addresses can be combined, Address is added first.
+type CreateUserRequest struct {
+	Name      string    `json:"name" xml:"name" binding:"required,regexp"`                  //anotations; si el content type es json, el nombre del campo es name
+	Address   *Address  `json:"address" xml:"address" binding:"required_without=Addresses"` // Objeto o texto viejo
+	Addresses []Address `json:"addresses" xml:"addresses>address"`
+	NickName  string    `json:"nickname" xml:"nickname" binding:"omitempty,regexp"` //solo letras
+	Email     string    `json:"email" xml:"email" binding:"omitempty,email"`
+	Password  string    `json:"password" xml:"password" binding:"omitempty,min=8,max=128"` // Opcional, se puede definir después
 }
 
 // UpdateFields represents the optional fields for updating a User.
 // A nil pointer means “no change” for that field.
+// Addresses replaces every address, Address only the one with its label.
 type UpdateFields struct {
-	Name     *string `json:"name" binding:"required,regexp`      // Solo letras si se proporciona
-	Address  *string `json:"address" binding:"required"`         // Opcional
-	NickName *string `json:"nickname" binding:"omitempty,regexp` // Solo letras si se
+	Name      *string   `json:"name" xml:"name" binding:"omitempty,regexp"`                               // Solo letras si se proporciona
+	Address   *Address  `json:"address" xml:"address" binding:"required_without=Addresses"`               // Reemplaza la de su etiqueta
+	Addresses []Address `json:"addresses" xml:"addresses>address"`                                        // Reemplaza todas
+	NickName  *string   `json:"nickname" xml:"nickname" binding:"omitempty,regexp"`                       // Solo letras si se
+	Role      *string   `json:"role" xml:"role" binding:"omitempty,oneof=admin approver seller customer"` // Solo admins
+	Email     *string   `json:"email" xml:"email" binding:"omitempty,email"`
+}
+
+// ChangePasswordRequest is the payload of PUT /users/:id/password.
+// CurrentPassword is required when users change their own password.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" xml:"current_password"`
+	NewPassword     string `json:"new_password" xml:"new_password" binding:"required,min=8,max=128"`
+}
+
+// ForgotPasswordRequest is the payload of POST /auth/password/forgot.
+type ForgotPasswordRequest struct {
+	Email string `json:"email" xml:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest is the payload of POST /auth/password/reset, it
+// redeems a setup or reset token.
+type ResetPasswordRequest struct {
+	Token    string `json:"token" xml:"token" binding:"required"`
+	Password string `json:"password" xml:"password" binding:"required,min=8,max=128"`
 }
@@ -0,0 +1,60 @@
+package user
+
+import (
+	"parte3/internal/logging"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Campos con datos personales, con su política en logging.SetPolicies.
+const (
+	LogFieldName      = "user.name"
+	LogFieldNickName  = "user.nickname"
+	LogFieldEmail     = "user.email"
+	LogFieldAddresses = "user.addresses"
+)
+
+// MarshalLogObject logs the user with its personal data redacted following
+// the logging policies: the IDs, role, status and version are always
+// logged, the password hash never.
+func (u User) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("id", u.ID)
+	logging.AddPII(enc, LogFieldName, "name", u.Name)
+	logging.AddPII(enc, LogFieldNickName, "nickname", u.NickName)
+	logging.AddPII(enc, LogFieldEmail, "email", u.Email)
+	if logging.PolicyOf(LogFieldAddresses) != logging.PolicyHide {
+		if err := enc.AddArray("addresses", addressesLog(u.Addresses)); err != nil {
+			return err
+		}
+	}
+	enc.AddString("role", u.Role)
+	enc.AddBool("estado", u.Estado)
+	enc.AddInt("version", u.Version)
+	enc.AddTime("created_at", u.CreatedAt)
+	enc.AddTime("updated_at", u.UpdatedAt)
+	return nil
+}
+
+// MarshalLogObject logs the label and country of the address, the rest
+// follows the policy of LogFieldAddresses.
+func (a Address) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("label", a.Label)
+	logging.AddPII(enc, LogFieldAddresses, "street", a.Street)
+	logging.AddPII(enc, LogFieldAddresses, "number", a.Number)
+	logging.AddPII(enc, LogFieldAddresses, "city", a.City)
+	logging.AddPII(enc, LogFieldAddresses, "province", a.Province)
+	logging.AddPII(enc, LogFieldAddresses, "postal_code", a.PostalCode)
+	enc.AddString("country", a.Country)
+	return nil
+}
+
+type addressesLog []Address
+
+func (as addressesLog) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, a := range as {
+		if err := enc.AppendObject(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
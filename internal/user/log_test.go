@@ -0,0 +1,49 @@
+package user
+
+import (
+	"testing"
+
+	"parte3/internal/logging"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestUser_MarshalLogObject(t *testing.T) {
+	t.Cleanup(func() { logging.SetPolicies(nil) })
+	u := User{
+		ID:           "u-1",
+		Name:         "Juana Perez",
+		Email:        "juana@example.com",
+		Addresses:    []Address{{Label: AddressHome, Street: "Corrientes", Number: "1234", City: "CABA", Country: "AR"}},
+		Version:      3,
+		Role:         "seller",
+		PasswordHash: "$argon2id$secreto",
+	}
+	marshal := func() map[string]any {
+		enc := zapcore.NewMapObjectEncoder()
+		require.NoError(t, u.MarshalLogObject(enc))
+		return enc.Fields
+	}
+
+	fields := marshal()
+	require.Equal(t, "u-1", fields["id"])
+	require.Equal(t, 3, fields["version"])
+	require.Equal(t, "J***", fields["name"])
+	require.Equal(t, "j***", fields["email"])
+	address := fields["addresses"].([]any)[0].(map[string]any)
+	require.Equal(t, AddressHome, address["label"])
+	require.Equal(t, "C***", address["street"])
+	require.Equal(t, "AR", address["country"])
+	for _, v := range fields {
+		require.NotEqual(t, u.PasswordHash, v)
+	}
+
+	logging.SetPolicies(map[string]logging.Policy{
+		LogFieldEmail:     logging.PolicyShow,
+		LogFieldAddresses: logging.PolicyHide,
+	})
+	fields = marshal()
+	require.Equal(t, "juana@example.com", fields["email"])
+	require.NotContains(t, fields, "addresses")
+}
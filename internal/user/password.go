@@ -0,0 +1,181 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Límites de las contraseñas, los mismos que validan los bindings.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+)
+
+// Parámetros de argon2id (recomendación de OWASP).
+const (
+	argonTime    = 1
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+// Propósitos de los tokens de contraseña y su vigencia.
+const (
+	PasswordSetup = "setup" // el usuario todavía no tiene contraseña
+	PasswordReset = "reset" // el usuario olvidó su contraseña
+
+	PasswordSetupTTL = 72 * time.Hour
+	PasswordResetTTL = time.Hour
+)
+
+// ErrWeakPassword is returned when a password does not meet the length limits.
+var ErrWeakPassword = fmt.Errorf("password must have between %d and %d characters", MinPasswordLength, MaxPasswordLength)
+
+// ErrWrongPassword is returned when the current password does not match.
+var ErrWrongPassword = errors.New("current password does not match")
+
+// ErrInvalidPasswordToken is returned for unknown, expired or already used tokens.
+var ErrInvalidPasswordToken = errors.New("password token is invalid, expired or already used")
+
+// ErrNoEmail is returned when a token has to be sent to a user without email.
+var ErrNoEmail = errors.New("user has no email to send the password token to")
+
+// HashPassword hashes password with argon2id and returns it in the PHC
+// string format, e.g. $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>.
+// Returns ErrWeakPassword if the password is too short or too long.
+func HashPassword(password string) (string, error) {
+	if n := len([]rune(password)); n < MinPasswordLength || n > MaxPasswordLength {
+		return "", ErrWeakPassword
+	}
+
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// checkPassword reports whether password matches hash. Besides argon2id it
+// accepts bcrypt hashes, e.g. imported from another system.
+func checkPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+
+	// $argon2id$v=19$m=65536,t=1,p=4$salt$hash
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// dummyHash is compared against when the login does not exist, so unknown
+// and known emails take the same time to answer.
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword("not-a-real-password")
+	return hash
+})
+
+// passwordToken is a pending setup or reset of a user's password.
+type passwordToken struct {
+	userID    string
+	purpose   string
+	expiresAt time.Time
+}
+
+// passwordTokens keeps the pending password tokens by the SHA-256 of the
+// token, only the user gets the token itself. A user has at most one
+// pending token: issuing a new one invalidates the previous.
+type passwordTokens struct {
+	mu     sync.Mutex
+	byHash map[string]passwordToken
+	byUser map[string]string // userID -> hash
+}
+
+func newPasswordTokens() *passwordTokens {
+	return &passwordTokens{
+		byHash: map[string]passwordToken{},
+		byUser: map[string]string{},
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issue creates a new token for the user valid for ttl.
+func (t *passwordTokens) issue(userID, purpose string, ttl time.Duration) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	expiresAt := time.Now().Add(ttl)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if prev, ok := t.byUser[userID]; ok {
+		delete(t.byHash, prev)
+	}
+	hash := hashToken(token)
+	t.byHash[hash] = passwordToken{userID: userID, purpose: purpose, expiresAt: expiresAt}
+	t.byUser[userID] = hash
+	return token, expiresAt, nil
+}
+
+// consume redeems token, it can only be used once.
+// Returns ErrInvalidPasswordToken if it is unknown or expired.
+func (t *passwordTokens) consume(token string) (passwordToken, error) {
+	hash := hashToken(token)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pt, ok := t.byHash[hash]
+	if !ok {
+		return passwordToken{}, ErrInvalidPasswordToken
+	}
+	delete(t.byHash, hash)
+	delete(t.byUser, pt.userID)
+	if time.Now().After(pt.expiresAt) {
+		return passwordToken{}, ErrInvalidPasswordToken
+	}
+	return pt, nil
+}
@@ -0,0 +1,55 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("contraseña-segura")
+	require.NoError(t, err)
+	require.Contains(t, hash, "$argon2id$")
+	require.True(t, checkPassword(hash, "contraseña-segura"))
+	require.False(t, checkPassword(hash, "otra-contraseña"))
+
+	// dos hashes de la misma contraseña usan sal distinta
+	other, err := HashPassword("contraseña-segura")
+	require.NoError(t, err)
+	require.NotEqual(t, hash, other)
+
+	_, err = HashPassword("corta")
+	require.ErrorIs(t, err, ErrWeakPassword)
+
+	// los hashes bcrypt importados siguen funcionando
+	legacy, err := bcrypt.GenerateFromPassword([]byte("clave-vieja"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.True(t, checkPassword(string(legacy), "clave-vieja"))
+	require.False(t, checkPassword("basura", "clave-vieja"))
+}
+
+func TestPasswordTokens_UnSoloUso(t *testing.T) {
+	tokens := newPasswordTokens()
+
+	first, _, err := tokens.issue("u1", PasswordReset, time.Hour)
+	require.NoError(t, err)
+	second, _, err := tokens.issue("u1", PasswordReset, time.Hour)
+	require.NoError(t, err)
+
+	// emitir un token nuevo invalida el anterior
+	_, err = tokens.consume(first)
+	require.ErrorIs(t, err, ErrInvalidPasswordToken)
+
+	pt, err := tokens.consume(second)
+	require.NoError(t, err)
+	require.Equal(t, "u1", pt.userID)
+	_, err = tokens.consume(second)
+	require.ErrorIs(t, err, ErrInvalidPasswordToken)
+
+	expired, _, err := tokens.issue("u2", PasswordSetup, -time.Second)
+	require.NoError(t, err)
+	_, err = tokens.consume(expired)
+	require.ErrorIs(t, err, ErrInvalidPasswordToken)
+}
@@ -1,21 +1,48 @@
 package user
 
 import (
+	"context"
+	"errors"
+	"parte3/internal/auth"
+	"parte3/internal/health"
+	"parte3/internal/logging"
+	"parte3/internal/metrics"
+	"parte3/internal/notify"
+	"parte3/internal/tracing"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+// tracer creates the spans of the users service and storage.
+var tracer = otel.Tracer("parte3/internal/user")
+
+// ErrAlreadyActive is returned when restoring a user that was not deleted.
+var ErrAlreadyActive = errors.New("user is already active")
+
+// ErrBatchAborted is returned for the items of an all-or-nothing batch that
+// were not created because another item failed.
+var ErrBatchAborted = errors.New("batch aborted because another item failed")
+
 type Getter interface {
-	Get(id string) (*User, error)
+	Get(ctx context.Context, id string) (*User, error)
 }
 
+var _ auth.PasswordVerifier = (*Service)(nil)
+
 // Service provides high-level user management operations on a LocalStorage backend.
 type Service struct {
 	// storage is the underlying persistence for User entities.
-	storage *LocalStorage
-	logger  *zap.Logger
+	storage  *LocalStorage
+	logger   *zap.Logger
+	notifier notify.Notifier
+	tokens   *passwordTokens
+	metrics  metrics.Recorder
 }
 
 func NewService(storage *LocalStorage, logger *zap.Logger) *Service {
@@ -25,61 +52,170 @@ func NewService(storage *LocalStorage, logger *zap.Logger) *Service {
 	}
 
 	return &Service{
-		storage: storage,
-		logger:  logger,
+		storage:  storage,
+		logger:   logger,
+		notifier: notify.NewLogNotifier(logger),
+		tokens:   newPasswordTokens(),
+		metrics:  metrics.Nop{},
 	}
 }
 
+// log returns the logger of the request ctx belongs to, or the service's.
+func (s *Service) log(ctx context.Context) *zap.Logger {
+	return logging.FromContext(ctx, s.logger)
+}
+
+// SetNotifier changes how password tokens are delivered, by default they
+// are dropped and only logged without the token.
+func (s *Service) SetNotifier(n notify.Notifier) {
+	s.notifier = n
+}
+
+// SetMetrics sets where the users created and deleted are counted.
+func (s *Service) SetMetrics(m metrics.Recorder) {
+	s.metrics = m
+}
+
+// RegisterChecks registers the health checks of the users: the storage and,
+// if it can be checked, the notifier that delivers the password tokens.
+func (s *Service) RegisterChecks(r *health.Registry) {
+	r.Register("users.storage", health.Liveness, s.storage.Check)
+	if c, ok := s.notifier.(health.Checker); ok {
+		r.Register("users.notifier", health.Readiness, c.Check)
+	}
+}
+
+// NormalizeEmail returns the canonical form used to store and compare emails.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 // Create adds a brand-new user to the system.
 // It sets CreatedAt and UpdatedAt to the current time and initializes Version to 1.
-// Returns ErrEmptyID if user.ID is empty.
-func (s *Service) Create(user *User) error {
+// Returns an *AddressError (ErrInvalidAddress) if an address is not valid
+// and a *ConflictError (ErrConflict) if another active user has the same
+// email or nickname.
+func (s *Service) Create(ctx context.Context, user *User) (err error) {
+	ctx, span := tracer.Start(ctx, "user.Service.Create")
+	defer tracing.End(span, &err)
+
+	if err := s.create(ctx, user); err != nil {
+		return err
+	}
+	span.SetAttributes(tracing.KeyUserID.String(user.ID))
+	s.metrics.UserCreated()
+	return nil
+}
+
+// create stores a new user without counting it, CreateBatch only counts
+// the users that survive the batch.
+func (s *Service) create(ctx context.Context, user *User) error {
 	user.ID = uuid.NewString()
 	now := time.Now()
 	user.CreatedAt = now
 	user.UpdatedAt = now
 	user.Version = 1
 	user.Estado = true
+	if user.Role == "" {
+		user.Role = auth.RoleCustomer
+	}
+	user.Email = NormalizeEmail(user.Email)
+	addresses, err := NormalizeAddresses(user.Addresses)
+	if err != nil {
+		return err
+	}
+	user.Addresses = addresses
 
-	if err := s.storage.Set(user); err != nil {
-		s.logger.Error("failed to set user", zap.Error(err), zap.Any("user", user))
+	if err := s.storage.Set(ctx, user); err != nil {
+		if errors.Is(err, ErrConflict) {
+			s.log(ctx).Warn("user conflicts with an active user", zap.Error(err))
+			return err
+		}
+		s.log(ctx).Error("failed to set user", zap.Error(err), zap.Object("user", user))
 		return err
 	}
 
-	return s.storage.Set(user)
+	return s.storage.Set(ctx, user)
 }
 
 // Get retrieves a user by its ID.
 // Returns ErrNotFound if no user exists with the given ID.
-func (s *Service) Get(id string) (*User, error) {
-	return s.storage.Get(id)
+func (s *Service) Get(ctx context.Context, id string) (_ *User, err error) {
+	ctx, span := tracer.Start(ctx, "user.Service.Get", trace.WithAttributes(tracing.KeyUserID.String(id)))
+	defer tracing.End(span, &err)
+
+	return s.storage.Get(ctx, id)
 }
 
 // Update modifies an existing user's data.
-// It updates Name, Address, NickName, sets UpdatedAt to now and increments Version.
-// Returns ErrNotFound if the user does not exist, or ErrEmptyID if user.ID is empty.
-func (s *Service) Update(id string, user *UpdateFields, user2 User) (*User, error) {
-	existing, err := s.storage.Get(id)
+// It updates Name, Addresses, NickName, sets UpdatedAt to now and increments Version.
+// The actor must be allowed to update the user, to assign roles if
+// user.Role is set and to change the credentials of the user (see
+// auth.AuthorizeCredentials) if user.Email is set; auth.ErrForbidden is
+// returned otherwise.
+// Returns ErrNotFound if the user does not exist, or ErrEmptyID if user.ID is empty,
+// an *AddressError if the resulting addresses are not valid, and a *ConflictError if the new email or nickname belongs to another active user.
+func (s *Service) Update(ctx context.Context, actor *auth.Principal, id string, user *UpdateFields, user2 User) (_ *User, err error) {
+	ctx, span := tracer.Start(ctx, "user.Service.Update", trace.WithAttributes(tracing.KeyUserID.String(id)))
+	defer tracing.End(span, &err)
+
+	if err := auth.AuthorizeOwn(actor, auth.PermUsersUpdateAny, auth.PermUsersUpdateOwn, id); err != nil {
+		return nil, err
+	}
+	if user.Role != nil {
+		if err := auth.Authorize(actor, auth.PermUsersAssignRole); err != nil {
+			return nil, err
+		}
+		if err := auth.ValidRole(*user.Role); err != nil {
+			return nil, err
+		}
+	}
+
+	existing, err := s.storage.Get(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	if user.Email != nil {
+		// con el email se puede resetear la contraseña
+		if err := auth.AuthorizeCredentials(actor, auth.PermUsersUpdateOwn, id, existing.Role); err != nil {
+			return nil, err
+		}
+		email := NormalizeEmail(*user.Email)
+		user.Email = &email
+	}
+	addresses := existing.Addresses
+	if user.Addresses != nil {
+		addresses = user.Addresses
+	}
+	if user.Address != nil {
+		addresses = SetAddress(addresses, *user.Address)
+	}
+	if addresses, err = NormalizeAddresses(addresses); err != nil {
+		return nil, err
+	}
 	if !user2.Estado {
 		if user.Name != nil {
 			existing.Name = *user.Name
 		}
 
-		if user.Address != nil {
-			existing.Address = *user.Address
-		}
+		existing.Addresses = addresses
 
 		if user.NickName != nil {
 			existing.NickName = *user.NickName
 		}
 
+		if user.Role != nil {
+			existing.Role = *user.Role
+		}
+
+		if user.Email != nil {
+			existing.Email = *user.Email
+		}
+
 		existing.UpdatedAt = time.Now()
 		existing.Version++
 
-		if err := s.storage.Set(existing); err != nil {
+		if err := s.storage.Set(ctx, existing); err != nil {
 			return nil, err
 		}
 	}
@@ -87,10 +223,18 @@ func (s *Service) Update(id string, user *UpdateFields, user2 User) (*User, erro
 }
 
 // Delete removes a user from the system by its ID.
+// Only actors allowed to delete users can do it, auth.ErrForbidden otherwise.
 // Returns ErrNotFound if the user does not exist.
-func (s *Service) Delete(id string) error {
+func (s *Service) Delete(ctx context.Context, actor *auth.Principal, id string) (err error) {
+	ctx, span := tracer.Start(ctx, "user.Service.Delete", trace.WithAttributes(tracing.KeyUserID.String(id)))
+	defer tracing.End(span, &err)
+
+	if err := auth.Authorize(actor, auth.PermUsersDelete); err != nil {
+		return err
+	}
+
 	// Obtener el usuario existente
-	existing, err := s.storage.Get(id)
+	existing, err := s.storage.Get(ctx, id)
 	if err != nil {
 		return err // Retorna ErrNotFound si no existe
 	}
@@ -100,8 +244,247 @@ func (s *Service) Delete(id string) error {
 	existing.UpdatedAt = time.Now() // Actualizar la fecha de modificación
 
 	// Guardar los cambios en el almacenamiento
-	return s.storage.Set(existing)
+	if err := s.storage.Set(ctx, existing); err != nil {
+		return err
+	}
+	s.metrics.UserDeleted()
+	return nil
 }
-func (s *Service) ListActive() ([]*User, error) {
-	return s.storage.ListActive()
+
+// Restore reactivates a logically deleted user.
+// Only actors allowed to restore users can do it, auth.ErrForbidden otherwise.
+// Returns ErrNotFound if the user does not exist and ErrAlreadyActive if it
+// was not deleted, or a *ConflictError if an active user took its email or
+// nickname meanwhile.
+func (s *Service) Restore(ctx context.Context, actor *auth.Principal, id string) (_ *User, err error) {
+	ctx, span := tracer.Start(ctx, "user.Service.Restore", trace.WithAttributes(tracing.KeyUserID.String(id)))
+	defer tracing.End(span, &err)
+
+	if err := auth.Authorize(actor, auth.PermUsersRestore); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.storage.GetAny(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if existing.Estado {
+		return nil, ErrAlreadyActive
+	}
+
+	existing.Estado = true
+	existing.UpdatedAt = time.Now()
+	existing.Version++
+
+	if err := s.storage.Set(ctx, existing); err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+func (s *Service) ListActive(ctx context.Context) (_ []*User, err error) {
+	ctx, span := tracer.Start(ctx, "user.Service.ListActive")
+	defer tracing.End(span, &err)
+
+	return s.storage.ListActive(ctx)
+}
+
+// EachActive walks the active users without building the whole list, see
+// LocalStorage.EachActive.
+func (s *Service) EachActive(ctx context.Context, fn func(*User) error) (err error) {
+	ctx, span := tracer.Start(ctx, "user.Service.EachActive")
+	defer tracing.End(span, &err)
+
+	return s.storage.EachActive(ctx, fn)
+}
+
+// CreateBatch creates every user in users and returns one error per item.
+// When atomic is true either all users are created or none is: the users
+// already stored are removed again and the rest get ErrBatchAborted.
+func (s *Service) CreateBatch(ctx context.Context, users []*User, atomic bool) []error {
+	ctx, span := tracer.Start(ctx, "user.Service.CreateBatch", trace.WithAttributes(attribute.Int("batch.size", len(users))))
+	defer span.End()
+
+	errs := make([]error, len(users))
+	failed := false
+	for i, u := range users {
+		if failed && atomic {
+			errs[i] = ErrBatchAborted
+			continue
+		}
+		if err := s.create(ctx, u); err != nil {
+			errs[i] = err
+			failed = true
+		}
+	}
+	if !failed || !atomic {
+		for _, err := range errs {
+			if err == nil {
+				s.metrics.UserCreated()
+			}
+		}
+		return errs
+	}
+
+	// el rollback se completa aunque el pedido se haya cancelado
+	rollback := context.WithoutCancel(ctx)
+	for i, u := range users {
+		if errs[i] != nil {
+			continue
+		}
+		if err := s.storage.Delete(rollback, u.ID); err != nil {
+			s.log(ctx).Error("failed to rollback user batch", zap.Error(err), zap.String("id", u.ID))
+		}
+		errs[i] = ErrBatchAborted
+	}
+	return errs
+}
+
+// VerifyPassword checks the credentials of the password grant, login is the
+// user's email. It implements auth.PasswordVerifier.
+// Returns auth.ErrInvalidCredentials if the user does not exist, has no
+// password or the password does not match.
+func (s *Service) VerifyPassword(ctx context.Context, login, password string) (_ *auth.Principal, err error) {
+	ctx, span := tracer.Start(ctx, "user.Service.VerifyPassword")
+	defer tracing.End(span, &err)
+
+	u, err := s.storage.GetByEmail(ctx, NormalizeEmail(login))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if u == nil || u.PasswordHash == "" {
+		checkPassword(dummyHash(), password) // mismo tiempo de respuesta
+		return nil, auth.ErrInvalidCredentials
+	}
+	if !checkPassword(u.PasswordHash, password) {
+		s.log(ctx).Warn("wrong password", zap.String("id", u.ID))
+		return nil, auth.ErrInvalidCredentials
+	}
+	return &auth.Principal{Subject: u.ID, Kind: auth.SubjectUser, Role: u.Role}, nil
+}
+
+// ChangePassword sets a new password for the user. Users changing their own
+// password must send the current one if they have it (ErrWrongPassword
+// otherwise); admins may skip it for users whose role is not above theirs.
+func (s *Service) ChangePassword(ctx context.Context, actor *auth.Principal, id string, current, password string) (err error) {
+	ctx, span := tracer.Start(ctx, "user.Service.ChangePassword", trace.WithAttributes(tracing.KeyUserID.String(id)))
+	defer tracing.End(span, &err)
+
+	if err := auth.AuthorizeOwn(actor, auth.PermUsersUpdateAny, auth.PermUsersUpdateOwn, id); err != nil {
+		return err
+	}
+
+	existing, err := s.storage.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := auth.AuthorizeCredentials(actor, auth.PermUsersUpdateOwn, id, existing.Role); err != nil {
+		return err
+	}
+	self := actor.Kind == auth.SubjectUser && actor.Subject == id
+	if self && existing.PasswordHash != "" && !checkPassword(existing.PasswordHash, current) {
+		return ErrWrongPassword
+	}
+	return s.setPassword(ctx, existing, password)
+}
+
+// SendPasswordSetup sends a setup token to a user so they choose their
+// password, e.g. after an admin created the account without one.
+// Returns ErrNoEmail if the user has no email.
+func (s *Service) SendPasswordSetup(ctx context.Context, actor *auth.Principal, id string) (err error) {
+	ctx, span := tracer.Start(ctx, "user.Service.SendPasswordSetup", trace.WithAttributes(tracing.KeyUserID.String(id)))
+	defer tracing.End(span, &err)
+
+	if err := auth.Authorize(actor, auth.PermUsersCredentials); err != nil {
+		return err
+	}
+
+	existing, err := s.storage.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := auth.AuthorizeCredentials(actor, auth.PermUsersUpdateOwn, id, existing.Role); err != nil {
+		return err
+	}
+	return s.sendPasswordToken(ctx, existing, PasswordSetup, PasswordSetupTTL)
+}
+
+// RequestPasswordReset sends a reset token to the active user with email.
+// It does not fail if there is no such user, so callers cannot find out
+// which emails are registered.
+func (s *Service) RequestPasswordReset(ctx context.Context, email string) (err error) {
+	ctx, span := tracer.Start(ctx, "user.Service.RequestPasswordReset")
+	defer tracing.End(span, &err)
+
+	existing, err := s.storage.GetByEmail(ctx, NormalizeEmail(email))
+	if errors.Is(err, ErrNotFound) {
+		s.log(ctx).Info("password reset requested for unknown email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.sendPasswordToken(ctx, existing, PasswordReset, PasswordResetTTL)
+}
+
+// ResetPassword redeems a setup or reset token and sets the new password.
+// Returns ErrInvalidPasswordToken if the token is unknown, expired or used.
+func (s *Service) ResetPassword(ctx context.Context, token, password string) (err error) {
+	ctx, span := tracer.Start(ctx, "user.Service.ResetPassword")
+	defer tracing.End(span, &err)
+
+	if _, err := HashPassword(password); err != nil {
+		return err // no gastar el token con una contraseña inválida
+	}
+	pt, err := s.tokens.consume(token)
+	if err != nil {
+		return err
+	}
+	existing, err := s.storage.Get(ctx, pt.userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrInvalidPasswordToken
+		}
+		return err
+	}
+	s.log(ctx).Info("password token redeemed", zap.String("id", existing.ID), zap.String("purpose", pt.purpose))
+	return s.setPassword(ctx, existing, password)
+}
+
+func (s *Service) setPassword(ctx context.Context, u *User, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = hash
+	u.UpdatedAt = time.Now()
+	u.Version++
+	return s.storage.Set(ctx, u)
+}
+
+func (s *Service) sendPasswordToken(ctx context.Context, u *User, purpose string, ttl time.Duration) error {
+	if u.Email == "" {
+		return ErrNoEmail
+	}
+	token, expiresAt, err := s.tokens.issue(u.ID, purpose, ttl)
+	if err != nil {
+		return err
+	}
+
+	subject := "Reset your password"
+	if purpose == PasswordSetup {
+		subject = "Choose your password"
+	}
+	err = s.notifier.Notify(notify.Message{
+		To:      u.Email,
+		Subject: subject,
+		Body:    "Use this token with POST /auth/password/reset before " + expiresAt.Format(time.RFC1123) + ": " + token,
+		Data:    map[string]string{"purpose": purpose, "token": token, "user_id": u.ID, "expires_at": expiresAt.Format(time.RFC3339)},
+		SentAt:  time.Now(),
+	})
+	if err != nil {
+		s.log(ctx).Error("failed to send password token", zap.Error(err), zap.String("id", u.ID))
+		return err
+	}
+	return nil
 }
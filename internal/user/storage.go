@@ -1,6 +1,15 @@
 package user
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"parte3/internal/health"
+	"parte3/internal/tracing"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
 
 // ErrNotFound is returned when a user with the given ID is not found.
 var ErrNotFound = errors.New("user not found")
@@ -8,60 +17,287 @@ var ErrNotFound = errors.New("user not found")
 // ErrEmptyID is returned when trying to store a user with an empty ID.
 var ErrEmptyID = errors.New("empty user ID")
 
+// ErrConflict is returned when a unique field is already used by another
+// active user. The returned error is a *ConflictError naming the field.
+var ErrConflict = errors.New("already in use")
+
+// ConflictError tells which unique field collided with another active user.
+type ConflictError struct {
+	Field string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Field + " is already in use"
+}
+
+// Is makes errors.Is(err, ErrConflict) true.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// scanCheckEvery is how many users a scan visits between checks of its context.
+const scanCheckEvery = 256
+
+// Campos únicos entre los usuarios activos, sin distinguir mayúsculas.
+const (
+	fieldEmail    = "email"
+	fieldNickName = "nickname"
+)
+
+// uniqueValues returns the values of the unique fields of u, in lower case.
+// Empty values are not unique.
+func uniqueValues(u *User) map[string]string {
+	values := map[string]string{}
+	if u.Email != "" {
+		values[fieldEmail] = strings.ToLower(u.Email)
+	}
+	if u.NickName != "" {
+		values[fieldNickName] = strings.ToLower(u.NickName)
+	}
+	return values
+}
+
 // LocalStorage provides an in-memory implementation for storing users.
+// It keeps an index of the unique fields of the active users, checked and
+// updated under the same lock as the write so two concurrent requests
+// cannot both take the same email or nickname.
+// Every method returns ctx.Err() once ctx is done, ListActive also checks it
+// while walking the users.
 type LocalStorage struct {
-	m map[string]*User
+	mu     sync.RWMutex
+	m      map[string]*User
+	unique map[string]map[string]string // field -> lower case value -> userID
 }
 
 // NewLocalStorage instantiates a new LocalStorage with an empty map.
 func NewLocalStorage() *LocalStorage {
 	return &LocalStorage{
 		m: map[string]*User{},
+		unique: map[string]map[string]string{
+			fieldEmail:    {},
+			fieldNickName: {},
+		},
 	}
 }
 
 // Set stores or updates a user in the local storage.
-// Returns ErrEmptyID if the user has an empty ID.
-func (l *LocalStorage) Set(user *User) error {
+// Returns ErrEmptyID if the user has an empty ID, and a *ConflictError if
+// the user is active and another active user has the same email or nickname.
+// The storage keeps its own copy, later changes to user are not visible
+// until Set is called again.
+func (l *LocalStorage) Set(ctx context.Context, user *User) error {
+	ctx, span := tracer.Start(ctx, "user.LocalStorage.Set", trace.WithAttributes(tracing.KeyUserID.String(user.ID)))
+	defer span.End()
+
 	if user.ID == "" {
 		return ErrEmptyID
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stored := *user
+	values := uniqueValues(&stored)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	l.m[user.ID] = user
+	if stored.Estado {
+		// orden fijo para que el campo informado sea siempre el mismo
+		for _, field := range []string{fieldEmail, fieldNickName} {
+			value, ok := values[field]
+			if !ok {
+				continue
+			}
+			if owner, taken := l.unique[field][value]; taken && owner != stored.ID {
+				return &ConflictError{Field: field}
+			}
+		}
+	}
+
+	l.unindex(stored.ID)
+	l.m[stored.ID] = &stored
+	if stored.Estado {
+		for field, value := range values {
+			l.unique[field][value] = stored.ID
+		}
+	}
 	return nil
 }
 
+// unindex removes the unique values of the stored user with the given ID.
+// Must be called with the write lock held.
+func (l *LocalStorage) unindex(id string) {
+	prev, ok := l.m[id]
+	if !ok {
+		return
+	}
+	for field, value := range uniqueValues(prev) {
+		if l.unique[field][value] == id {
+			delete(l.unique[field], value)
+		}
+	}
+}
+
 // Read retrieves a user from the local storage by ID.
 // Returns ErrNotFound if the user is not found.
-func (l *LocalStorage) Get(id string) (*User, error) {
+func (l *LocalStorage) Get(ctx context.Context, id string) (*User, error) {
+	ctx, span := tracer.Start(ctx, "user.LocalStorage.Get", trace.WithAttributes(tracing.KeyUserID.String(id)))
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
 	//lado izquierdo tipo de mapa (tipo mapa), booleano si existe o no en el mapa
 	u, ok := l.m[id]
 	if !ok || !u.Estado {
 		return nil, ErrNotFound
 	}
 
-	return u, nil
+	cp := *u
+	return &cp, nil
+}
+
+// GetAny retrieves a user by ID even if it was logically deleted.
+// Returns ErrNotFound if the user is not found.
+func (l *LocalStorage) GetAny(ctx context.Context, id string) (*User, error) {
+	ctx, span := tracer.Start(ctx, "user.LocalStorage.GetAny", trace.WithAttributes(tracing.KeyUserID.String(id)))
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	u, ok := l.m[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	cp := *u
+	return &cp, nil
+}
+
+// GetByEmail retrieves the active user with the given email, case insensitive.
+// Returns ErrNotFound if there is none.
+func (l *LocalStorage) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, span := tracer.Start(ctx, "user.LocalStorage.GetByEmail")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	id, ok := l.unique[fieldEmail][strings.ToLower(email)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	cp := *l.m[id]
+	return &cp, nil
 }
 
 // Delete removes a user from the local storage by ID.
 // Returns ErrNotFound if the user does not exist.
-func (l *LocalStorage) Delete(id string) error {
-	_, err := l.Get(id)
-	if err != nil {
+func (l *LocalStorage) Delete(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "user.LocalStorage.Delete", trace.WithAttributes(tracing.KeyUserID.String(id)))
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u, ok := l.m[id]
+	if !ok || !u.Estado {
+		return ErrNotFound
+	}
+
+	l.unindex(id)
 	delete(l.m, id) //eliminar keys de un mapa, parametro derecho que quiero eliminar, parametro lado izquierdo el mapa; elimina clave-valor
 	return nil
 }
 
-func (l *LocalStorage) ListActive() ([]*User, error) {
+// ListActive returns a copy of every active user.
+func (l *LocalStorage) ListActive(ctx context.Context) ([]*User, error) {
+	ctx, span := tracer.Start(ctx, "user.LocalStorage.ListActive")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
 	var activeUsers []*User
+	scanned := 0
 	for _, user := range l.m {
+		if scanned++; scanned%scanCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
 		if user.Estado {
-			activeUsers = append(activeUsers, user)
+			cp := *user
+			activeUsers = append(activeUsers, &cp)
 		}
 	}
 
 	return activeUsers, nil
 }
+
+// EachActive calls fn with a copy of every active user without building the
+// whole list. The users are collected under the read lock and fn runs
+// without it, so slow consumers (e.g. a CSV download) do not block writers.
+// It stops at the first error returned by fn or once ctx is done.
+func (l *LocalStorage) EachActive(ctx context.Context, fn func(*User) error) error {
+	ctx, span := tracer.Start(ctx, "user.LocalStorage.EachActive")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	var active []*User
+	scanned := 0
+	for _, user := range l.m {
+		if scanned++; scanned%scanCheckEvery == 0 && ctx.Err() != nil {
+			l.mu.RUnlock()
+			return ctx.Err()
+		}
+		if user.Estado {
+			active = append(active, user)
+		}
+	}
+	l.mu.RUnlock()
+
+	// Set guarda una copia nueva, los usuarios guardados no se modifican
+	for _, user := range active {
+		if err := ctx.Err(); err != nil {
+			return err // p. ej. el cliente cortó la descarga
+		}
+		cp := *user
+		if err := fn(&cp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check implements health.Checker, see health.Lock.
+func (l *LocalStorage) Check(ctx context.Context) error {
+	return health.Lock(ctx, l.mu.TryRLock, l.mu.RUnlock)
+}
@@ -0,0 +1,112 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ctx es el contexto de los tests, nunca se cancela.
+var ctx = context.Background()
+
+func TestLocalStorage_CamposUnicos(t *testing.T) {
+	storage := NewLocalStorage()
+	require.NoError(t, storage.Set(ctx, &User{ID: "a", NickName: "Ana", Email: "ana@example.com", Estado: true}))
+
+	// sin distinguir mayúsculas
+	err := storage.Set(ctx, &User{ID: "b", NickName: "ANA", Estado: true})
+	var ce *ConflictError
+	require.ErrorAs(t, err, &ce)
+	require.Equal(t, "nickname", ce.Field)
+	require.ErrorIs(t, err, ErrConflict)
+
+	err = storage.Set(ctx, &User{ID: "b", NickName: "bob", Email: "ANA@example.com", Estado: true})
+	require.ErrorAs(t, err, &ce)
+	require.Equal(t, "email", ce.Field)
+
+	// el mismo usuario puede guardarse de nuevo con sus valores
+	require.NoError(t, storage.Set(ctx, &User{ID: "a", NickName: "ana", Email: "ana@example.com", Estado: true}))
+
+	// un usuario borrado libera sus valores, y no puede volver si otro los tomó
+	require.NoError(t, storage.Set(ctx, &User{ID: "a", NickName: "ana", Email: "ana@example.com", Estado: false}))
+	require.NoError(t, storage.Set(ctx, &User{ID: "b", NickName: "Ana", Estado: true}))
+	err = storage.Set(ctx, &User{ID: "a", NickName: "ana", Email: "ana@example.com", Estado: true})
+	require.ErrorAs(t, err, &ce)
+	require.Equal(t, "nickname", ce.Field)
+}
+
+func TestLocalStorage_CamposUnicos_Concurrencia(t *testing.T) {
+	storage := NewLocalStorage()
+
+	var ok atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if storage.Set(ctx, &User{ID: fmt.Sprint(i), NickName: "mismo", Estado: true}) == nil {
+				ok.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), ok.Load())
+	users, err := storage.ListActive(ctx)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+}
+
+func TestLocalStorage_ContextoCancelado(t *testing.T) {
+	storage := NewLocalStorage()
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, storage.Set(ctx, &User{ID: fmt.Sprint(i), Estado: true}))
+	}
+
+	cancelado, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err := storage.ListActive(cancelado)
+	require.ErrorIs(t, err, context.Canceled)
+	_, err = storage.Get(cancelado, "1")
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorIs(t, storage.Set(cancelado, &User{ID: "nuevo", Estado: true}), context.Canceled)
+
+	// lo cancelado no se escribió
+	_, err = storage.Get(ctx, "nuevo")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLocalStorage_EachActive(t *testing.T) {
+	storage := NewLocalStorage()
+	require.NoError(t, storage.Set(ctx, &User{ID: "a", Estado: true}))
+	require.NoError(t, storage.Set(ctx, &User{ID: "b", Estado: false}))
+	require.NoError(t, storage.Set(ctx, &User{ID: "c", Estado: true}))
+
+	var ids []string
+	err := storage.EachActive(ctx, func(u *User) error {
+		u.Name = "cambiado" // es una copia
+		ids = append(ids, u.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a", "c"}, ids)
+	u, err := storage.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Empty(t, u.Name)
+
+	// se corta con el primer error
+	corte := errors.New("corte")
+	calls := 0
+	err = storage.EachActive(ctx, func(*User) error {
+		calls++
+		return corte
+	})
+	require.ErrorIs(t, err, corte)
+	require.Equal(t, 1, calls)
+}
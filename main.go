@@ -1,35 +1,155 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"regexp"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
 
 	//framework
 	"parte3/api"
+	"parte3/internal/config"
+	"parte3/internal/logging"
+	"parte3/internal/tracing"
 )
 
+// Códigos de salida del proceso.
+const (
+	exitOK     = 0
+	exitError  = 1 // el servidor falló o no terminó de drenar a tiempo
+	exitConfig = 2 // configuración inválida
+)
+
+// tracingFlushTimeout bounds the export of the pending spans on exit.
+const tracingFlushTimeout = 5 * time.Second
+
+// errDrainTimeout is returned by run when in-flight requests did not finish
+// within the shutdown timeout and their connections were closed.
+var errDrainTimeout = errors.New("in-flight requests did not finish in time")
+
 // Custom validation function for regexp
 func regexpValidation(fl validator.FieldLevel) bool {
 	value := fl.Field().String()
-	regex := regexp.MustCompile(`^[a-zA-Z]+(?: [a-zA-Z]+)*$`)
+	regex := regexp.MustCompile(api.NamePattern)
 	return regex.MatchString(value)
 }
+
 func main() {
-	r := gin.Default()
+	os.Exit(start())
+}
+
+// start runs the server until SIGINT or SIGTERM and returns the exit code.
+// It is separate from main so deferred calls run before os.Exit.
+func start() int {
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+
+	logger, err := cfg.Log.Logger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	defer logger.Sync() // se ejecuta al salir, después de drenar los pedidos
+	logging.SetPolicies(cfg.Log.Policies())
+
+	shutdownTracing, err := tracing.Setup(cfg.Tracing.Exporter, cfg.Tracing.File, cfg.Tracing.ServiceName, cfg.Tracing.SampleRatio)
+	if err != nil {
+		logger.Error("error trying to set up tracing", zap.Error(err))
+		return exitConfig
+	}
+	defer func() {
+		// los spans pendientes se exportan después de drenar los pedidos
+		ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("error trying to flush traces", zap.Error(err))
+		}
+	}()
+
+	gin.SetMode(cfg.Server.Mode)
+	r := gin.New()
+	r.Use(gin.Recovery()) // el access log lo escribe api, con el ID del pedido
 
 	// Register custom validation
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
 		v.RegisterValidation("regexp", regexpValidation)
 	}
 
-	api.InitRoutes(r)
+	api.InitRoutes(r, cfg, logger)
+
+	// un segundo Ctrl+C mata el proceso sin esperar: stop restaura las señales
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := net.Listen("tcp", cfg.Server.Addr)
+	if err != nil {
+		logger.Error("error trying to start server", zap.Error(err))
+		return exitError
+	}
+	logger.Info("server listening", zap.String("addr", ln.Addr().String()))
+
+	if err := run(ctx, ln, r, cfg.Server.ShutdownTimeout.Duration, logger, stop); err != nil {
+		logger.Error("server stopped with error", zap.Error(err))
+		return exitError
+	}
+	logger.Info("server stopped")
+	return exitOK
+}
 
-	if err := r.Run(":8080"); err != nil {
-		panic(fmt.Errorf("error trying to start server: %v", err))
+// run serves handler on ln until ctx is done, then stops accepting
+// connections and waits up to timeout for in-flight requests. onShutdown is
+// called as soon as the shutdown starts.
+// The memory storage has nothing to flush; persistent backends would be
+// closed here, after the last request finished.
+func run(ctx context.Context, ln net.Listener, handler http.Handler, timeout time.Duration, logger *zap.Logger, onShutdown func()) error {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err // Serve nunca devuelve nil
+	case <-ctx.Done():
+	}
+	onShutdown()
+	logger.Info("shutting down, draining in-flight requests", zap.Duration("timeout", timeout))
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		srv.Close()
+		if errors.Is(err, context.DeadlineExceeded) {
+			return errDrainTimeout
+		}
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
+	return nil
 }
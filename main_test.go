@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// servir levanta run en un puerto libre con un handler que tarda lo que diga
+// demora, y devuelve la URL, la función que simula la señal y el resultado.
+func servir(t *testing.T, demora, timeout time.Duration) (string, context.CancelFunc, <-chan error, chan struct{}) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	empezo := make(chan struct{}, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		empezo <- struct{}{}
+		time.Sleep(demora)
+		io.WriteString(w, "ok")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, ln, handler, timeout, zap.NewNop(), func() {})
+	}()
+	return "http://" + ln.Addr().String(), cancel, done, empezo
+}
+
+func TestRun_DrenaPedidosEnCurso(t *testing.T) {
+	url, señal, done, empezo := servir(t, 200*time.Millisecond, 5*time.Second)
+
+	respuesta := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			respuesta <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		respuesta <- string(body)
+	}()
+
+	<-empezo
+	señal()
+
+	// el pedido en curso termina bien aunque ya llegó la señal
+	require.Equal(t, "ok", <-respuesta)
+	require.NoError(t, <-done)
+
+	// y no se aceptan conexiones nuevas
+	_, err := http.Get(url)
+	require.Error(t, err)
+}
+
+func TestRun_TimeoutAlDrenar(t *testing.T) {
+	url, señal, done, empezo := servir(t, 2*time.Second, 50*time.Millisecond)
+
+	go http.Get(url)
+	<-empezo
+	señal()
+
+	require.ErrorIs(t, <-done, errDrainTimeout)
+}
@@ -2,14 +2,23 @@ package tests
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"parte3/api"
+	"parte3/internal/config"
 	"parte3/internal/sale"
+	"parte3/internal/tracing"
 	"parte3/internal/user"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
@@ -18,7 +27,18 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 // validación de main.go para que tome el regex
@@ -29,7 +49,16 @@ func regexpValidationTest(fl validator.FieldLevel) bool {
 	return regex.MatchString(value)
 }
 
-func setupRouter() *gin.Engine {
+// testAdminKey es la clave de admin con la que se autentican los tests.
+const testAdminKey = "sk_test_admin_key"
+
+func setupEngine() *gin.Engine {
+	return setupEngineConLogger(zap.NewNop())
+}
+
+// setupEngineConLogger es setupEngine con el logger dado, para los tests
+// que leen los logs.
+func setupEngineConLogger(logger *zap.Logger) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
 
@@ -38,10 +67,32 @@ func setupRouter() *gin.Engine {
 		v.RegisterValidation("regexp", regexpValidationTest)
 	}
 
-	api.InitRoutes(router) // inicializar tus servicios y rutas
+	os.Setenv("API_BOOTSTRAP_KEY", testAdminKey)
+	cfg, err := config.Load(nil, os.Getenv)
+	if err != nil {
+		panic(err)
+	}
+	api.InitRoutes(router, cfg, logger) // inicializar tus servicios y rutas
 	return router
 }
 
+// conClave agrega la clave de admin a las requests que no traen credenciales.
+type conClave struct {
+	http.Handler
+}
+
+func (c conClave) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" && r.Header.Get("X-API-Key") == "" {
+		r.Header.Set("Authorization", "Bearer "+testAdminKey)
+	}
+	c.Handler.ServeHTTP(w, r)
+}
+
+// setupRouter devuelve el router autenticado como admin.
+func setupRouter() http.Handler {
+	return conClave{setupEngine()}
+}
+
 // generarString crea una cadena aleatoria de letras de una longitud dada.
 func generarString(length int) string {
 	rand.Seed(time.Now().UnixNano())
@@ -55,7 +106,7 @@ func generarString(length int) string {
 
 // crearUsuarioforTest es una función auxiliar para crear un usuario vía API y devolver su ID.
 // Asegura que el nombre de usuario cumpla con la regexp `^[a-zA-Z]+(?: [a-zA-Z]+)*$`.
-func crearUsuarioforTest(t *testing.T, router *gin.Engine) string {
+func crearUsuarioforTest(t *testing.T, router http.Handler) string {
 	userName := "TestUser" + generarString(4) // ej., TestUserXyzAbc
 	userPayload := gin.H{
 		"name":    userName,
@@ -196,3 +247,1170 @@ func HappyPath_PostPatchGet(t *testing.T) {
 	}
 	t.Logf("Venta recuperada exitosamente con estado: %s y verificada.", retrievedSale.Status)
 }
+
+// TestIdempotencyKey_RepiteRespuesta verifica que un POST reintentado con la misma
+// Idempotency-Key devuelva la respuesta original en lugar de crear otro recurso.
+func TestIdempotencyKey_RepiteRespuesta(t *testing.T) {
+	router := setupRouter()
+
+	post := func(key string, payload gin.H) *httptest.ResponseRecorder {
+		body, err := json.Marshal(payload)
+		require.NoError(t, err)
+		req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	payload := gin.H{"name": "Idempotent User", "address": "25 de mayo 1234"}
+	first := post("clave-1", payload)
+	require.Equal(t, http.StatusCreated, first.Code)
+
+	retry := post("clave-1", payload)
+	require.Equal(t, http.StatusCreated, retry.Code)
+	require.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	require.JSONEq(t, first.Body.String(), retry.Body.String())
+
+	other := post("clave-1", gin.H{"name": "Other User", "address": "25 de mayo 1234"})
+	require.Equal(t, http.StatusUnprocessableEntity, other.Code)
+
+	// los errores de validación también se repiten, no se vuelve a ejecutar el handler
+	invalid := post("clave-2", gin.H{"name": "Sin Direccion"})
+	require.Equal(t, http.StatusBadRequest, invalid.Code)
+	require.Equal(t, http.StatusBadRequest, post("clave-2", gin.H{"name": "Sin Direccion"}).Code)
+
+	// el cuerpo se lee con un tope
+	grande, _ := http.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"`+strings.Repeat("a", 2<<20)+`"}`))
+	grande.Header.Set("Content-Type", "application/json")
+	grande.Header.Set("Idempotency-Key", "clave-grande")
+	demasiado := httptest.NewRecorder()
+	router.ServeHTTP(demasiado, grande)
+	require.Equal(t, http.StatusRequestEntityTooLarge, demasiado.Code, demasiado.Body.String())
+
+	fresh := post("clave-3", payload)
+	require.Equal(t, http.StatusCreated, fresh.Code)
+	require.NotEqual(t, first.Body.String(), fresh.Body.String())
+
+	// si el cliente se fue (499) la clave se libera y el reintento crea el usuario
+	cancelado, cancel := context.WithCancel(context.Background())
+	cancel()
+	body, err := json.Marshal(payload)
+	require.NoError(t, err)
+	req, _ := http.NewRequestWithContext(cancelado, http.MethodPost, "/users", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", "clave-4")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, 499, rr.Code, rr.Body.String())
+
+	retry = post("clave-4", payload)
+	require.Equal(t, http.StatusCreated, retry.Code, retry.Body.String())
+	require.Empty(t, retry.Header().Get("Idempotent-Replayed"))
+}
+
+// TestBatch_UsuariosYVentas prueba los modos all_or_nothing y best_effort de los endpoints batch.
+func TestBatch_UsuariosYVentas(t *testing.T) {
+	router := setupRouter()
+
+	send := func(path, contentType, body string) (*httptest.ResponseRecorder, map[string]any) {
+		req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var resp map[string]any
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp), rr.Body.String())
+		return rr, resp
+	}
+	listUsers := func() int {
+		req, _ := http.NewRequest(http.MethodGet, "/users", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var users []map[string]any
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &users))
+		return len(users)
+	}
+
+	users := `[{"name":"Ana","address":"Calle 1"},{"name":"Bob 2","address":"Calle 2"}]`
+	rr, resp := send("/users:batch", "application/json", users)
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	require.EqualValues(t, 0, resp["created"])
+	results := resp["results"].([]any)
+	require.Equal(t, "aborted", results[0].(map[string]any)["code"])
+	require.Equal(t, "validation_failed", results[1].(map[string]any)["code"])
+	require.Equal(t, 0, listUsers())
+
+	rr, resp = send("/users:batch?mode=best_effort", "application/json", users)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.EqualValues(t, 1, resp["created"])
+	require.EqualValues(t, 1, resp["failed"])
+	require.Equal(t, 1, listUsers())
+
+	userID := resp["results"].([]any)[0].(map[string]any)["data"].(map[string]any)["id"].(string)
+	sales := fmt.Sprintf("{\"user_id\":%q,\"amount\":10}\n{\"user_id\":%q,\"amount\":20}\n", userID, userID)
+	rr, resp = send("/sales:batch", "application/x-ndjson", sales)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	require.EqualValues(t, 2, resp["created"])
+
+	sales = fmt.Sprintf("{\"user_id\":%q,\"amount\":10}\n{\"user_id\":\"no-existe\",\"amount\":20}\n", userID)
+	rr, resp = send("/sales:batch", "application/x-ndjson", sales)
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	results = resp["results"].([]any)
+	require.Equal(t, "user_not_found", results[1].(map[string]any)["code"])
+
+	req, _ := http.NewRequest(http.MethodGet, "/sales/"+userID, nil)
+	rrSales := httptest.NewRecorder()
+	router.ServeHTTP(rrSales, req)
+	var getResponse struct {
+		Metadata *sale.Metadata `json:"metadata"`
+	}
+	require.NoError(t, json.Unmarshal(rrSales.Body.Bytes(), &getResponse))
+	require.Equal(t, 2, getResponse.Metadata.Quantity)
+}
+
+// TestCSV_ImportarYExportar importa usuarios y ventas desde CSV, descarga el
+// reporte de errores y exporta las ventas filtradas.
+func TestCSV_ImportarYExportar(t *testing.T) {
+	router := setupRouter()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/csv")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// la dirección de Ana ocupa dos líneas del archivo
+	users := "Nombre,Direccion,nickname\nAna,\"Calle 1\nPiso 2\",ana\nBob 2,Calle 2,\n"
+	rr := do(http.MethodPost, "/users/import?mode=best_effort&map=Nombre:name,Direccion:address", users)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var resp struct {
+		Created     int    `json:"created"`
+		Failed      int    `json:"failed"`
+		ErrorReport string `json:"error_report"`
+		Results     []struct {
+			Data user.User `json:"data"`
+		} `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, 1, resp.Created)
+	require.Equal(t, 1, resp.Failed)
+	require.NotEmpty(t, resp.ErrorReport)
+
+	rr = do(http.MethodGet, resp.ErrorReport, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "4,validation_failed")
+	require.Contains(t, rr.Body.String(), "Bob 2,Calle 2")
+
+	// el reporte solo lo descarga quien subió el archivo
+	conOtraClave := func(scope string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/auth/keys", strings.NewReader(fmt.Sprintf(`{"name":"otra","scopes":[%q]}`, scope)))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var issued struct {
+			Key string `json:"key"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &issued))
+		req, _ = http.NewRequest(http.MethodGet, resp.ErrorReport, nil)
+		req.Header.Set("Authorization", "Bearer "+issued.Key)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	require.Equal(t, http.StatusForbidden, conOtraClave("users:read").Code)
+	require.Equal(t, http.StatusNotFound, conOtraClave("users:write").Code)
+
+	userID := resp.Results[0].Data.ID
+	rr = do(http.MethodPost, "/sales/import", fmt.Sprintf("user_id,amount\n%s,10.5\n%s,20\n", userID, userID))
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	rr = do(http.MethodGet, "/sales.csv?user_id="+userID, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	require.Len(t, lines, 3)
+	require.Equal(t, "id,user_id,amount,status,created_at,updated_at,version,approvers", lines[0])
+
+	rr = do(http.MethodGet, "/users.csv?include=sales_summary", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Contains(t, records[0], "sales_quantity")
+	require.Contains(t, strings.Join(records[1], ","), ",2,")
+
+	// lo que una planilla correría como fórmula se exporta como texto
+	rr = do(http.MethodPost, "/users/import", "name,address\nEva,@SUM(A1)\n")
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	rr = do(http.MethodGet, "/users.csv", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), ",'@SUM(A1),")
+	require.NotContains(t, rr.Body.String(), ",@SUM(A1),")
+}
+
+// TestNegociacion_Formatos prueba los formatos de request y response distintos de JSON.
+func TestNegociacion_Formatos(t *testing.T) {
+	router := setupRouter()
+
+	do := func(method, path, contentType, accept string, body []byte) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// alta de usuario con XML, respuesta en XML
+	rr := do(http.MethodPost, "/users", "application/xml", "application/xml",
+		[]byte(`<user><name>Xml User</name><address>Calle 1</address></user>`))
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var created struct {
+		ID   string `xml:"id"`
+		Name string `xml:"name"`
+	}
+	require.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &created))
+	require.Equal(t, "Xml User", created.Name)
+
+	// alta de usuario con CSV
+	rr = do(http.MethodPost, "/users", "text/csv", "", []byte("name,address\nCsv User,Calle 2\n"))
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var csvUser user.User
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &csvUser))
+
+	// modificación parcial con CSV, las celdas vacías no cambian el campo
+	rr = do(http.MethodPatch, "/users/"+csvUser.ID, "text/csv", "", []byte("nickname,address,name\nCsvy,Calle 3,Csv User\n"))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	rr = do(http.MethodPatch, "/users/"+csvUser.ID, "text/csv", "", []byte("nickname,address,name\n,Calle 4,Csv User\n"))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &csvUser))
+	require.Equal(t, "Csvy", csvUser.NickName)
+	require.Equal(t, []user.Address{{Label: user.AddressHome, Street: "Calle", Number: "4"}}, csvUser.Addresses)
+
+	// alta de venta con MessagePack, respuesta en MessagePack
+	var body []byte
+	mh := new(codec.MsgpackHandle)
+	require.NoError(t, codec.NewEncoderBytes(&body, mh).Encode(map[string]any{"user_id": created.ID, "amount": 25.5}))
+	rr = do(http.MethodPost, "/sales", "application/x-msgpack", "application/x-msgpack", body)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var createdSale sale.Sale
+	require.NoError(t, codec.NewDecoderBytes(rr.Body.Bytes(), mh).Decode(&createdSale))
+	require.Equal(t, created.ID, createdSale.UserID)
+
+	// listados en NDJSON y CSV
+	rr = do(http.MethodGet, "/users", "", "application/x-ndjson", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, strings.Split(strings.TrimSpace(rr.Body.String()), "\n"), 2)
+
+	rr = do(http.MethodGet, "/sales/"+created.ID, "", "text/csv", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.True(t, strings.HasPrefix(rr.Body.String(), "id,user_id,amount,status"))
+
+	rr = do(http.MethodGet, "/sales/"+created.ID, "", "application/xml", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "<sales><metadata>")
+
+	// formatos no soportados
+	rr = do(http.MethodGet, "/users/"+created.ID, "", "text/plain", nil)
+	require.Equal(t, http.StatusNotAcceptable, rr.Code)
+	rr = do(http.MethodPost, "/users", "text/plain", "", []byte("hola"))
+	require.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+}
+
+// TestAuth_APIKeys prueba la autenticación y la administración de API keys.
+func TestAuth_APIKeys(t *testing.T) {
+	router := setupEngine()
+
+	do := func(method, path, key string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(http.MethodGet, "/users", "", "")
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	require.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/ping", "", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/users", "sk_inventada", "").Code)
+
+	rr = do(http.MethodPost, "/auth/keys", testAdminKey, `{"name":"reportes","scopes":["users:read"]}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var issued struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &issued))
+	require.NotEmpty(t, issued.Key)
+
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/users", issued.Key, "").Code)
+	rr = do(http.MethodPost, "/users", issued.Key, `{"name":"Ana","address":"Calle 1"}`)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodPost, "/users:batch", issued.Key, `[]`).Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodGet, "/auth/keys", issued.Key, "").Code)
+
+	rr = do(http.MethodGet, "/auth/keys", testAdminKey, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NotContains(t, rr.Body.String(), issued.Key)
+	require.Contains(t, rr.Body.String(), "last_used_at")
+
+	rr = do(http.MethodPost, "/auth/keys/"+issued.ID+"/rotate", testAdminKey, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var rotated struct {
+		Key string `json:"key"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rotated))
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/users", issued.Key, "").Code)
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/users", rotated.Key, "").Code)
+
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/auth/keys/"+issued.ID, testAdminKey, "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/users", rotated.Key, "").Code)
+	require.Equal(t, http.StatusConflict, do(http.MethodDelete, "/auth/keys/"+issued.ID, testAdminKey, "").Code)
+}
+
+// TestAuth_TokenJWT canjea una API key por un JWT y lo usa como bearer token.
+func TestAuth_TokenJWT(t *testing.T) {
+	router := setupEngine()
+
+	do := func(method, path, bearer, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(http.MethodPost, "/auth/token", "", fmt.Sprintf(`{"grant_type":"client_credentials","client_secret":%q}`, testAdminKey))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		Scope       string `json:"scope"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &token))
+	require.Equal(t, "Bearer", token.TokenType)
+	require.Equal(t, "admin", token.Scope)
+
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/users", token.AccessToken, "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/users", token.AccessToken+"x", "").Code)
+
+	rr = do(http.MethodPost, "/auth/token", "", `{"grant_type":"client_credentials","client_secret":"sk_inventada"}`)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = do(http.MethodGet, "/.well-known/jwks.json", "", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), `"kty":"OKP"`)
+	require.NotContains(t, rr.Body.String(), `"d":`)
+
+	// después de rotar, los tokens firmados con la clave anterior siguen valiendo
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/auth/jwks/rotate", testAdminKey, "").Code)
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/users", token.AccessToken, "").Code)
+
+	// los tokens de una API key dejan de valer cuando la clave se rota o se revoca
+	canjear := func(secret string) string {
+		rr := do(http.MethodPost, "/auth/token", "", fmt.Sprintf(`{"grant_type":"client_credentials","client_secret":%q}`, secret))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var token struct {
+			AccessToken string `json:"access_token"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &token))
+		return token.AccessToken
+	}
+	rr = do(http.MethodPost, "/auth/keys", testAdminKey, `{"name":"maquina","scopes":["users:read"]}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var issued struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &issued))
+	viejo := canjear(issued.Key)
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/users", viejo, "").Code)
+
+	rr = do(http.MethodPost, "/auth/keys/"+issued.ID+"/rotate", testAdminKey, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &issued))
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/users", viejo, "").Code)
+	nuevo := canjear(issued.Key)
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/users", nuevo, "").Code)
+
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/auth/keys/"+issued.ID, testAdminKey, "").Code)
+	rr = do(http.MethodGet, "/users", nuevo, "")
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	require.Contains(t, rr.Body.String(), "api key revoked")
+}
+
+// escribirJWKS crea un archivo JWKS con una clave HS256 conocida, para que
+// los tests puedan firmar tokens de usuarios como lo haría otro emisor.
+func escribirJWKS(t *testing.T) []byte {
+	secret := []byte("clave-de-prueba-de-32-bytes-minimo!!")
+	path := t.TempDir() + "/jwks.json"
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"oct","kid":"test","alg":"HS256","k":%q}]}`, base64.RawURLEncoding.EncodeToString(secret))
+	require.NoError(t, os.WriteFile(path, []byte(jwks), 0o600))
+	t.Setenv("JWT_JWKS_FILE", path)
+	return secret
+}
+
+// tokenDeUsuario firma un token para el usuario con el rol dado.
+func tokenDeUsuario(t *testing.T, secret []byte, userID, role string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss":      "parte3",
+		"sub":      userID,
+		"sub_type": "user",
+		"role":     role,
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(secret)
+	require.NoError(t, err)
+	return signed
+}
+
+// TestAuth_TokenDeUsuarioBorrado reusa el token de un usuario después de borrarlo.
+func TestAuth_TokenDeUsuarioBorrado(t *testing.T) {
+	secret := escribirJWKS(t)
+	router := setupRouter()
+
+	get := func(path, bearer string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	ana := crearUsuarioforTest(t, router)
+	token := tokenDeUsuario(t, secret, ana, "customer")
+	require.Equal(t, http.StatusOK, get("/users/"+ana, token).Code)
+
+	req, _ := http.NewRequest(http.MethodDelete, "/users/"+ana, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = get("/users", token)
+	require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+	require.Contains(t, rr.Body.String(), "no longer exists")
+}
+
+// TestRBAC_Roles prueba la matriz de permisos de cada rol.
+func TestRBAC_Roles(t *testing.T) {
+	secret := escribirJWKS(t)
+	router := setupRouter()
+
+	do := func(method, path, bearer, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// el rol sale del usuario guardado, no del token
+	ana := crearUsuarioforTest(t, router)
+	bob := crearUsuarioforTest(t, router)
+	carla := crearUsuarioforTest(t, router)
+	require.Equal(t, http.StatusOK, do(http.MethodPatch, "/users/"+bob, "", `{"address":"Calle 1","role":"approver"}`).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPatch, "/users/"+carla, "", `{"address":"Calle 1","role":"seller"}`).Code)
+	customer := tokenDeUsuario(t, secret, ana, "customer")
+	approver := tokenDeUsuario(t, secret, bob, "approver")
+	seller := tokenDeUsuario(t, secret, carla, "seller")
+
+	// un cliente solo ve su usuario y sus ventas
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/users/"+ana, customer, "").Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodGet, "/users/"+bob, customer, "").Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodGet, "/users", customer, "").Code)
+	rr := do(http.MethodPost, "/sales", customer, fmt.Sprintf(`{"user_id":%q,"amount":10}`, ana))
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var created sale.Sale
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	require.Equal(t, http.StatusForbidden, do(http.MethodPost, "/sales", customer, fmt.Sprintf(`{"user_id":%q,"amount":10}`, bob)).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/sales/"+ana, customer, "").Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodGet, "/sales/"+bob, customer, "").Code)
+
+	// el comprador no puede aprobar su venta, el aprobador sí
+	patch := `{"status":"approved"}`
+	require.Equal(t, http.StatusForbidden, do(http.MethodPatch, "/sales/"+created.ID, customer, patch).Code)
+	rr = do(http.MethodPatch, "/sales/"+created.ID, approver, patch)
+	require.NotEqual(t, http.StatusForbidden, rr.Code, rr.Body.String())
+
+	// solo los admins asignan roles, borran y restauran usuarios
+	require.Equal(t, http.StatusForbidden, do(http.MethodPatch, "/users/"+ana, customer, `{"address":"Calle 2","role":"admin"}`).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPatch, "/users/"+ana, customer, `{"address":"Calle 2"}`).Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/users/"+ana, seller, "").Code)
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/users/"+ana, "", "").Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, "/users/"+ana, "", "").Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodPost, "/users/"+ana+"/restore", seller, "").Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/users/"+ana+"/restore", "", "").Code)
+	require.Equal(t, http.StatusConflict, do(http.MethodPost, "/users/"+ana+"/restore", "", "").Code)
+
+	rr = do(http.MethodPatch, "/users/"+ana, "", `{"address":"Calle 3","role":"approver"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Contains(t, rr.Body.String(), `"role":"approver"`)
+
+	// degradar o borrar un usuario invalida lo que dicen sus tokens
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/users/"+ana, approver, "").Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPatch, "/users/"+bob, "", `{"address":"Calle 1","role":"customer"}`).Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodGet, "/users/"+ana, approver, "").Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodGet, "/users", tokenDeUsuario(t, secret, bob, "admin"), "").Code)
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/users/"+ana, "", "").Code)
+	rr = do(http.MethodGet, "/users/"+ana, customer, "")
+	require.Equal(t, http.StatusUnauthorized, rr.Code, rr.Body.String())
+}
+
+// ultimoToken lee el último token de contraseña enviado al archivo de notificaciones.
+func ultimoToken(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var msg struct {
+		Data map[string]string `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &msg))
+	require.NotEmpty(t, msg.Data["token"])
+	return msg.Data["token"]
+}
+
+// TestPasswords_LoginCambioYReseteo prueba el ciclo de vida de una contraseña.
+func TestPasswords_LoginCambioYReseteo(t *testing.T) {
+	notifications := t.TempDir() + "/notifications.jsonl"
+	t.Setenv("NOTIFY_FILE", notifications)
+	router := setupRouter()
+
+	do := func(method, path, bearer, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	login := func(email, password string) *httptest.ResponseRecorder {
+		return do(http.MethodPost, "/auth/token", "", fmt.Sprintf(`{"grant_type":"password","username":%q,"password":%q}`, email, password))
+	}
+
+	rr := do(http.MethodPost, "/users", "", `{"name":"Ana","address":"Calle 1","email":"Ana@Example.com","password":"primera-clave"}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	require.NotContains(t, rr.Body.String(), "password")
+	var ana user.User
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ana))
+	require.Equal(t, "ana@example.com", ana.Email)
+
+	// el email es único
+	rr = do(http.MethodPost, "/users", "", `{"name":"Otra","address":"Calle 2","email":"ANA@example.com"}`)
+	require.Equal(t, http.StatusConflict, rr.Code)
+
+	// login con la contraseña
+	require.Equal(t, http.StatusUnauthorized, login("ana@example.com", "incorrecta").Code)
+	require.Equal(t, http.StatusUnauthorized, login("nadie@example.com", "primera-clave").Code)
+	rr = login("ANA@example.com", "primera-clave")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &tok))
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/users/"+ana.ID, tok.AccessToken, "").Code)
+
+	// cambiar la propia contraseña exige la actual
+	require.Equal(t, http.StatusForbidden, do(http.MethodPut, "/users/"+ana.ID+"/password", tok.AccessToken, `{"current_password":"mal","new_password":"segunda-clave"}`).Code)
+	require.Equal(t, http.StatusNoContent, do(http.MethodPut, "/users/"+ana.ID+"/password", tok.AccessToken, `{"current_password":"primera-clave","new_password":"segunda-clave"}`).Code)
+	require.Equal(t, http.StatusUnauthorized, login("ana@example.com", "primera-clave").Code)
+	require.Equal(t, http.StatusOK, login("ana@example.com", "segunda-clave").Code)
+
+	// reseteo con un token de un solo uso
+	require.Equal(t, http.StatusAccepted, do(http.MethodPost, "/auth/password/forgot", "", `{"email":"nadie@example.com"}`).Code)
+	require.Equal(t, http.StatusAccepted, do(http.MethodPost, "/auth/password/forgot", "", `{"email":"ana@example.com"}`).Code)
+	token := ultimoToken(t, notifications)
+	require.Equal(t, http.StatusNoContent, do(http.MethodPost, "/auth/password/reset", "", fmt.Sprintf(`{"token":%q,"password":"tercera-clave"}`, token)).Code)
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/auth/password/reset", "", fmt.Sprintf(`{"token":%q,"password":"cuarta-clave"}`, token)).Code)
+	require.Equal(t, http.StatusOK, login("ana@example.com", "tercera-clave").Code)
+
+	// un admin invita a elegir contraseña a un usuario creado sin ella
+	rr = do(http.MethodPost, "/users", "", `{"name":"Bob","address":"Calle 3","email":"bob@example.com"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var bob user.User
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &bob))
+	require.Equal(t, http.StatusUnauthorized, login("bob@example.com", "").Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodPost, "/users/"+bob.ID+"/password/setup", tok.AccessToken, "").Code)
+	require.Equal(t, http.StatusAccepted, do(http.MethodPost, "/users/"+bob.ID+"/password/setup", "", "").Code)
+	token = ultimoToken(t, notifications)
+	require.Equal(t, http.StatusNoContent, do(http.MethodPost, "/auth/password/reset", "", fmt.Sprintf(`{"token":%q,"password":"clave-de-bob"}`, token)).Code)
+	require.Equal(t, http.StatusOK, login("bob@example.com", "clave-de-bob").Code)
+
+	// una key users:write modifica usuarios pero no toma la cuenta de un admin
+	require.Equal(t, http.StatusOK, do(http.MethodPatch, "/users/"+ana.ID, "", `{"address":"Calle 1","role":"admin"}`).Code)
+	rr = do(http.MethodPost, "/auth/keys", "", `{"name":"altas","scopes":["users:write"]}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var writeKey struct {
+		Key string `json:"key"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &writeKey))
+	require.Equal(t, http.StatusForbidden, do(http.MethodPut, "/users/"+ana.ID+"/password", writeKey.Key, `{"new_password":"robada-clave"}`).Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodPatch, "/users/"+ana.ID, writeKey.Key, `{"address":"Calle 1","email":"atacante@example.com"}`).Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodPost, "/users/"+ana.ID+"/password/setup", writeKey.Key, "").Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPatch, "/users/"+ana.ID, writeKey.Key, `{"address":"Calle 4"}`).Code)
+	require.Equal(t, http.StatusUnauthorized, login("ana@example.com", "robada-clave").Code)
+	require.Equal(t, http.StatusOK, login("ana@example.com", "tercera-clave").Code)
+}
+
+// TestUsuarios_CamposUnicos prueba que email y nickname no se repitan entre usuarios activos.
+func TestUsuarios_CamposUnicos(t *testing.T) {
+	router := setupRouter()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	conflicto := func(rr *httptest.ResponseRecorder, field string) {
+		t.Helper()
+		require.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+		require.Contains(t, rr.Body.String(), fmt.Sprintf(`"field":%q`, field))
+	}
+
+	rr := do(http.MethodPost, "/users", `{"name":"Ana","address":"Calle 1","nickname":"ana","email":"ana@example.com"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var ana user.User
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ana))
+
+	conflicto(do(http.MethodPost, "/users", `{"name":"Otra","address":"Calle 2","nickname":"ANA"}`), "nickname")
+	conflicto(do(http.MethodPost, "/users", `{"name":"Otra","address":"Calle 2","email":"Ana@Example.com"}`), "email")
+
+	rr = do(http.MethodPost, "/users", `{"name":"Bob","address":"Calle 3","nickname":"bob"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var bob user.User
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &bob))
+	conflicto(do(http.MethodPatch, "/users/"+bob.ID, `{"address":"Calle 3","nickname":"Ana"}`), "nickname")
+
+	// al borrar a Ana su nickname queda libre, y ya no puede restaurarse
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/users/"+ana.ID, "").Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPatch, "/users/"+bob.ID, `{"address":"Calle 3","nickname":"Ana"}`).Code)
+	conflicto(do(http.MethodPost, "/users/"+ana.ID+"/restore", ""), "nickname")
+}
+
+// TestUsuarios_Direcciones prueba las direcciones estructuradas y el formato viejo.
+func TestUsuarios_Direcciones(t *testing.T) {
+	router := setupRouter()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// el texto viejo se sigue aceptando y se interpreta
+	rr := do(http.MethodPost, "/users", `{"name":"Ana","address":"Av. Corrientes 1234, CABA, C1043AAZ, Argentina"}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var ana user.User
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ana))
+	require.Equal(t, []user.Address{{
+		Label: "home", Street: "Av. Corrientes", Number: "1234", City: "CABA",
+		PostalCode: "C1043AAZ", Country: "AR",
+	}}, ana.Addresses)
+
+	// direcciones estructuradas con etiquetas
+	rr = do(http.MethodPatch, "/users/"+ana.ID, `{"address":{"label":"shipping","street":"Bv. Oroño","number":"500","city":"Rosario","province":"Santa Fe","postal_code":"S2000ABC","country":"AR"}}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ana))
+	require.Len(t, ana.Addresses, 2)
+	require.Equal(t, "shipping", ana.Addresses[1].Label)
+
+	// las reglas de cada país devuelven el campo inválido
+	rr = do(http.MethodPatch, "/users/"+ana.ID, `{"address":{"label":"billing","street":"Bv. Oroño","city":"Rosario","province":"Santa Fe","postal_code":"C2000ABC","country":"AR"}}`)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Contains(t, rr.Body.String(), `"field":"addresses[2].postal_code"`)
+
+	rr = do(http.MethodPost, "/users", `{"name":"Bob","addresses":[{"street":"Calle 1"},{"label":"home","street":"Calle 2"}]}`)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Contains(t, rr.Body.String(), `"field":"addresses[1].label"`)
+
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/users", `{"name":"Bob"}`).Code)
+
+	// addresses reemplaza todas
+	rr = do(http.MethodPatch, "/users/"+ana.ID, `{"addresses":[{"label":"billing","street":"Market St","number":"1","city":"San Francisco","province":"ca","postal_code":"94105","country":"US"}]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ana))
+	require.Equal(t, []user.Address{{
+		Label: "billing", Street: "Market St", Number: "1", City: "San Francisco",
+		Province: "CA", PostalCode: "94105", Country: "US",
+	}}, ana.Addresses)
+}
+
+// TestRateLimit_PorClienteYRuta prueba los límites de pedidos por cliente.
+func TestRateLimit_PorClienteYRuta(t *testing.T) {
+	router := setupRouter()
+	do := func(method, path, bearer, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "10.0.0.1:1234"
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// el endpoint de tokens se limita por IP
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < 10; i++ {
+		rr = do(http.MethodPost, "/auth/token", "", `{"grant_type":"client_credentials","client_secret":"sk_invalida"}`)
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+	require.Equal(t, "10", rr.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	rr = do(http.MethodPost, "/auth/token", "", `{"grant_type":"client_credentials","client_secret":"sk_invalida"}`)
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Equal(t, "6", rr.Header().Get("Retry-After"))
+	require.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+
+	// sin proxies de confianza X-Forwarded-For no cambia el bucket
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(`{"grant_type":"client_credentials","client_secret":"sk_invalida"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i))
+		req.Header.Set("X-Real-IP", fmt.Sprintf("198.51.100.%d", i))
+		req.RemoteAddr = "10.0.0.1:1234"
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	}
+
+	// POST /sales tiene su propio bucket por clave
+	userID := crearUsuarioforTest(t, router)
+	sale := fmt.Sprintf(`{"user_id":%q,"amount":10}`, userID)
+	for i := 0; i < 20; i++ {
+		require.Equal(t, http.StatusCreated, do(http.MethodPost, "/sales", testAdminKey, sale).Code)
+	}
+	rr = do(http.MethodPost, "/sales", testAdminKey, sale)
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	// otra clave desde la misma IP no está limitada, ni el resto de las rutas
+	rr = do(http.MethodPost, "/auth/keys", testAdminKey, `{"name":"otra","scopes":["sales:write","sales:read"]}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var issued struct {
+		Key string `json:"key"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &issued))
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/sales", issued.Key, sale).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/sales/"+userID, testAdminKey, "").Code)
+}
+
+// TestConfig_Funcionalidades prueba que las funcionalidades apagadas no respondan.
+func TestConfig_Funcionalidades(t *testing.T) {
+	t.Setenv("APP_FEATURE_BATCH", "false")
+	t.Setenv("APP_FEATURE_CSV", "false")
+	t.Setenv("APP_FEATURE_PASSWORD_GRANT", "false")
+	router := setupRouter()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusNotFound, do(http.MethodPost, "/users:batch", `[{"name":"Ana","address":"Calle 1"}]`).Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, "/users.csv", "").Code)
+	rr := do(http.MethodPost, "/auth/token", `{"grant_type":"password","username":"ana@example.com","password":"una-clave"}`)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Contains(t, rr.Body.String(), "unsupported_grant_type")
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/users", `{"name":"Ana","address":"Calle 1"}`).Code)
+}
+
+func TestHealth_Probes(t *testing.T) {
+	type reporte struct {
+		Status string `json:"status"`
+		Checks []struct {
+			Name      string  `json:"name"`
+			Kind      string  `json:"kind"`
+			Status    string  `json:"status"`
+			LatencyMS float64 `json:"latency_ms"`
+			Error     string  `json:"error"`
+		} `json:"checks"`
+	}
+	probar := func(router http.Handler, path string) (int, reporte) {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var r reporte
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &r), rr.Body.String())
+		return rr.Code, r
+	}
+	nombres := func(r reporte) map[string]string {
+		m := map[string]string{}
+		for _, c := range r.Checks {
+			m[c.Name] = c.Status
+		}
+		return m
+	}
+
+	// sin credenciales, los probes son públicos
+	router := setupEngine()
+	code, live := probar(router, "/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "up", live.Status)
+	require.Equal(t, "up", nombres(live)["users.storage"])
+	require.Equal(t, "up", nombres(live)["sales.storage"])
+	for _, c := range live.Checks {
+		require.Equal(t, "liveness", c.Kind)
+	}
+
+	code, ready := probar(router, "/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "up", ready.Status)
+	require.Contains(t, nombres(ready), "idempotency.storage")
+
+	// si no se pueden entregar los tokens de contraseña no está lista, pero sigue viva
+	t.Setenv("NOTIFY_FILE", filepath.Join(t.TempDir(), "no-existe", "notify.jsonl"))
+	router = setupEngine()
+	code, _ = probar(router, "/healthz")
+	require.Equal(t, http.StatusOK, code)
+	code, ready = probar(router, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "down", ready.Status)
+	require.Equal(t, "down", nombres(ready)["users.notifier"])
+	require.Equal(t, "up", nombres(ready)["users.storage"])
+}
+
+func TestMetrics_Prometheus(t *testing.T) {
+	engine := setupEngine()
+	router := conClave{engine} // el mismo engine, para leer sus métricas sin clave
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// sin credenciales no se pueden leer
+	req, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+	rr := httptest.NewRecorder()
+	engine.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	ana := crearUsuarioforTest(t, router)
+	beto := crearUsuarioforTest(t, router)
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/users/"+beto, "").Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, "/users/"+beto, "").Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, "/no-existe/"+beto, "").Code)
+
+	// el estado inicial es aleatorio: se crean ventas hasta tener una pendiente
+	var pendiente string
+	creadas := 0
+	for i := 0; i < 50 && pendiente == ""; i++ {
+		rr := do(http.MethodPost, "/sales", `{"user_id":"`+ana+`","amount":250}`)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		creadas++
+		var s sale.Sale
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &s))
+		if s.Status == "pending" {
+			pendiente = s.ID
+		}
+	}
+	require.NotEmpty(t, pendiente)
+	require.Equal(t, http.StatusOK, do(http.MethodPatch, "/sales/"+pendiente, `{"status":"approved"}`).Code)
+
+	rr = do(http.MethodGet, "/metrics", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Header().Get("Content-Type"), "text/plain")
+	text := rr.Body.String()
+
+	require.Contains(t, text, "users_created_total 2\n")
+	require.Contains(t, text, "users_deleted_total 1\n")
+	require.Contains(t, text, `sale_transitions_total{from="pending",to="approved"} 1`)
+	require.Contains(t, text, `sales_created_total{status="pending"}`)
+	require.Contains(t, text, `sale_amount_count{status="pending"} `)
+	total := 0
+	for _, status := range []string{"pending", "approved", "rejected"} {
+		var n int
+		re := regexp.MustCompile(`sales_created_total\{status="` + status + `"\} (\d+)`)
+		if m := re.FindStringSubmatch(text); m != nil {
+			fmt.Sscan(m[1], &n)
+		}
+		total += n
+	}
+	require.Equal(t, creadas, total)
+
+	// por plantilla de ruta, nunca por el path con el ID
+	require.Contains(t, text, `http_requests_total{method="GET",route="/users/:id",status="404"} 1`)
+	require.Contains(t, text, `http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	require.Contains(t, text, `http_requests_total{method="GET",route="/metrics",status="401"} 1`)
+	require.Contains(t, text, `http_request_duration_seconds_count{method="PATCH",route="/sales/:id",status="200"} 1`)
+	require.NotContains(t, text, beto)
+}
+
+func TestRequestID_LogsCorrelacionados(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zap.InfoLevel)
+	engine := gin.New()
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterValidation("regexp", regexpValidationTest)
+	}
+	os.Setenv("API_BOOTSTRAP_KEY", testAdminKey)
+	cfg, err := config.Load(nil, os.Getenv)
+	require.NoError(t, err)
+	api.InitRoutes(engine, cfg, zap.New(core))
+	router := conClave{engine}
+
+	post := func(id string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/sales", strings.NewReader(`{"user_id":"no-existe","amount":10}`))
+		req.Header.Set("Content-Type", "application/json")
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// el ID del cliente se devuelve y aparece en los logs del servicio y en el access log
+	rr := post("pedido-123")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.Equal(t, "pedido-123", rr.Header().Get("X-Request-ID"))
+
+	porID := logs.FilterField(zap.String("request_id", "pedido-123"))
+	require.Equal(t, 1, porID.FilterMessage("user not found for sale").Len(), "warning de sale.Service")
+	access := porID.FilterMessage("request").All()
+	require.Len(t, access, 1)
+	campos := access[0].ContextMap()
+	require.Equal(t, "POST", campos["method"])
+	require.Equal(t, "/sales", campos["route"])
+	require.EqualValues(t, http.StatusNotFound, campos["status"])
+	require.Equal(t, "api_key", campos["sub_type"])
+
+	// sin ID, o con uno inválido, se genera uno nuevo
+	rr = post("")
+	generado := rr.Header().Get("X-Request-ID")
+	require.Len(t, generado, 36)
+	require.Equal(t, 1, logs.FilterField(zap.String("request_id", generado)).FilterMessage("request").Len())
+
+	rr = post("con espacios\ny saltos")
+	require.NotContains(t, rr.Header().Get("X-Request-ID"), " ")
+	require.Len(t, rr.Header().Get("X-Request-ID"), 36)
+}
+
+func TestPanic_AccessLogYMetricas(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zap.InfoLevel)
+	engine := gin.New()
+	engine.Use(gin.RecoveryWithWriter(io.Discard)) // como main
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterValidation("regexp", regexpValidationTest)
+	}
+	os.Setenv("API_BOOTSTRAP_KEY", testAdminKey)
+	cfg, err := config.Load(nil, os.Getenv)
+	require.NoError(t, err)
+	api.InitRoutes(engine, cfg, zap.New(core))
+	engine.GET("/explota", func(*gin.Context) { panic("boom") })
+	router := conClave{engine}
+
+	req, _ := http.NewRequest(http.MethodGet, "/explota", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+
+	// la línea de acceso se escribe aunque el handler no vuelva
+	access := logs.FilterMessage("request").FilterField(zap.String("route", "/explota")).All()
+	require.Len(t, access, 1)
+	require.Equal(t, zap.ErrorLevel, access[0].Level)
+	require.EqualValues(t, http.StatusInternalServerError, access[0].ContextMap()["status"])
+	require.Equal(t, "boom", access[0].ContextMap()["panic"])
+
+	req, _ = http.NewRequest(http.MethodGet, "/metrics", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), `http_requests_total{method="GET",route="/explota",status="500"} 1`)
+}
+
+func TestTimeout_504(t *testing.T) {
+	t.Setenv("APP_REQUEST_TIMEOUT", "1ns") // vence antes de llegar al storage
+	router := setupRouter()
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(http.MethodGet, "/users/cualquiera")
+	require.Equal(t, http.StatusGatewayTimeout, rr.Code, rr.Body.String())
+	require.Contains(t, rr.Body.String(), "deadline exceeded")
+	require.Equal(t, http.StatusGatewayTimeout, do(http.MethodGet, "/users").Code)
+
+	// lo que no pasa por los servicios no tiene por qué vencer
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/ping").Code)
+}
+
+func TestTimeout_CrearVenta(t *testing.T) {
+	t.Setenv("APP_REQUEST_TIMEOUT", "1ns")
+	// un logger que codifica los campos, como el de producción
+	var logs bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&logs), zap.InfoLevel)
+	router := conClave{setupEngineConLogger(zap.New(core))}
+
+	// el error del servicio se contesta y se loguea, no hay venta que loguear
+	req, _ := http.NewRequest(http.MethodPost, "/sales", strings.NewReader(`{"user_id":"cualquiera","amount":10}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusGatewayTimeout, rr.Code, rr.Body.String())
+	require.Contains(t, rr.Body.String(), "deadline exceeded")
+	require.Contains(t, logs.String(), "error creating sale")
+	require.NotContains(t, logs.String(), "sale created successfully")
+}
+
+func TestTracing_Spans(t *testing.T) {
+	// el provider global se instala una sola vez: los tracers de los paquetes
+	// quedan delegando en el primero que se registra
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	router := setupRouter()
+	userID := crearUsuarioforTest(t, router)
+
+	// el trace ID del cliente llega por traceparent y se continúa
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req, _ := http.NewRequest(http.MethodPost, "/sales", strings.NewReader(fmt.Sprintf(`{"user_id":%q,"amount":10}`, userID)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var venta sale.Sale
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &venta))
+
+	porNombre := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range spans.Ended() {
+		if s.SpanContext().TraceID().String() == traceID {
+			porNombre[s.Name()] = s
+		}
+	}
+	for _, nombre := range []string{"/sales", "sale.Service.Create", "sale.Service.Validate", "user.Service.Get", "user.LocalStorage.Get", "sale.LocalStorage.Set"} {
+		require.Contains(t, porNombre, nombre)
+	}
+	atributos := func(s sdktrace.ReadOnlySpan) map[attribute.Key]string {
+		m := map[attribute.Key]string{}
+		for _, kv := range s.Attributes() {
+			m[kv.Key] = kv.Value.Emit()
+		}
+		return m
+	}
+
+	// el span HTTP es hijo del span remoto y padre del servicio
+	httpSpan := porNombre["/sales"]
+	require.Equal(t, "00f067aa0ba902b7", httpSpan.Parent().SpanID().String())
+	create := porNombre["sale.Service.Create"]
+	require.Equal(t, httpSpan.SpanContext().SpanID(), create.Parent().SpanID())
+	require.Equal(t, create.SpanContext().SpanID(), porNombre["sale.Service.Validate"].Parent().SpanID())
+	require.Equal(t, porNombre["sale.Service.Validate"].SpanContext().SpanID(), porNombre["user.Service.Get"].Parent().SpanID())
+	require.Equal(t, porNombre["user.Service.Get"].SpanContext().SpanID(), porNombre["user.LocalStorage.Get"].Parent().SpanID())
+
+	attrs := atributos(create)
+	require.Equal(t, userID, attrs[tracing.KeyUserID])
+	require.Equal(t, venta.ID, attrs[tracing.KeySaleID])
+	require.Equal(t, venta.Status, attrs[tracing.KeySaleStatus])
+	require.Equal(t, venta.ID, atributos(porNombre["sale.LocalStorage.Set"])[tracing.KeySaleID])
+
+	// los errores quedan registrados en el span del servicio
+	req, _ = http.NewRequest(http.MethodGet, "/users/no-existe", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	var get sdktrace.ReadOnlySpan
+	for _, s := range spans.Ended() {
+		if s.Name() == "user.Service.Get" && atributos(s)[tracing.KeyUserID] == "no-existe" {
+			get = s
+		}
+	}
+	require.NotNil(t, get)
+	require.Equal(t, codes.Error, get.Status().Code)
+}
+
+func TestOpenAPI_Rutas(t *testing.T) {
+	engine := setupEngine()
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, path, nil) // público, sin credenciales
+		rr := httptest.NewRecorder()
+		engine.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/openapi.json")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var spec struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Required   []string                   `json:"required"`
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+	require.Equal(t, "3.1.0", spec.OpenAPI)
+
+	// cada ruta registrada está documentada y el documento no tiene rutas de más
+	documentadas := map[string]bool{}
+	for path, item := range spec.Paths {
+		for method := range item {
+			documentadas[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	registradas := map[string]bool{"POST /users:batch": true, "POST /sales:batch": true}
+	param := regexp.MustCompile(`:([a-z_]+)`)
+	for _, r := range engine.Routes() {
+		registradas[r.Method+" "+param.ReplaceAllString(r.Path, "{$1}")] = true
+	}
+	for route := range registradas {
+		require.True(t, documentadas[route], "ruta sin documentar: %s", route)
+	}
+	for route := range documentadas {
+		require.True(t, registradas[route], "ruta documentada que no existe: %s", route)
+	}
+
+	// los bindings de los requests quedan como validaciones del schema
+	venta := spec.Components.Schemas["CreateSaleRequest"]
+	require.ElementsMatch(t, []string{"user_id", "amount"}, venta.Required)
+	require.JSONEq(t, `{"type":"number","exclusiveMinimum":0}`, string(venta.Properties["amount"]))
+	require.JSONEq(t, `{"type":"string","enum":["approved","rejected"]}`, string(spec.Components.Schemas["UpdateSale"].Properties["status"]))
+	require.Contains(t, spec.Components.Schemas["UpdateFields"].Properties, "role")
+
+	rr = get("/docs")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "/openapi.json")
+	// el script de la página se sirve desde el binario, nunca de un CDN
+	require.Contains(t, rr.Body.String(), `src="/docs/docs.js"`)
+	require.NotContains(t, rr.Body.String(), "https://")
+	rr = get("/docs/docs.js")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "text/javascript; charset=utf-8", rr.Header().Get("Content-Type"))
+	require.Contains(t, rr.Body.String(), "data-spec-url")
+}